@@ -0,0 +1,130 @@
+package ban
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+	Ban list entries. An entry is one of:
+
+	name#Gateway     exactly that user
+	troll*#Azeroth   a glob, where `*` matches any run of characters, and
+	                 `?` any one character
+	troll#*          the user on any gateway, which is also a glob
+	/^troll\d+#/     a regular expression, between slashes
+
+	Case is ignored. An entry starting with `!` is an exemption: users it
+	matches are not banned by any other entry.
+*/
+
+const _EXEMPT = "!"
+const _REGEXP = "/"
+const _GLOB = "*?"
+
+type Rule struct {
+	Entry  string
+	Exempt bool
+
+	exact  string /* upper case, when not a pattern */
+	regexp *regexp.Regexp
+}
+
+func isRegexp(entry string) bool {
+	return len(entry) > 2 && strings.HasPrefix(entry, _REGEXP) && strings.HasSuffix(entry, _REGEXP)
+}
+
+/* Whether the entry matches more than one user, or is an exemption */
+func IsPattern(entry string) bool {
+	if strings.HasPrefix(entry, _EXEMPT) {
+		return true
+	}
+
+	return isRegexp(entry) || strings.ContainsAny(entry, _GLOB)
+}
+
+/*
+	How an entry is stored: upper case, so names compare without case,
+	except for regular expressions, where case has a meaning, e.g. `\d`
+*/
+func Key(entry string) string {
+	if isRegexp(strings.TrimPrefix(entry, _EXEMPT)) {
+		return entry
+	}
+
+	return strings.ToUpper(entry)
+}
+
+func Parse(entry string) (*Rule, error) {
+	rule := &Rule{Entry: entry, Exempt: strings.HasPrefix(entry, _EXEMPT)}
+	pattern := strings.TrimPrefix(entry, _EXEMPT)
+
+	switch {
+	case len(pattern) == 0:
+		return nil, fmt.Errorf("Ban '%s' is empty.", entry)
+	case isRegexp(pattern):
+		re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Ban '%s' is not a valid regular expression: %v", entry, err)
+		}
+		rule.regexp = re
+	case strings.ContainsAny(pattern, _GLOB):
+		var expr strings.Builder
+		for _, r := range pattern {
+			switch r {
+			case '*':
+				expr.WriteString(".*")
+			case '?':
+				expr.WriteString(".")
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		rule.regexp = regexp.MustCompile("(?i)^" + expr.String() + "$")
+	default:
+		rule.exact = strings.ToUpper(pattern)
+	}
+
+	return rule, nil
+}
+
+func (r *Rule) Matches(name string) bool {
+	if r.regexp != nil {
+		return r.regexp.MatchString(name)
+	}
+
+	return strings.ToUpper(name) == r.exact
+}
+
+/*
+	Sort rules into the order `Find` tries them: exemptions, then exact
+	names, then patterns, each in order of their entry, so the same list
+	always decides the same way.
+*/
+func Sort(rules []*Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Exempt != rules[j].Exempt {
+			return rules[i].Exempt
+		}
+		if (rules[i].regexp == nil) != (rules[j].regexp == nil) {
+			return rules[i].regexp == nil
+		}
+		return rules[i].Entry < rules[j].Entry
+	})
+}
+
+/*
+	The rule that decides whether `name` is banned, and whether it is: the
+	first of `rules`, sorted with `Sort`, that matches. Nil if none does.
+*/
+func Find(rules []*Rule, name string) (*Rule, bool) {
+	for _, rule := range rules {
+		if rule.Matches(name) {
+			return rule, !rule.Exempt
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,89 @@
+package ban
+
+import (
+	"testing"
+)
+
+func mustParse(t *testing.T, entries ...string) []*Rule {
+	var rules []*Rule
+	for _, entry := range entries {
+		rule, err := Parse(entry)
+		if err != nil {
+			t.Fatalf("Could not parse '%s': %v", entry, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+func TestMatches(t *testing.T) {
+	for _, test := range []struct {
+		entry   string
+		name    string
+		matches bool
+	}{
+		{"Troll#Azeroth", "troll#azeroth", true},
+		{"Troll#Azeroth", "Troll1#Azeroth", false},
+		{"troll*#Azeroth", "TROLL42#Azeroth", true},
+		{"troll*#Azeroth", "troll42#Lordaeron", false},
+		{"troll?#*", "troll7#Northrend", true},
+		{"troll?#*", "troll77#Northrend", false},
+		{"troll#*", "Troll#Lordaeron", true},
+		{"troll.#*", "trollX#Azeroth", false},
+		{`/^troll\d+#/`, "Troll123#Azeroth", true},
+		{`/^troll\d+#/`, "TrollFace#Azeroth", false},
+		{"!troll#*", "troll#Azeroth", true},
+	} {
+		rule := mustParse(t, test.entry)[0]
+		if rule.Matches(test.name) != test.matches {
+			t.Errorf("%s: Expected: %s matches %v, Actual: %v",
+				test.entry, test.name, test.matches, !test.matches)
+		}
+	}
+
+	for _, invalid := range []string{"", "!", `/troll(/`} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Expected: error parsing '%s', Actual: nil", invalid)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	rules := mustParse(t, "troll*#*", "!TrollHunter#Azeroth", "Troll1#Azeroth", `/^trolling$/`)
+	Sort(rules)
+
+	for _, test := range []struct {
+		name   string
+		entry  string
+		banned bool
+	}{
+		{"troll1#azeroth", "Troll1#Azeroth", true},
+		{"troll2#Azeroth", "troll*#*", true},
+		{"TrollHunter#Azeroth", "!TrollHunter#Azeroth", false},
+		{"trolling", `/^trolling$/`, true},
+	} {
+		rule, banned := Find(rules, test.name)
+		if rule == nil || rule.Entry != test.entry || banned != test.banned {
+			t.Errorf("%s: Expected: %s (banned %v), Actual: %+v (banned %v)",
+				test.name, test.entry, test.banned, rule, banned)
+		}
+	}
+
+	if rule, banned := Find(rules, "Peon#Azeroth"); rule != nil || banned {
+		t.Errorf("Expected: no rule, Actual: %+v", rule)
+	}
+}
+
+func TestKey(t *testing.T) {
+	if key := Key("troll*#azeroth"); key != "TROLL*#AZEROTH" {
+		t.Errorf("Expected: TROLL*#AZEROTH, Actual: %s", key)
+	}
+	if key := Key(`!/^troll\d/`); key != `!/^troll\d/` {
+		t.Errorf(`Expected: !/^troll\d/, Actual: %s`, key)
+	}
+	if IsPattern("Troll#Azeroth") || !IsPattern("troll#*") || !IsPattern("/troll/") ||
+		!IsPattern("!Troll#Azeroth") {
+		t.Errorf("Expected: only globs, regular expressions and exemptions to be patterns")
+	}
+}
@@ -0,0 +1,160 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	A minimal cron expression parser. Expressions have five space separated
+	fields: minute, hour, day of month, month, and day of week (0 is
+	Sunday). Each field is `*`, a number, a range `a-b`, a step `*\/n`,
+	`a-b/n` or `a/n` (from a to the end of the range), or a comma
+	separated list of those.
+
+	As with cron, when both day of month and day of week are restricted, a
+	time matches if either does.
+*/
+
+type field struct {
+	min int
+	max int
+}
+
+var _FIELDS = []field{
+	{0, 59}, /* minute */
+	{0, 23}, /* hour */
+	{1, 31}, /* day of month */
+	{1, 12}, /* month */
+	{0, 6},  /* day of week */
+}
+
+var _FIELD_NAMES = []string{"minute", "hour", "day of month", "month", "day of week"}
+
+/* Give up looking for the next match after this long */
+const _MAX_SEARCH = time.Duration(366*24) * time.Hour
+
+type Schedule struct {
+	expr   string
+	fields [5]map[int]bool
+	domAny bool
+	dowAny bool
+}
+
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+func Parse(expr string) (*Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(_FIELDS) {
+		return nil, fmt.Errorf(
+			"Invalid cron expression '%s'. Expected %d fields, got %d.",
+			expr, len(_FIELDS), len(parts))
+	}
+
+	schedule := &Schedule{
+		expr:   strings.Join(parts, " "),
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}
+
+	for i, part := range parts {
+		values, err := parseField(part, _FIELDS[i])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cron expression '%s'. Bad %s: %v",
+				expr, _FIELD_NAMES[i], err)
+		}
+
+		schedule.fields[i] = values
+	}
+
+	return schedule, nil
+}
+
+func parseField(part string, f field) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, item := range strings.Split(part, ",") {
+		lo, hi, step := f.min, f.max, 1
+		stepped := false
+
+		if i := strings.Index(item, "/"); i != -1 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step '%s'", item)
+			}
+			step = n
+			stepped = true
+			item = item[:i]
+		}
+
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			a, errA := strconv.Atoi(bounds[0])
+			b, errB := strconv.Atoi(bounds[1])
+			if errA != nil || errB != nil || a > b {
+				return nil, fmt.Errorf("invalid range '%s'", item)
+			}
+			lo, hi = a, b
+		default:
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", item)
+			}
+			lo, hi = n, n
+			if stepped {
+				hi = f.max
+			}
+		}
+
+		if lo < f.min || lo > f.max || hi > f.max {
+			return nil, fmt.Errorf("'%s' is out of range %d-%d", item, f.min, f.max)
+		}
+
+		for n := lo; n <= hi; n += step {
+			values[n] = true
+		}
+	}
+
+	return values, nil
+}
+
+func (s *Schedule) matches(t time.Time) bool {
+	if !s.fields[0][t.Minute()] || !s.fields[1][t.Hour()] ||
+		!s.fields[3][int(t.Month())] {
+		return false
+	}
+
+	dom := s.fields[2][t.Day()]
+	dow := s.fields[4][int(t.Weekday())]
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+/* The first time after `t` that matches, or the zero time if none does */
+func (s *Schedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(_MAX_SEARCH)
+
+	for ; next.Before(end); next = next.Add(time.Minute) {
+		if s.matches(next) {
+			return next
+		}
+	}
+
+	return time.Time{}
+}
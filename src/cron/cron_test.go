@@ -0,0 +1,75 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, expr string) *Schedule {
+	schedule, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	return schedule
+}
+
+func assertNext(t *testing.T, expr string, from time.Time, expected time.Time) {
+	actual := mustParse(t, expr).Next(from)
+	if !actual.Equal(expected) {
+		t.Errorf("%s: Expected: %v, Actual: %v", expr, expected, actual)
+	}
+}
+
+/* A Wednesday */
+var _TEST_TIME = time.Date(2026, time.October, 14, 12, 30, 45, 0, time.UTC)
+
+func TestNextEveryMinute(t *testing.T) {
+	assertNext(t, "* * * * *", _TEST_TIME,
+		time.Date(2026, time.October, 14, 12, 31, 0, 0, time.UTC))
+}
+
+func TestNextStep(t *testing.T) {
+	assertNext(t, "*/15 * * * *", _TEST_TIME,
+		time.Date(2026, time.October, 14, 12, 45, 0, 0, time.UTC))
+}
+
+func TestNextStepFromStart(t *testing.T) {
+	/* 5, 15, 25... as with cron, not just 5 */
+	assertNext(t, "5/10 * * * *", _TEST_TIME,
+		time.Date(2026, time.October, 14, 12, 35, 0, 0, time.UTC))
+}
+
+func TestNextDayOfWeek(t *testing.T) {
+	/* Friday at 20:00 */
+	assertNext(t, "0 20 * * 5", _TEST_TIME,
+		time.Date(2026, time.October, 16, 20, 0, 0, 0, time.UTC))
+}
+
+func TestNextDayOfMonthOrWeek(t *testing.T) {
+	/* The 1st of the month, or any Thursday */
+	assertNext(t, "0 9 1 * 4", _TEST_TIME,
+		time.Date(2026, time.October, 15, 9, 0, 0, 0, time.UTC))
+}
+
+func TestNextListAndRange(t *testing.T) {
+	assertNext(t, "0,30 8-10 * * 1-5", _TEST_TIME,
+		time.Date(2026, time.October, 15, 8, 0, 0, 0, time.UTC))
+}
+
+func TestNextNoMatch(t *testing.T) {
+	if next := mustParse(t, "0 0 31 2 *").Next(_TEST_TIME); !next.IsZero() {
+		t.Errorf("February 31st should never match, but got: %v", next)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 7", "*/0 * * * *", "5-1 * * * *", "a * * * *", "60/5 * * * *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected an error parsing '%s', but got nil.", expr)
+		}
+	}
+}
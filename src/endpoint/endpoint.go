@@ -0,0 +1,149 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+/*
+	Where the bot connects to, and how it does the websocket handshake.
+	Each version of the chat bot API has its own endpoint. The handshake
+	can be given subprotocols to offer, and extra headers to send, for
+	endpoints that require them.
+
+	With version `auto`, the versions are tried oldest first, until one
+	completes the handshake. Only v1 is known to work, so it goes first.
+*/
+
+const V1 = "v1"
+const V2 = "v2"
+const V3 = "v3"
+const AUTO = "auto"
+
+const _HOST = "wss://connect-bot.classic.blizzard.com"
+
+/* Oldest first */
+var _VERSIONS = []string{V1, V2, V3}
+
+/* Headers the websocket library sets itself */
+var _RESERVED_HEADERS = []string{
+	"Upgrade",
+	"Connection",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol",
+}
+
+type Config struct {
+	Version      string /* one of V1, V2, V3 or AUTO; V1 if empty */
+	URL          string /* in place of the version's endpoint */
+	Subprotocols []string
+	Headers      map[string]string
+}
+
+type Endpoint struct {
+	Version      string
+	URL          string
+	Subprotocols []string
+	Header       http.Header
+}
+
+/* The endpoint of `version`, with the default handshake */
+func Default(version string) Endpoint {
+	return Endpoint{
+		Version: version,
+		URL:     fmt.Sprintf("%s/%s/rpc/chat", _HOST, version),
+	}
+}
+
+func known(version string) bool {
+	for _, v := range _VERSIONS {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c Config) version() string {
+	if len(c.Version) == 0 {
+		return V1
+	}
+
+	return c.Version
+}
+
+func (c Config) Validate() error {
+	version := c.version()
+	if version != AUTO && !known(version) {
+		return fmt.Errorf("Version '%s' must be one of %s, %s.",
+			c.Version, strings.Join(_VERSIONS, ", "), AUTO)
+	}
+
+	if len(c.URL) > 0 {
+		if version == AUTO {
+			return fmt.Errorf("An endpoint url needs a version, not %s.", AUTO)
+		}
+
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return fmt.Errorf("Endpoint is not a valid url: %v", err)
+		}
+		if u.Scheme != "wss" || len(u.Host) == 0 {
+			return fmt.Errorf("Endpoint '%s' must be a wss:// url.", c.URL)
+		}
+	}
+
+	for _, protocol := range c.Subprotocols {
+		if len(protocol) == 0 || strings.ContainsAny(protocol, " ,\t") {
+			return fmt.Errorf("Subprotocol '%s' must be a single token.", protocol)
+		}
+	}
+
+	for name := range c.Headers {
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if len(name) == 0 || strings.ContainsAny(name, " :\t") {
+			return fmt.Errorf("Header '%s' is not a valid header name.", name)
+		}
+		for _, reserved := range _RESERVED_HEADERS {
+			if canonical == reserved {
+				return fmt.Errorf("Header '%s' is set by the handshake itself.", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+/* The endpoints to try, in order */
+func (c Config) Endpoints() []Endpoint {
+	versions := []string{c.version()}
+	if c.version() == AUTO {
+		versions = _VERSIONS
+	}
+
+	var endpoints []Endpoint
+	for _, version := range versions {
+		endpoint := Default(version)
+		if len(c.URL) > 0 {
+			endpoint.URL = c.URL
+		}
+
+		endpoint.Subprotocols = c.Subprotocols
+		if len(c.Headers) > 0 {
+			endpoint.Header = make(http.Header)
+			for name, value := range c.Headers {
+				endpoint.Header.Set(name, value)
+			}
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints
+}
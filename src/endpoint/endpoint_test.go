@@ -0,0 +1,68 @@
+package endpoint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, valid := range []Config{
+		{},
+		{Version: V3},
+		{Version: AUTO},
+		{Version: V2, URL: "wss://localhost:8443/v2/rpc/chat"},
+		{Subprotocols: []string{"json"}, Headers: map[string]string{"User-Agent": "peonbot"}},
+	} {
+		if err := valid.Validate(); err != nil {
+			t.Errorf("Expected: %+v to be valid, Actual: %v", valid, err)
+		}
+	}
+
+	for _, invalid := range []Config{
+		{Version: "v4"},
+		{Version: AUTO, URL: "wss://localhost:8443/v2/rpc/chat"},
+		{URL: "https://localhost:8443/v1/rpc/chat"},
+		{URL: "ws://localhost:8080/v1/rpc/chat"},
+		{URL: "wss:///v1/rpc/chat"},
+		{Subprotocols: []string{"json, v3"}},
+		{Subprotocols: []string{""}},
+		{Headers: map[string]string{"sec-websocket-protocol": "json"}},
+		{Headers: map[string]string{"Bad Header": "x"}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected: error for %+v, Actual: nil", invalid)
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	endpoints := Config{}.Endpoints()
+	if len(endpoints) != 1 || endpoints[0].URL != "wss://connect-bot.classic.blizzard.com/v1/rpc/chat" {
+		t.Errorf("Expected: v1 endpoint, Actual: %+v", endpoints)
+	}
+
+	endpoints = Config{
+		Version:      AUTO,
+		Subprotocols: []string{"json"},
+		Headers:      map[string]string{"x-api-version": "3"},
+	}.Endpoints()
+
+	var versions []string
+	for _, endpoint := range endpoints {
+		versions = append(versions, endpoint.Version)
+		if !strings.HasSuffix(endpoint.URL, "/"+endpoint.Version+"/rpc/chat") {
+			t.Errorf("Expected: %s's endpoint, Actual: %s", endpoint.Version, endpoint.URL)
+		}
+		if endpoint.Header.Get("X-Api-Version") != "3" || endpoint.Subprotocols[0] != "json" {
+			t.Errorf("Expected: handshake with subprotocol and header, Actual: %+v", endpoint)
+		}
+	}
+	if strings.Join(versions, " ") != "v1 v2 v3" {
+		t.Errorf("Expected: v1 v2 v3, Actual: %s", strings.Join(versions, " "))
+	}
+
+	endpoints = Config{Version: V2, URL: "wss://localhost:8443/chat"}.Endpoints()
+	if len(endpoints) != 1 || endpoints[0].URL != "wss://localhost:8443/chat" || endpoints[0].Version != V2 {
+		t.Errorf("Expected: v2 at the configured url, Actual: %+v", endpoints)
+	}
+}
@@ -0,0 +1,118 @@
+package federation
+
+import (
+	"fmt"
+	"peonbot/ban"
+	"strings"
+	"time"
+)
+
+/*
+	A shared ban feed. Bots subscribe to feeds to learn about bans issued in
+	other channels, and publish their own bans to them. A feed is an append
+	only log of ban and unban entries, stored either in a local json file or
+	behind an http endpoint (see `Handler`).
+*/
+
+const ACTION_BAN = "ban"
+const ACTION_UNBAN = "unban"
+
+type Entry struct {
+	User   string    `json:"user"`   /* name#Gateway */
+	Action string    `json:"action"` /* ban or unban */
+	Source string    `json:"source"` /* who issued it */
+	Time   time.Time `json:"time"`
+}
+
+type Feed struct {
+	Entries []Entry `json:"entries"`
+}
+
+func NewEntry(user string, action string, source string) Entry {
+	return Entry{
+		User:   user,
+		Action: action,
+		Source: source,
+		Time:   time.Now().UTC(),
+	}
+}
+
+func (e Entry) validate() error {
+	if !strings.Contains(e.User, "#") {
+		return fmt.Errorf("Invalid feed entry. User must be of the form name#Gateway: %+v", e)
+	}
+
+	/* Feeds share bans of users, not patterns that could match anyone */
+	if ban.IsPattern(e.User) {
+		return fmt.Errorf("Invalid feed entry. User must not be a pattern: %+v", e)
+	}
+
+	if e.Action != ACTION_BAN && e.Action != ACTION_UNBAN {
+		return fmt.Errorf("Invalid feed entry. Unknown action: %+v", e)
+	}
+
+	if len(e.Source) == 0 {
+		return fmt.Errorf("Invalid feed entry. Missing source: %+v", e)
+	}
+
+	return nil
+}
+
+type Store interface {
+	Fetch() ([]Entry, error)
+	Publish(Entry) error
+	String() string
+}
+
+/*
+	An http(s) url is fetched from over http, anything else is a file path.
+	`secret` signs entries published over http.
+*/
+func NewStore(location string, secret string) Store {
+	if IsUrl(location) {
+		return newHttpStore(location, secret)
+	}
+
+	return newFileStore(location)
+}
+
+/* Whether the feed at `location` is fetched over http */
+func IsUrl(location string) bool {
+	lower := strings.ToLower(location)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+/*
+	How much a bot trusts a feed:
+	  watch    - entries are only logged
+	  standard - bans are applied, unbans only lift bans from the same source
+	  full     - bans are applied, unbans lift a ban from any source
+	Bans from a bot's own config are never lifted by a feed.
+*/
+type Trust int
+
+const (
+	TrustWatch Trust = iota
+	TrustStandard
+	TrustFull
+)
+
+var _TRUST_NAMES = map[string]Trust{
+	"WATCH":    TrustWatch,
+	"STANDARD": TrustStandard,
+	"FULL":     TrustFull,
+}
+
+/* An empty string is standard trust */
+func ParseTrust(trust string) (Trust, error) {
+	if len(trust) == 0 {
+		return TrustStandard, nil
+	}
+
+	if t, ok := _TRUST_NAMES[strings.ToUpper(trust)]; ok {
+		return t, nil
+	}
+
+	return TrustWatch, fmt.Errorf(
+		"Unknown trust level '%s'. Expected watch, standard, or full.", trust)
+}
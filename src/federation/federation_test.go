@@ -0,0 +1,148 @@
+package federation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const _TEST_USER = "Troll#Azeroth"
+const _TEST_SOURCE = "clan"
+const _TEST_SECRET = "a-long-random-string"
+
+func assertRoundTrip(t *testing.T, store Store) {
+	entries, err := store.Fetch()
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Expected an empty feed, but got: %+v", entries)
+	}
+
+	if err := store.Publish(NewEntry(_TEST_USER, ACTION_BAN, _TEST_SOURCE)); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if err := store.Publish(NewEntry(_TEST_USER, ACTION_UNBAN, _TEST_SOURCE)); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	entries, err = store.Fetch()
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected: %d entries, Actual: %d", 2, len(entries))
+	}
+
+	if entries[0].Action != ACTION_BAN || entries[1].Action != ACTION_UNBAN {
+		t.Errorf("Entries should be returned in the order they were published: %+v", entries)
+	}
+
+	if strings.Compare(_TEST_USER, entries[0].User) != 0 {
+		t.Errorf("Expected: %s, Actual: %s", _TEST_USER, entries[0].User)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "bans.json"), "")
+	if _, ok := store.(*fileStore); !ok {
+		t.Fatalf("A path should be stored in a file, but got: %T", store)
+	}
+
+	assertRoundTrip(t, store)
+}
+
+func TestHttpStore(t *testing.T) {
+	server := httptest.NewServer(Handler(newFileStore(
+		filepath.Join(t.TempDir(), "bans.json")), _TEST_SECRET))
+	defer server.Close()
+
+	store := NewStore(server.URL, _TEST_SECRET)
+	if _, ok := store.(*httpStore); !ok {
+		t.Fatalf("A url should be fetched over http, but got: %T", store)
+	}
+
+	assertRoundTrip(t, store)
+}
+
+func TestHttpStoreRejectsInvalidEntry(t *testing.T) {
+	server := httptest.NewServer(Handler(newFileStore(
+		filepath.Join(t.TempDir(), "bans.json")), _TEST_SECRET))
+	defer server.Close()
+
+	store := NewStore(server.URL, _TEST_SECRET)
+	for _, entry := range []Entry{
+		NewEntry("nogateway", ACTION_BAN, _TEST_SOURCE),
+		NewEntry("*#*", ACTION_BAN, _TEST_SOURCE),
+		NewEntry("!Troll#Azeroth", ACTION_BAN, _TEST_SOURCE),
+		NewEntry(_TEST_USER, "kick", _TEST_SOURCE),
+		NewEntry(_TEST_USER, ACTION_BAN, ""),
+	} {
+		if err := store.Publish(entry); err == nil {
+			t.Errorf("Expected an error publishing %+v, but got nil.", entry)
+		}
+	}
+}
+
+func TestHttpStoreRequiresSignature(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	server := httptest.NewServer(Handler(newFileStore(path), _TEST_SECRET))
+	defer server.Close()
+
+	for _, secret := range []string{"", "not-the-secret"} {
+		store := NewStore(server.URL, secret)
+		if err := store.Publish(NewEntry(_TEST_USER, ACTION_BAN, _TEST_SOURCE)); err == nil {
+			t.Errorf("Expected an error publishing with secret '%s', but got nil.", secret)
+		}
+	}
+
+	/* Without a secret, the served feed is read only */
+	readOnly := httptest.NewServer(Handler(newFileStore(path), ""))
+	defer readOnly.Close()
+
+	if err := NewStore(readOnly.URL, "").Publish(NewEntry(_TEST_USER, ACTION_BAN, _TEST_SOURCE)); err == nil {
+		t.Errorf("Expected an error publishing to a feed served without a secret, but got nil.")
+	}
+
+	entries, err := newFileStore(path).Fetch()
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected an empty feed, Actual: %+v (%v)", entries, err)
+	}
+}
+
+func TestHttpStoreLimitsFeedSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := `{"user":"Troll#Azeroth","action":"ban","source":"clan"},`
+		_, _ = w.Write([]byte(`{"entries":[`))
+		_, _ = w.Write([]byte(strings.Repeat(entry, _HTTP_MAX_FEED/len(entry)+1)))
+		_, _ = w.Write([]byte(`{}]}`))
+	}))
+	defer server.Close()
+
+	if entries, err := NewStore(server.URL, "").Fetch(); err == nil {
+		t.Errorf("Expected an error for a feed over %d bytes, but got %d entries.", _HTTP_MAX_FEED, len(entries))
+	}
+}
+
+func TestParseTrust(t *testing.T) {
+	expected := map[string]Trust{
+		"":         TrustStandard,
+		"watch":    TrustWatch,
+		"Standard": TrustStandard,
+		"FULL":     TrustFull,
+	}
+
+	for name, trust := range expected {
+		actual, err := ParseTrust(name)
+		if err != nil || actual != trust {
+			t.Errorf("Expected: %d, Actual: %d (%v)", trust, actual, err)
+		}
+	}
+
+	if _, err := ParseTrust("some"); err == nil {
+		t.Errorf("Expected an error, but got nil.")
+	}
+}
@@ -0,0 +1,93 @@
+package federation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+	A feed stored in a local json file. Writes replace the file atomically,
+	so readers never see a partial feed. Publishing from several processes
+	at once may lose entries; use a single process serving the feed over
+	http (see `Handler`) for that.
+*/
+
+/* Serializes read-modify-write cycles of every file store in the process */
+var _FILE_LOCK sync.Mutex
+
+type fileStore struct {
+	path string
+}
+
+func newFileStore(path string) *fileStore {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) String() string {
+	return s.path
+}
+
+func (s *fileStore) read() (Feed, error) {
+	var feed Feed
+
+	raw, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return feed, nil
+	}
+	if err != nil {
+		return feed, err
+	}
+
+	if err := json.Unmarshal(raw, &feed); err != nil {
+		return feed, err
+	}
+
+	return feed, nil
+}
+
+func (s *fileStore) Fetch() ([]Entry, error) {
+	_FILE_LOCK.Lock()
+	defer _FILE_LOCK.Unlock()
+
+	feed, err := s.read()
+	return feed.Entries, err
+}
+
+func (s *fileStore) Publish(entry Entry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+
+	_FILE_LOCK.Lock()
+	defer _FILE_LOCK.Unlock()
+
+	feed, err := s.read()
+	if err != nil {
+		return err
+	}
+	feed.Entries = append(feed.Entries, entry)
+
+	raw, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
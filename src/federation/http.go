@@ -0,0 +1,148 @@
+package federation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"peonbot/webhook"
+	"strconv"
+	"time"
+)
+
+/*
+	A feed served over http. `GET` returns the feed, and `POST` appends a
+	single json encoded entry to it. POSTs must be signed with the feed's
+	shared secret, the same way as inbound webhooks, see `webhook.Sign`.
+*/
+
+const _HTTP_TIMEOUT = time.Duration(10) * time.Second
+const _HTTP_MAX_ENTRY = 4096
+
+/* Largest feed read, about 40k entries. Anything past it is cut off, and fails to decode. */
+const _HTTP_MAX_FEED = 4 << 20
+
+type httpStore struct {
+	url    string
+	secret string /* signs published entries */
+	client *http.Client
+}
+
+func newHttpStore(url string, secret string) *httpStore {
+	return &httpStore{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: _HTTP_TIMEOUT},
+	}
+}
+
+func (s *httpStore) String() string {
+	return s.url
+}
+
+func (s *httpStore) Fetch() ([]Entry, error) {
+	var feed Feed
+
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching feed '%s' failed: %s", s.url, resp.Status)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, _HTTP_MAX_FEED)).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("Feed '%s' is not valid, or is over %d bytes: %v", s.url, _HTTP_MAX_FEED, err)
+	}
+
+	return feed.Entries, nil
+}
+
+func (s *httpStore) Publish(entry Entry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.HEADER_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhook.HEADER_SIGNATURE, webhook.Sign(s.secret, timestamp, raw))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Publishing to feed '%s' failed: %s", s.url, resp.Status)
+	}
+
+	return nil
+}
+
+/*
+	Serve a feed over http, so bots in other processes can share it. Anyone
+	can read it, but only POSTs signed with `secret` are published. With no
+	secret, nothing is.
+*/
+func Handler(store Store, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			entries, err := store.Fetch()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(Feed{Entries: entries})
+		case http.MethodPost:
+			var entry Entry
+
+			raw, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, _HTTP_MAX_ENTRY))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if len(secret) == 0 || !webhook.Verify(secret, r, raw, time.Now()) {
+				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+				return
+			}
+
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := entry.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := store.Publish(entry); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+/* Serve the feed stored at `path` on `addr`. Blocks like `ListenAndServe`. */
+func Serve(addr string, path string, secret string) error {
+	return http.ListenAndServe(addr, Handler(newFileStore(path), secret))
+}
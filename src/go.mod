@@ -1,8 +1,13 @@
 module peonbot
 
+go 1.15
+
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/gdamore/tcell/v2 v2.0.0
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/websocket v1.4.1
+	go.starlark.net v0.0.0-20210223155950-e043a3d3c984
 	golang.org/x/net v0.0.0-20191021144547-ec77196f6094
 	golang.org/x/tools v0.0.0-20191101200257-8dbcdeb83d3f // indirect
 	gopkg.in/yaml.v2 v2.2.4
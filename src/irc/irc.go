@@ -0,0 +1,390 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+	A minimal IRC client: enough to sit in one channel, read what is said
+	there, and say things back. It reconnects whenever the connection
+	drops, waiting longer after each failure in a row.
+*/
+
+type Config struct {
+	Server   string /* host:port */
+	TLS      bool
+	Nick     string
+	Password string
+	Channel  string
+}
+
+/* Something said in the channel */
+type Message struct {
+	Nick   string
+	Text   string
+	Action bool /* sent with /me */
+}
+
+/* A raw protocol line, e.g. `:nick!user@host PRIVMSG #channel :hi` */
+type Line struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+const _CTCP_ACTION = "\x01ACTION "
+
+/* Outgoing lines are dropped rather than block the bot on a slow server */
+const _SEND_QUEUE = 64
+
+/* Keep clear of server flood limits */
+const _SEND_INTERVAL = 500 * time.Millisecond
+
+/* Servers cut lines at 512 bytes, including the command and channel */
+const _MAX_TEXT = 400
+
+const _READ_TIMEOUT = 5 * time.Minute
+
+const _MIN_BACKOFF = time.Second
+const _MAX_BACKOFF = 5 * time.Minute
+
+func ParseLine(raw string) (Line, error) {
+	var line Line
+
+	raw = strings.TrimRight(raw, "\r\n")
+	if strings.HasPrefix(raw, ":") {
+		parts := strings.SplitN(raw[1:], " ", 2)
+		if len(parts) != 2 {
+			return line, fmt.Errorf("Line has a prefix but no command: %s", raw)
+		}
+		line.Prefix, raw = parts[0], parts[1]
+	}
+
+	for len(raw) > 0 {
+		if strings.HasPrefix(raw, ":") {
+			line.Params = append(line.Params, raw[1:])
+			break
+		}
+
+		parts := strings.SplitN(raw, " ", 2)
+		if len(line.Command) == 0 {
+			line.Command = strings.ToUpper(parts[0])
+		} else if len(parts[0]) > 0 {
+			line.Params = append(line.Params, parts[0])
+		}
+
+		if len(parts) < 2 {
+			break
+		}
+		raw = parts[1]
+	}
+
+	if len(line.Command) == 0 {
+		return line, fmt.Errorf("Line has no command.")
+	}
+
+	return line, nil
+}
+
+/* The nick part of a `nick!user@host` prefix */
+func (l Line) Nick() string {
+	return strings.SplitN(l.Prefix, "!", 2)[0]
+}
+
+type Client struct {
+	Printf func(string, ...interface{})
+
+	config Config
+
+	queue chan string
+	done  chan struct{}
+	stop  sync.Once
+
+	mu   sync.Mutex
+	nick string
+	conn net.Conn
+
+	sendInterval time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+}
+
+func New(config Config) *Client {
+	return &Client{
+		Printf:       log.Printf,
+		config:       config,
+		queue:        make(chan string, _SEND_QUEUE),
+		done:         make(chan struct{}),
+		nick:         config.Nick,
+		sendInterval: _SEND_INTERVAL,
+		minBackoff:   _MIN_BACKOFF,
+		maxBackoff:   _MAX_BACKOFF,
+	}
+}
+
+/* The nick in use, which may differ from the configured one if it was taken */
+func (c *Client) Nick() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.nick
+}
+
+func (c *Client) Channel() string {
+	return c.config.Channel
+}
+
+/* Lines waiting to be sent */
+func (c *Client) Queued() int {
+	return len(c.queue)
+}
+
+/*
+	Queue a message to the channel. Long messages are split. Returns an
+	error if the queue is full.
+*/
+func (c *Client) Say(text string) error {
+	for _, chunk := range splitText(text, _MAX_TEXT) {
+		line := fmt.Sprintf("PRIVMSG %s :%s", c.config.Channel, chunk)
+
+		select {
+		case c.queue <- line:
+		default:
+			return fmt.Errorf("IRC send queue is full. Dropped: %s", chunk)
+		}
+	}
+
+	return nil
+}
+
+func splitText(text string, max int) []string {
+	var chunks []string
+
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+	for len(text) > max {
+		cut := strings.LastIndex(text[:max], " ")
+		if cut <= 0 {
+			/* Never in the middle of a multi-byte character */
+			cut = max
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = max
+			}
+		}
+
+		chunks = append(chunks, text[:cut])
+		text = strings.TrimLeft(text[cut:], " ")
+	}
+
+	return append(chunks, text)
+}
+
+/* Disconnect, and do not reconnect */
+func (c *Client) Stop() {
+	c.stop.Do(func() {
+		close(c.done)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+func (c *Client) stopped() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+	Stay connected until `Stop` is called, sending what is said in the
+	channel to `messages`. Blocks, so start it on its own goroutine.
+*/
+func (c *Client) Run(messages chan<- Message) {
+	backoff := c.minBackoff
+
+	for !c.stopped() {
+		joined, err := c.runOnce(messages)
+		if c.stopped() {
+			return
+		}
+		c.Printf("Disconnected from IRC server '%s': %v\n", c.config.Server, err)
+
+		if joined {
+			backoff = c.minBackoff
+		}
+
+		c.Printf("Reconnecting to IRC in %v\n", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-c.done:
+			return
+		}
+
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+func (c *Client) dial() (net.Conn, error) {
+	if !c.config.TLS {
+		return net.DialTimeout("tcp", c.config.Server, 30*time.Second)
+	}
+
+	host, _, err := net.SplitHostPort(c.config.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	return tls.DialWithDialer(dialer, "tcp", c.config.Server, &tls.Config{ServerName: host})
+}
+
+/* Returns whether the channel was joined before the connection dropped */
+func (c *Client) runOnce(messages chan<- Message) (bool, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	if c.stopped() {
+		c.mu.Unlock()
+		conn.Close()
+		return false, nil
+	}
+	c.conn = conn
+	c.nick = c.config.Nick
+	c.mu.Unlock()
+	defer conn.Close()
+
+	/* Registration is written directly, ahead of anything queued */
+	var writeMu sync.Mutex
+	write := func(line string) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		_, err := fmt.Fprintf(conn, "%s\r\n", line)
+		return err
+	}
+
+	if len(c.config.Password) > 0 {
+		if err := write("PASS " + c.config.Password); err != nil {
+			return false, err
+		}
+	}
+	if err := write("NICK " + c.config.Nick); err != nil {
+		return false, err
+	}
+	if err := write(fmt.Sprintf("USER %s 0 * :peonbot", c.config.Nick)); err != nil {
+		return false, err
+	}
+
+	exited := make(chan struct{})
+	defer close(exited)
+	joined := false
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(_READ_TIMEOUT))
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			return joined, err
+		}
+
+		line, err := ParseLine(raw)
+		if err != nil {
+			continue
+		}
+
+		switch line.Command {
+		case "PING":
+			err = write("PONG :" + strings.Join(line.Params, " "))
+		case "001": /* registered */
+			err = write("JOIN " + c.config.Channel)
+		case "433": /* nick in use */
+			c.mu.Lock()
+			c.nick += "_"
+			nick := c.nick
+			c.mu.Unlock()
+
+			err = write("NICK " + nick)
+		case "JOIN":
+			if !joined && strings.EqualFold(line.Nick(), c.Nick()) {
+				joined = true
+				c.Printf("Joined IRC channel %s as %s\n", c.config.Channel, c.Nick())
+				go c.writeQueue(write, exited)
+			}
+		case "KICK":
+			if len(line.Params) > 1 && strings.EqualFold(line.Params[1], c.Nick()) {
+				return joined, fmt.Errorf("Kicked from %s.", c.config.Channel)
+			}
+		case "ERROR":
+			return joined, fmt.Errorf("Server error: %s", strings.Join(line.Params, " "))
+		case "PRIVMSG":
+			if len(line.Params) < 2 || !strings.EqualFold(line.Params[0], c.config.Channel) {
+				continue
+			}
+
+			message := Message{Nick: line.Nick(), Text: line.Params[1]}
+			if strings.HasPrefix(message.Text, _CTCP_ACTION) {
+				message.Action = true
+				message.Text = strings.TrimSuffix(
+					strings.TrimPrefix(message.Text, _CTCP_ACTION), "\x01")
+			} else if strings.HasPrefix(message.Text, "\x01") {
+				continue /* other CTCP requests */
+			}
+
+			select {
+			case messages <- message:
+			case <-c.done:
+				return joined, nil
+			}
+		}
+
+		if err != nil {
+			return joined, err
+		}
+	}
+}
+
+func (c *Client) writeQueue(write func(string) error, exited chan struct{}) {
+	for {
+		select {
+		case line := <-c.queue:
+			if err := write(line); err != nil {
+				c.Printf("Could not send to IRC: %v\n", err)
+				return
+			}
+		case <-exited:
+			return
+		case <-c.done:
+			return
+		}
+
+		select {
+		case <-time.After(c.sendInterval):
+		case <-exited:
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
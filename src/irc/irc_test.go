@@ -0,0 +1,162 @@
+package irc
+
+import (
+	"peonbot/irc/irctest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestParseLine(t *testing.T) {
+	line, err := ParseLine(":nick!user@host PRIVMSG #peon :hello there\r\n")
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if line.Nick() != "nick" || line.Command != "PRIVMSG" ||
+		len(line.Params) != 2 || line.Params[1] != "hello there" {
+
+		t.Errorf("Unexpected line: %+v", line)
+	}
+
+	line, err = ParseLine("ping :irc.example.com")
+	if err != nil || line.Command != "PING" || line.Params[0] != "irc.example.com" {
+		t.Errorf("Unexpected line: %+v, %v", line, err)
+	}
+
+	if _, err := ParseLine(":prefixonly"); err == nil {
+		t.Errorf("Expected an error, but got nil.")
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	chunks := splitText("aaa bbb ccc\nddd", 8)
+	if strings.Join(chunks, "|") != "aaa bbb|ccc ddd" {
+		t.Errorf("Unexpected chunks: %q", chunks)
+	}
+
+	chunks = splitText("abcdefghij", 4)
+	if strings.Join(chunks, "|") != "abcd|efgh|ij" {
+		t.Errorf("Unexpected chunks: %q", chunks)
+	}
+
+	/* Each "ö" is two bytes, and "日" three */
+	chunks = splitText("ööööö日本語", 5)
+	if strings.Join(chunks, "|") != "öö|öö|ö日|本|語" {
+		t.Errorf("Unexpected chunks: %q", chunks)
+	}
+	for _, chunk := range chunks {
+		if !utf8.ValidString(chunk) {
+			t.Errorf("Chunk is not valid UTF-8: %q", chunk)
+		}
+	}
+}
+
+func getTestClient(server *irctest.Server) *Client {
+	client := New(Config{Server: server.Addr, Nick: "peonbot", Channel: "#peon"})
+	client.sendInterval = time.Millisecond
+	client.minBackoff = 10 * time.Millisecond
+
+	return client
+}
+
+func expectString(t *testing.T, ch <-chan string, expected string) {
+	select {
+	case actual := <-ch:
+		if actual != expected {
+			t.Fatalf("Expected: %s, Actual: %s", expected, actual)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for: %s", expected)
+	}
+}
+
+func expectMessage(t *testing.T, ch <-chan Message, expected Message) {
+	select {
+	case actual := <-ch:
+		if actual != expected {
+			t.Fatalf("Expected: %+v, Actual: %+v", expected, actual)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for: %+v", expected)
+	}
+}
+
+func TestClientRelay(t *testing.T) {
+	server := irctest.NewServer()
+	defer server.Close()
+
+	client := getTestClient(server)
+	defer client.Stop()
+
+	messages := make(chan Message)
+	go client.Run(messages)
+	expectString(t, server.Joined(), "peonbot")
+
+	if err := client.Say("hi from battle.net"); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	expectString(t, server.Received(), "#peon hi from battle.net")
+
+	server.Say("grunt", "#peon", "hi from irc")
+	expectMessage(t, messages, Message{Nick: "grunt", Text: "hi from irc"})
+
+	server.Say("grunt", "#peon", "\x01ACTION waves\x01")
+	expectMessage(t, messages, Message{Nick: "grunt", Text: "waves", Action: true})
+}
+
+func TestClientNickInUse(t *testing.T) {
+	server := irctest.NewServer()
+	defer server.Close()
+	server.TakeNick("peonbot")
+
+	client := getTestClient(server)
+	defer client.Stop()
+
+	go client.Run(make(chan Message))
+	expectString(t, server.Joined(), "peonbot_")
+
+	if client.Nick() != "peonbot_" {
+		t.Errorf("Expected: %s, Actual: %s", "peonbot_", client.Nick())
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	server := irctest.NewServer()
+	defer server.Close()
+
+	client := getTestClient(server)
+	defer client.Stop()
+
+	go client.Run(make(chan Message))
+	expectString(t, server.Joined(), "peonbot")
+
+	server.Disconnect()
+	expectString(t, server.Joined(), "peonbot")
+
+	_ = client.Say("still here")
+	expectString(t, server.Received(), "#peon still here")
+}
+
+func TestClientStop(t *testing.T) {
+	server := irctest.NewServer()
+	defer server.Close()
+
+	client := getTestClient(server)
+
+	done := make(chan struct{})
+	go func() {
+		client.Run(make(chan Message))
+		close(done)
+	}()
+	expectString(t, server.Joined(), "peonbot")
+
+	client.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Run should return once the client is stopped")
+	}
+}
@@ -0,0 +1,173 @@
+package irctest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+/*
+	An in-process IRC server for tests. It registers clients, lets them
+	join channels, and records what they say. Tests speak in the channel
+	with `Say`, and read what clients said from `Received`.
+*/
+
+type Server struct {
+	Addr string
+
+	listener net.Listener
+	received chan string
+	joined   chan string
+
+	mu    sync.Mutex
+	conns map[net.Conn]*_client
+	taken map[string]interface{} /* nicks to answer with 433 */
+}
+
+type _client struct {
+	nick    string
+	channel string
+}
+
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("irctest: could not listen: %v", err))
+	}
+
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		received: make(chan string, 128),
+		joined:   make(chan string, 16),
+		conns:    make(map[net.Conn]*_client),
+		taken:    make(map[string]interface{}),
+	}
+	go s.serve()
+
+	return s
+}
+
+/* Text of every PRIVMSG clients send, e.g. "#channel hi" */
+func (s *Server) Received() <-chan string {
+	return s.received
+}
+
+/* Nick of every client that joins a channel */
+func (s *Server) Joined() <-chan string {
+	return s.joined
+}
+
+/* Refuse a nick, as if someone else were using it */
+func (s *Server) TakeNick(nick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.taken[strings.ToLower(nick)] = nil
+}
+
+/* Say something in a channel, as `nick` */
+func (s *Server) Say(nick string, channel string, text string) {
+	s.broadcast(channel, fmt.Sprintf(":%s!%s@irctest PRIVMSG %s :%s", nick, nick, channel, text))
+}
+
+/* Drop every client connection, e.g. to test reconnecting */
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) Close() {
+	s.listener.Close()
+	s.Disconnect()
+}
+
+func (s *Server) broadcast(channel string, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, client := range s.conns {
+		if strings.EqualFold(client.channel, channel) {
+			fmt.Fprintf(conn, "%s\r\n", line)
+		}
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = &_client{}
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimRight(scanner.Text(), "\r"), " ", 2)
+		command := strings.ToUpper(fields[0])
+		arg := ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+
+		var said, joined string
+
+		s.mu.Lock()
+		client, ok := s.conns[conn]
+		if !ok {
+			s.mu.Unlock()
+			return
+		}
+
+		switch command {
+		case "NICK":
+			if _, taken := s.taken[strings.ToLower(arg)]; taken {
+				fmt.Fprintf(conn, ":irctest 433 * %s :Nickname is already in use\r\n", arg)
+			} else {
+				client.nick = arg
+				fmt.Fprintf(conn, ":irctest 001 %s :Welcome\r\n", arg)
+			}
+		case "JOIN":
+			client.channel = arg
+			fmt.Fprintf(conn, ":%s!%s@irctest JOIN %s\r\n", client.nick, client.nick, arg)
+			joined = client.nick
+		case "PING":
+			fmt.Fprintf(conn, ":irctest PONG irctest :%s\r\n", strings.TrimPrefix(arg, ":"))
+		case "PRIVMSG":
+			parts := strings.SplitN(arg, " :", 2)
+			if len(parts) == 2 {
+				said = parts[0] + " " + parts[1]
+			}
+		}
+		s.mu.Unlock()
+
+		/* Not under the lock, so a slow test cannot block `Say` */
+		if len(joined) > 0 {
+			s.joined <- joined
+		}
+		if len(said) > 0 {
+			s.received <- said
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
@@ -1,13 +1,30 @@
 package main
 
 import (
+	"peonbot/federation"
+	"peonbot/irc"
 	"peonbot/params"
 	"peonbot/peonbot"
+	"peonbot/session"
+	"peonbot/tui"
 	"peonbot/verbose"
 
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gdamore/tcell/v2"
 )
 
+/* Exit codes, so a supervisor can tell a bad api key from a network failure */
+const _EXIT_FAILURE = 1
+const _EXIT_BAD_KEY = 3
+
 func main() {
 	log.Printf("Starting up...\n")
 
@@ -19,36 +36,296 @@ func main() {
 	/* Set verbose printer */
 	verbose.SetPrinter(p.Args.Verbose())
 
-	/* Connect bot to battle.net */
-	bot := peonbot.New(p.Token(), p.Config.Blist(), p.Config.Greetings(),
-		p.Config.Pusers())
-	if err := bot.Start(); err != nil {
-		panic(err)
+	/*
+		Replay through a bot with the first instance's ban list and
+		priveleged users. Saved state, plugins, and other side effects are
+		left out, so replays are repeatable.
+	*/
+	if path := p.Args.Replay(); len(path) > 0 {
+		instance := p.Config.Instances()[0]
+		bot := peonbot.New(instance.Token(), instance.Blist(),
+			instance.Greetings(), instance.Pusers())
+
+		os.Exit(replay(path, bot.Replay))
+	}
+
+	/*
+		Each bot's event loop reloads config on its own, so serialize
+		access to the reloaded params.
+	*/
+	var mu sync.Mutex
+	reload := func(name string) ([]string, []string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := p.Reload(); err != nil {
+			return nil, nil, err
+		}
+
+		instance := p.Config.Instance(name)
+		if instance == nil {
+			return nil, nil, fmt.Errorf(
+				"Bot '%s' was removed from config. Restart to stop it.", name)
+		}
+
+		return instance.Blist(), instance.Pusers(), nil
 	}
-	defer bot.Conn.Close()
 
-	/* Listen for user input from stdin */
-	go bot.ListenStdin()
+	/* Serve a shared ban feed for bots in other processes */
+	fed := p.Config.Federation()
+	if len(fed.Serve) > 0 {
+		go func() {
+			log.Printf("Serving ban feed '%s' on %s\n", fed.ServeFile, fed.Serve)
+			if err := federation.Serve(fed.Serve, fed.ServeFile, fed.Secret); err != nil {
+				log.Printf("Stopped serving ban feed: %v\n", err)
+			}
+		}()
+	}
+
+	instances := p.Config.Instances()
+	group := peonbot.NewGroup()
+	var wg sync.WaitGroup
+	exit := _EXIT_FAILURE /* if no bots start */
 
-	/* Listen for responses from websocket */
-	go bot.ListenWebsocket()
+	var endedMu sync.Mutex
+	ended := 0 /* exit code once every event loop has ended */
 
-	/* Event loop */
-event_loop:
-	for {
-		select {
-		case event := <-bot.Chbnt():
-			if err := bot.HandleEvent(event); err != nil {
-				bot.Vprintf("Got error from handling event: %v\n", err)
+	for _, instance := range instances {
+		/* Connect bot to battle.net */
+		bot := peonbot.New(instance.Token(), instance.Blist(),
+			instance.Greetings(), instance.Pusers())
+		/* Reloads look the bot up in config by name, even if it is the only one */
+		if len(instance.Name()) > 0 {
+			bot.SetName(instance.Name())
+		}
+
+		if path := p.Args.Record(); len(path) > 0 {
+			if len(instances) > 1 {
+				ext := filepath.Ext(path)
+				path = strings.TrimSuffix(path, ext) + "-" + instance.Name() + ext
 			}
-		case err := <-bot.Cherr():
-			bot.Vprintf("Got error reading from websocket: %v\n", err)
-			break event_loop
-		case msg := <-bot.Chsin():
-			bot.HandleMessage(bot.Conn, msg)
+
+			if err := bot.Record(path); err != nil {
+				bot.Printf("Could not record session to '%s': %v\n", path, err)
+			} else {
+				bot.Printf("Recording session to '%s'\n", path)
+			}
+		}
+
+		if err := bot.SetDataDir(instance.DataDir()); err != nil {
+			bot.Printf("Could not load saved state from '%s': %v\n",
+				instance.DataDir(), err)
 		}
 
+		bot.SetScriptsDir(instance.ScriptsDir())
+
+		for _, announcement := range instance.Announcements() {
+			if err := bot.AddAnnouncement(announcement.Every,
+				announcement.Cron, announcement.Message); err != nil {
+				bot.Printf("Could not schedule announcement: %v\n", err)
+			}
+		}
+
+		strikes := p.Config.Moderation().Strikes
+		bot.SetStrikes(strikes.Kick, strikes.Ban, strikes.BanDuration(),
+			strikes.DecayDuration())
+
+		lockdown := p.Config.Moderation().Lockdown
+		bot.SetLockdown(lockdown.Allow, lockdown.Message)
+
+		idle := p.Config.Moderation().Idle
+		bot.SetIdleKick(idle.KickDuration(), idle.Capacity, idle.Headroom)
+
+		if votes := p.Config.Moderation().Votes; votes != nil {
+			bot.SetVoting(votes.VoteDuration(), votes.CooldownDuration(),
+				votes.Quorum, votes.Ratio)
+		}
+
+		if err := bot.SetPins(p.Config.Connection().Pins); err != nil {
+			bot.Printf("Could not pin certificates: %v\n", err)
+			continue
+		}
+
+		if err := bot.SetProxy(p.Config.Connection().Proxy); err != nil {
+			bot.Printf("Could not use proxy: %v\n", err)
+			continue
+		}
+
+		if err := bot.SetEndpoint(p.Config.Connection().EndpointConfig()); err != nil {
+			bot.Printf("Could not use endpoint: %v\n", err)
+			continue
+		}
+
+		if err := bot.Start(); err != nil {
+			bot.Printf("Could not start: %v\n", err)
+			if peonbot.IsAuthError(err) {
+				exit = _EXIT_BAD_KEY
+			}
+			continue
+		}
+		group.Add(bot)
+
+		/* Listen for responses from websocket */
+		go bot.ListenWebsocket()
+
+		/* Subscribe to shared ban feeds */
+		if len(fed.Feeds) > 0 {
+			name := fed.Name
+			if len(name) > 0 && len(instances) > 1 {
+				name = name + "/" + instance.Name()
+			}
+			bot.SetFederationName(name)
+
+			for _, feed := range fed.Feeds {
+				trust, _ := federation.ParseTrust(feed.Trust)
+				bot.AddFeed(federation.NewStore(feed.Location, feed.Secret), trust,
+					feed.Publish)
+			}
+
+			go bot.PollFeeds(fed.IntervalDuration())
+		}
+
+		/* Bridge to IRC */
+		if config := instance.IRC(); config != nil {
+			bot.AddBridge(irc.Config{
+				Server:   config.Server,
+				TLS:      config.TLS,
+				Nick:     config.Nick,
+				Password: config.Password,
+				Channel:  config.Channel,
+			}, config.Nicks, config.Ignore, config.RateLimitDuration())
+		}
+
+		/* Webhooks */
+		for _, hook := range instance.Webhooks() {
+			if err := bot.AddWebhook(hook.Url, hook.Template, hook.Events,
+				hook.Keywords, hook.Watch); err != nil {
+				bot.Printf("Could not add webhook: %v\n", err)
+			}
+		}
+		if inbound := instance.InboundWebhook(); inbound != nil {
+			bot.ServeWebhook(inbound.Listen, inbound.Path, inbound.Secret)
+		}
+
+		/* Start plugins */
+		for _, plugin := range instance.Plugins() {
+			bot.AddPlugin(plugin.Name, plugin.Command, plugin.Args, plugin.Scopes)
+		}
+
+		/* Event loop */
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			/* The connection is replaced when reconnecting */
+			defer func() { _ = bot.Conn.Close() }()
+			defer bot.StopRecording()
+			defer bot.StopPlugins()
+			defer bot.StopBridge()
+			defer bot.StopWebhooks()
+
+			err := bot.EventLoop(reload)
+			if err == nil {
+				bot.Printf("Event loop broken.\n")
+				return
+			}
+			bot.Printf("Event loop broken: %v\n", err)
+
+			/* A rejected key outranks a lost connection, as it needs fixing */
+			endedMu.Lock()
+			defer endedMu.Unlock()
+			if ended != _EXIT_BAD_KEY {
+				ended = exitCode(err)
+			}
+		}()
+	}
+
+	if len(group.Bots()) == 0 {
+		log.Printf("No bots could be started.\n")
+		os.Exit(exit)
+	}
+
+	/* Listen for user input from the terminal UI, or stdin */
+	var ui *tui.UI
+	if p.Args.Tui() {
+		screen, err := tcell.NewScreen()
+		if err != nil {
+			log.Fatalf("Could not start terminal UI: %v\n", err)
+		}
+
+		ui = tui.New(screen, group, peonbot.StdinCommands())
+		log.SetOutput(ui)
+		verbose.SetOutput(ui)
+
+		go func() {
+			if err := ui.Run(); err != nil {
+				log.SetOutput(os.Stderr)
+				log.Fatalf("Could not start terminal UI: %v\n", err)
+			}
+
+			/* Quit with ctrl-c */
+			group.Stop()
+		}()
+	} else {
+		go group.ListenStdin()
 	}
 
+	/* Reload config when a config file changes, or on SIGHUP */
+	go p.Watch(group.RequestReload)
+
+	chsig := make(chan os.Signal, 1)
+	signal.Notify(chsig, syscall.SIGHUP)
+	go func() {
+		for range chsig {
+			group.RequestReload()
+		}
+	}()
+
+	/* Shut down cleanly on ctrl-c, or when asked to by the system */
+	chint := make(chan os.Signal, 1)
+	signal.Notify(chint, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-chint
+		log.Printf("Shutting down...\n")
+		group.Stop()
+	}()
+
+	wg.Wait()
+
+	if ui != nil {
+		ui.Stop()
+		log.SetOutput(os.Stderr)
+	}
 	log.Printf("Event loop broken. Shutting down...\n")
+	os.Exit(ended)
+}
+
+/* Exit code for a bot whose event loop ended with `err` */
+func exitCode(err error) int {
+	if peonbot.IsAuthError(err) {
+		return _EXIT_BAD_KEY
+	}
+
+	return _EXIT_FAILURE
+}
+
+/* Replay a recorded session file. Returns the exit code. */
+func replay(path string, play func([]session.Frame) []string) int {
+	frames, err := session.Read(path)
+	if err != nil {
+		log.Printf("Could not read session: %v\n", err)
+		return _EXIT_FAILURE
+	}
+
+	mismatches := play(frames)
+	for _, mismatch := range mismatches {
+		log.Printf("%s\n", mismatch)
+	}
+
+	log.Printf("Replayed %d frames from '%s', %d differ from the recording.\n",
+		len(session.Steps(frames)), path, len(mismatches))
+	if len(mismatches) > 0 {
+		return _EXIT_FAILURE
+	}
+
+	return 0
 }
@@ -1,17 +1,56 @@
 package params
 
-import "flag"
+import (
+	"flag"
+	"os"
+)
 
 /* Command line args */
 
+const _ENV_CONFIG_DIR = "PEONBOT_CONFIG_DIR"
+const _ENV_CONFIG_FILE = "PEONBOT_CONFIG"
+
 type _args struct {
-	verbose bool
+	verbose    bool
+	tui        bool
+	record     string
+	replay     string
+	configDir  string
+	configFile string
 }
 
 func (a *_args) Verbose() bool {
 	return a.verbose
 }
 
+/* Session file to record websocket traffic to. Empty if not recording. */
+func (a *_args) Record() string {
+	return a.record
+}
+
+/* Session file to replay instead of connecting. Empty if not replaying. */
+func (a *_args) Replay() string {
+	return a.replay
+}
+
+/* Run the full-screen terminal UI instead of reading lines from stdin */
+func (a *_args) Tui() bool {
+	return a.tui
+}
+
+/*
+	Directory that holds the bot's `config/` and `tokens/` folders, and/or
+	a unified `peonbot.yaml` or `peonbot.toml` file.
+*/
+func (a *_args) ConfigDir() string {
+	return a.configDir
+}
+
+/* Path to a unified config file. Empty if one was not specified. */
+func (a *_args) ConfigFile() string {
+	return a.configFile
+}
+
 func getArgs() *_args {
 	var args _args
 
@@ -19,9 +58,42 @@ func getArgs() *_args {
 	flag.BoolVar(&verbose, "verbose", false,
 		"Enables additional logging if set to true. Defaults to false.")
 
+	var tui bool
+	flag.BoolVar(&tui, "tui", false,
+		"Runs a full-screen terminal UI with the chat, channel members, "+
+			"and bot status. Defaults to false.")
+
+	var record string
+	flag.StringVar(&record, "record", "",
+		"Records everything sent to and received from Battle.net to this "+
+			"session file, with the api key redacted. With several bots, "+
+			"the bot's name is added to the file name.")
+
+	var replay string
+	flag.StringVar(&replay, "replay", "",
+		"Replays a recorded session file through the first configured "+
+			"bot instead of connecting, and reports requests that differ "+
+			"from the recording.")
+
+	var configDir string
+	flag.StringVar(&configDir, "config-dir", os.Getenv(_ENV_CONFIG_DIR),
+		"Directory containing the bot's config/ and tokens/ folders, or a "+
+			"unified peonbot.yaml/peonbot.toml. Defaults to the working "+
+			"directory, then the directory of the executable.")
+
+	var configFile string
+	flag.StringVar(&configFile, "config", os.Getenv(_ENV_CONFIG_FILE),
+		"Path to a unified .yaml or .toml config file. Overrides the "+
+			"split files in the config directory.")
+
 	flag.Parse()
 
 	args.verbose = verbose
+	args.tui = tui
+	args.record = record
+	args.replay = replay
+	args.configDir = configDir
+	args.configFile = configFile
 
 	return &args
 }
@@ -1,113 +1,1283 @@
 package params
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"peonbot/ban"
+	"peonbot/cron"
+	"peonbot/endpoint"
+	"peonbot/federation"
+	"peonbot/pin"
+	"peonbot/proxy"
+	"peonbot/webhook"
+	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v2"
 )
 
-/* config from yaml files in `../config` */
+/*
+	config from either a single unified yaml/toml file, or the legacy split
+	yaml files in `<config dir>/config` and `<config dir>/tokens`.
+*/
 
-const _FILE_BANLIST = "config/ban_list.yaml"
-const _FILE_GREETINGS = "config/greetings.yaml"
-const _FILE_PRIVELEGED = "config/priveleged_list.yaml"
+const _DIR_CONFIG = "config"
+const _DIR_TOKENS = "tokens"
+const _DIR_DATA = "data"
+const _DIR_SCRIPTS = "scripts"
+const _FILE_BANLIST = "ban_list.yaml"
+const _FILE_GREETINGS = "greetings.yaml"
+const _FILE_PRIVELEGED = "priveleged_list.yaml"
+const _FILE_API_TOKEN = "token.yaml"
+
+/* Unified config file names looked up in the config dir, in order */
+var _FILES_UNIFIED = []string{"peonbot.yaml", "peonbot.yml", "peonbot.toml"}
+
+const _EXT_YAML = ".YAML"
+const _EXT_YML = ".YML"
+const _EXT_TOML = ".TOML"
+
+/*
+	Where a config value was read from. Used to point validation errors at
+	the offending file and field.
+*/
+type _source struct {
+	file  string
+	field string
+}
+
+type _configError struct {
+	src    _source
+	reason string
+}
+
+func (e *_configError) Error() string {
+	return fmt.Sprintf("Invalid config in '%s', field '%s': %s",
+		e.src.file, e.src.field, e.reason)
+}
+
+func errConfig(src _source, reason string, vargs ...interface{}) error {
+	return &_configError{src: src, reason: fmt.Sprintf(reason, vargs...)}
+}
 
 type _banlist struct {
 	Users []string `yaml:"users"`
 }
 
-func readBanlist() (*_banlist, error) {
-	var banlist _banlist
+type _greetings struct {
+	Msg string `yaml:"msg"`
+}
 
-	raw, err := ioutil.ReadFile(_FILE_BANLIST)
-	if err != nil {
-		return &_banlist{}, err
+type _privelegedusers struct {
+	Users []string `yaml:"users"`
+}
+
+type _token struct {
+	ApiKey string `yaml:"api_key"`
+}
+
+/* A recurring channel message, sent either `every` interval, or on `cron` */
+type _announcement struct {
+	Message string `yaml:"message" toml:"message"`
+	Every   string `yaml:"every" toml:"every"` /* e.g. 30m, or 1h30m */
+	Cron    string `yaml:"cron" toml:"cron"`   /* e.g. "0 20 * * 5" */
+}
+
+/* Announcements more frequent than this would flood the channel */
+const _ANNOUNCEMENT_MIN_INTERVAL = time.Minute
+
+/*
+	An executable the bot streams events to, and takes action requests from.
+	`scopes` lists the actions it may request: say, whisper, kick, ban.
+*/
+type _plugin struct {
+	Name    string   `yaml:"name" toml:"name"`
+	Command string   `yaml:"command" toml:"command"`
+	Args    []string `yaml:"args" toml:"args"`
+	Scopes  []string `yaml:"scopes" toml:"scopes"`
+}
+
+var _PLUGIN_SCOPES = []string{"say", "whisper", "kick", "ban"}
+
+/*
+	Relay between the bot's channel and an IRC channel. `nicks` maps
+	battle.net names to IRC nicks, and is used both ways. Messages from
+	IRC nicks in `ignore` (e.g. other bridges) are never relayed.
+*/
+type _ircConfig struct {
+	Server    string            `yaml:"server" toml:"server"` /* host:port */
+	TLS       bool              `yaml:"tls" toml:"tls"`
+	Nick      string            `yaml:"nick" toml:"nick"`
+	Password  string            `yaml:"password" toml:"password"`
+	Channel   string            `yaml:"channel" toml:"channel"`
+	Nicks     map[string]string `yaml:"nicks" toml:"nicks"`
+	Ignore    []string          `yaml:"ignore" toml:"ignore"`
+	RateLimit string            `yaml:"rate_limit" toml:"rate_limit"` /* time between relayed messages */
+}
+
+/*
+	A url to POST to when one of `events` happens. A keyword event fires
+	when a channel message contains one of `keywords`, and a join event
+	fires when someone on `watch` joins (anyone, if `watch` is empty).
+*/
+type _webhookConfig struct {
+	Url      string   `yaml:"url" toml:"url"`
+	Events   []string `yaml:"events" toml:"events"`
+	Keywords []string `yaml:"keywords" toml:"keywords"`
+	Watch    []string `yaml:"watch" toml:"watch"`
+	Template string   `yaml:"template" toml:"template"` /* json payload, see webhook.NewHook */
+}
+
+/* An http endpoint accepting signed messages to say in the channel */
+type _inboundConfig struct {
+	Listen string `yaml:"listen" toml:"listen"` /* host:port */
+	Path   string `yaml:"path" toml:"path"`
+	Secret string `yaml:"secret" toml:"secret"`
+}
+
+/* Short secrets are easy to guess */
+const _INBOUND_MIN_SECRET = 16
+
+/* Relaying to battle.net more often than this would get the bot muted */
+const _IRC_MIN_RATE_LIMIT = 500 * time.Millisecond
+const _IRC_RATE_LIMIT = "1s"
+
+/* Schema of a bot instance in the unified config file */
+type _unifiedBot struct {
+	Name          string          `yaml:"name" toml:"name"`
+	ApiKey        string          `yaml:"api_key" toml:"api_key"`
+	Greetings     string          `yaml:"greetings" toml:"greetings"`
+	Banlist       []string        `yaml:"ban_list" toml:"ban_list"`
+	Pusers        []string        `yaml:"priveleged_list" toml:"priveleged_list"`
+	Announcements []_announcement `yaml:"announcements" toml:"announcements"`
+	Plugins       []_plugin       `yaml:"plugins" toml:"plugins"`
+	IRC           *_ircConfig     `yaml:"irc" toml:"irc"`
+
+	Webhooks       []_webhookConfig `yaml:"webhooks" toml:"webhooks"`
+	InboundWebhook *_inboundConfig  `yaml:"inbound_webhook" toml:"inbound_webhook"`
+}
+
+/* A shared ban feed, either a local json file or an http(s) url */
+type _feedConfig struct {
+	Location string `yaml:"location" toml:"location"`
+	Trust    string `yaml:"trust" toml:"trust"` /* watch, standard, or full */
+	Publish  bool   `yaml:"publish" toml:"publish"`
+	Secret   string `yaml:"secret" toml:"secret"` /* signs bans published over http */
+}
+
+type _federationConfig struct {
+	Name      string        `yaml:"name" toml:"name"`         /* source name to publish bans under */
+	Interval  int           `yaml:"interval" toml:"interval"` /* seconds between fetching feeds */
+	Serve     string        `yaml:"serve" toml:"serve"`       /* address to serve `serve_file` on */
+	ServeFile string        `yaml:"serve_file" toml:"serve_file"`
+	Secret    string        `yaml:"secret" toml:"secret"` /* bans posted to the served feed must be signed with it */
+	Feeds     []_feedConfig `yaml:"feeds" toml:"feeds"`
+}
+
+const _FEDERATION_INTERVAL = 30
+
+/* How every bot in the process connects to battle.net */
+type _connectionConfig struct {
+	Pins  []string `yaml:"pins" toml:"pins"`   /* certificate pins, see package pin */
+	Proxy string   `yaml:"proxy" toml:"proxy"` /* proxy url or `direct`, see package proxy */
+
+	/* See package endpoint */
+	ApiVersion   string            `yaml:"api_version" toml:"api_version"`
+	Endpoint     string            `yaml:"endpoint" toml:"endpoint"`
+	Subprotocols []string          `yaml:"subprotocols" toml:"subprotocols"`
+	Headers      map[string]string `yaml:"headers" toml:"headers"`
+}
+
+func (c *_connectionConfig) EndpointConfig() endpoint.Config {
+	return endpoint.Config{
+		Version:      c.ApiVersion,
+		URL:          c.Endpoint,
+		Subprotocols: c.Subprotocols,
+		Headers:      c.Headers,
 	}
+}
+
+/* Moderation by the members of the channel, for every bot */
+type _moderationConfig struct {
+	Votes    *_votesConfig   `yaml:"votes" toml:"votes"` /* .votekick and .voteban, off if not set */
+	Strikes  _strikesConfig  `yaml:"strikes" toml:"strikes"`
+	Lockdown _lockdownConfig `yaml:"lockdown" toml:"lockdown"`
+	Idle     _idleConfig     `yaml:"idle" toml:"idle"`
+}
+
+/*
+	Users idle for longer than `kick_after` are kicked when fewer than
+	`headroom` of the channel's `capacity` places are left. Off unless
+	`kick_after` is set.
+*/
+type _idleConfig struct {
+	KickAfter string `yaml:"kick_after" toml:"kick_after"`
+	Capacity  int    `yaml:"capacity" toml:"capacity"`
+	Headroom  int    `yaml:"headroom" toml:"headroom"`
+}
+
+const _IDLE_CAPACITY = 40
+const _IDLE_HEADROOM = 5
+const _IDLE_MIN_KICK_AFTER = time.Minute
+
+/* 0 if idle users are never kicked */
+func (c *_idleConfig) KickDuration() time.Duration {
+	after, _ := time.ParseDuration(c.KickAfter)
+	return after
+}
+
+/*
+	Users on `allow` (e.g. the clan roster) are let in during `.lockdown`.
+	Anyone else is whispered `message`, if set, and kicked.
+*/
+type _lockdownConfig struct {
+	Allow   []string `yaml:"allow" toml:"allow"`
+	Message string   `yaml:"message" toml:"message"`
+}
+
+/*
+	Votes are open for `duration`, and pass with at least `quorum` votes, of
+	which at least `ratio` are yes. Each member can start one vote per
+	`cooldown`.
+*/
+type _votesConfig struct {
+	Duration string  `yaml:"duration" toml:"duration"`
+	Quorum   int     `yaml:"quorum" toml:"quorum"`
+	Ratio    float64 `yaml:"ratio" toml:"ratio"`
+	Cooldown string  `yaml:"cooldown" toml:"cooldown"`
+}
+
+const _VOTE_DURATION = "1m"
+const _VOTE_MIN_DURATION = 10 * time.Second
+const _VOTE_QUORUM = 3
+const _VOTE_RATIO = 0.6
+const _VOTE_COOLDOWN = "10m"
+
+func (c *_votesConfig) VoteDuration() time.Duration {
+	duration, _ := time.ParseDuration(c.Duration)
+	return duration
+}
+
+func (c *_votesConfig) CooldownDuration() time.Duration {
+	cooldown, _ := time.ParseDuration(c.Cooldown)
+	return cooldown
+}
+
+/*
+	Users given `kick` strikes with `.warn` are kicked, and at `ban` they
+	are banned for `ban_for`. Strikes expire `decay` after they are given,
+	or never if it is 0.
+*/
+type _strikesConfig struct {
+	Kick   int    `yaml:"kick" toml:"kick"`
+	Ban    int    `yaml:"ban" toml:"ban"`
+	BanFor string `yaml:"ban_for" toml:"ban_for"`
+	Decay  string `yaml:"decay" toml:"decay"`
+}
+
+const _STRIKES_KICK = 3
+const _STRIKES_BAN = 5
+const _STRIKES_BAN_FOR = "24h"
+const _STRIKES_MIN_BAN_FOR = time.Minute
+const _STRIKES_DECAY = "168h" /* a week */
+
+func (c *_strikesConfig) BanDuration() time.Duration {
+	banFor, _ := time.ParseDuration(c.BanFor)
+	return banFor
+}
+
+func (c *_strikesConfig) DecayDuration() time.Duration {
+	decay, _ := time.ParseDuration(c.Decay)
+	return decay
+}
+
+/*
+	Schema of the unified config file. With a `bots` list, the top level
+	ban list and priveleged list are shared by every bot.
+*/
+type _unified struct {
+	ApiKey    string        `yaml:"api_key" toml:"api_key"`
+	Greetings string        `yaml:"greetings" toml:"greetings"`
+	Banlist   []string      `yaml:"ban_list" toml:"ban_list"`
+	Pusers    []string      `yaml:"priveleged_list" toml:"priveleged_list"`
+	Bots      []_unifiedBot `yaml:"bots" toml:"bots"`
+
+	DataDir       string            `yaml:"data_dir" toml:"data_dir"`
+	ScriptsDir    string            `yaml:"scripts_dir" toml:"scripts_dir"`
+	Announcements []_announcement   `yaml:"announcements" toml:"announcements"`
+	Plugins       []_plugin         `yaml:"plugins" toml:"plugins"`
+	IRC           *_ircConfig       `yaml:"irc" toml:"irc"`
+	Federation    _federationConfig `yaml:"federation" toml:"federation"`
+	Connection    _connectionConfig `yaml:"connection" toml:"connection"`
+	Moderation    _moderationConfig `yaml:"moderation" toml:"moderation"`
+
+	Webhooks       []_webhookConfig `yaml:"webhooks" toml:"webhooks"`
+	InboundWebhook *_inboundConfig  `yaml:"inbound_webhook" toml:"inbound_webhook"`
+}
+
+/* Config for one bot, i.e. one api key and the channel it is bound to */
+type _instance struct {
+	name      string
+	apiKey    string
+	blist     []string
+	greetings string
+	pusers    []string
+
+	announcements []_announcement
+	plugins       []_plugin
+	irc           *_ircConfig
+	webhooks      []_webhookConfig
+	inbound       *_inboundConfig
+	dataDir       string /* where state that outlives the bot is kept */
+	scriptsDir    string
+
+	srcName          _source
+	srcApiKey        _source
+	srcBlist         _source
+	srcGreetings     _source
+	srcPusers        _source
+	srcAnnouncements _source
+	srcPlugins       _source
+	srcIRC           _source
+	srcWebhooks      _source
+	srcInbound       _source
+}
+
+func (i *_instance) Name() string {
+	return i.name
+}
+
+func (i *_instance) Token() string {
+	return i.apiKey
+}
+
+func (i *_instance) Blist() []string {
+	return i.blist
+}
+
+func (i *_instance) Greetings() string {
+	return i.greetings
+}
+
+func (i *_instance) Pusers() []string {
+	return i.pusers
+}
+
+func (i *_instance) Announcements() []_announcement {
+	return i.announcements
+}
+
+func (i *_instance) Plugins() []_plugin {
+	return i.plugins
+}
+
+/* Returns nil if the bot is not bridged to IRC */
+func (i *_instance) IRC() *_ircConfig {
+	return i.irc
+}
 
-	if err := yaml.Unmarshal(raw, &banlist); err != nil {
-		return &_banlist{}, err
+func (i *_instance) Webhooks() []_webhookConfig {
+	return i.webhooks
+}
+
+/* Returns nil if the bot does not accept inbound webhooks */
+func (i *_instance) InboundWebhook() *_inboundConfig {
+	return i.inbound
+}
+
+func (c *_ircConfig) RateLimitDuration() time.Duration {
+	rate, _ := time.ParseDuration(c.RateLimit)
+	return rate
+}
+
+func (i *_instance) DataDir() string {
+	return i.dataDir
+}
+
+func (i *_instance) ScriptsDir() string {
+	return i.scriptsDir
+}
+
+type _config struct {
+	_instance /* top level values, shared by every bot */
+
+	bots  []*_instance
+	files []string /* files the config was read from */
+
+	federation    _federationConfig
+	srcFederation _source
+	connection    _connectionConfig
+	srcConnection _source
+	moderation    _moderationConfig
+	srcModeration _source
+}
+
+func (c *_config) Files() []string {
+	return c.files
+}
+
+func (c *_config) Federation() *_federationConfig {
+	return &c.federation
+}
+
+func (c *_config) Connection() *_connectionConfig {
+	return &c.connection
+}
+
+func (c *_config) Moderation() *_moderationConfig {
+	return &c.moderation
+}
+
+func (f *_federationConfig) IntervalDuration() time.Duration {
+	return time.Duration(f.Interval) * time.Second
+}
+
+/*
+	The bots to run. A config without a `bots` list runs a single, unnamed
+	bot from the top level values.
+*/
+func (c *_config) Instances() []*_instance {
+	if len(c.bots) == 0 {
+		return []*_instance{&c._instance}
+	}
+
+	var instances []*_instance
+	for _, bot := range c.bots {
+		instance := *bot
+		instance.blist = append(append([]string{}, c.blist...), bot.blist...)
+		instance.pusers = append(append([]string{}, c.pusers...), bot.pusers...)
+		instance.announcements = append(
+			append([]_announcement{}, c.announcements...), bot.announcements...)
+		instance.plugins = append(append([]_plugin{}, c.plugins...), bot.plugins...)
+		instance.webhooks = append(append([]_webhookConfig{}, c.webhooks...), bot.webhooks...)
+		instance.dataDir = filepath.Join(c.dataDir, bot.name)
+		instance.scriptsDir = c.scriptsDir
+		if len(instance.greetings) == 0 {
+			instance.greetings = c.greetings
+		}
+
+		instances = append(instances, &instance)
 	}
 
-	return &banlist, nil
+	return instances
 }
 
-type _greetings struct {
-	Msg string `yaml:"msg"`
+/* Returns nil if there is no bot with the given name */
+func (c *_config) Instance(name string) *_instance {
+	for _, instance := range c.Instances() {
+		if strings.Compare(instance.name, name) == 0 {
+			return instance
+		}
+	}
+
+	return nil
 }
 
-func readGreetings() (*_greetings, error) {
-	var greetings _greetings
+/* Omit api keys so that they never end up in a log by accident */
+func (i *_instance) String() string {
+	return fmt.Sprintf("{name:%s blist:%v greetings:%s pusers:%v}",
+		i.name, i.blist, i.greetings, i.pusers)
+}
+
+func (c *_config) String() string {
+	return fmt.Sprintf("{%s bots:%v}", c._instance.String(), c.bots)
+}
 
-	raw, err := ioutil.ReadFile(_FILE_GREETINGS)
+func readYaml(path string, v interface{}) error {
+	raw, err := ioutil.ReadFile(path)
 	if err != nil {
-		return &_greetings{}, err
+		return err
 	}
 
-	if err := yaml.Unmarshal(raw, &greetings); err != nil {
-		return &_greetings{}, err
+	if err := yaml.UnmarshalStrict(raw, v); err != nil {
+		return fmt.Errorf("Invalid config in '%s': %v", path, err)
 	}
 
-	return &greetings, nil
+	return nil
 }
 
-type _privelegedusers struct {
-	Users []string `yaml:"users"`
+func readToml(path string, v interface{}) error {
+	meta, err := toml.DecodeFile(path, v)
+	if err != nil {
+		return fmt.Errorf("Invalid config in '%s': %v", path, err)
+	}
+
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return errConfig(_source{path, undecoded[0].String()},
+			"unknown field")
+	}
+
+	return nil
+}
+
+func readUnified(path string) (*_config, error) {
+	var unified _unified
+
+	switch strings.ToUpper(filepath.Ext(path)) {
+	case _EXT_YAML, _EXT_YML:
+		if err := readYaml(path, &unified); err != nil {
+			return &_config{}, err
+		}
+	case _EXT_TOML:
+		if err := readToml(path, &unified); err != nil {
+			return &_config{}, err
+		}
+	default:
+		return &_config{}, fmt.Errorf(
+			"Unsupported config file '%s'. Expected a .yaml, .yml or .toml file.",
+			path)
+	}
+
+	config := &_config{
+		_instance: _instance{
+			apiKey:       unified.ApiKey,
+			blist:        unified.Banlist,
+			greetings:    unified.Greetings,
+			pusers:       unified.Pusers,
+			srcApiKey:    _source{path, "api_key"},
+			srcBlist:     _source{path, "ban_list"},
+			srcGreetings: _source{path, "greetings"},
+			srcPusers:    _source{path, "priveleged_list"},
+
+			announcements:    unified.Announcements,
+			dataDir:          unified.DataDir,
+			scriptsDir:       unified.ScriptsDir,
+			srcAnnouncements: _source{path, "announcements"},
+
+			plugins:    resolvePlugins(filepath.Dir(path), unified.Plugins),
+			srcPlugins: _source{path, "plugins"},
+
+			irc:    unified.IRC,
+			srcIRC: _source{path, "irc"},
+
+			webhooks:    unified.Webhooks,
+			srcWebhooks: _source{path, "webhooks"},
+			inbound:     unified.InboundWebhook,
+			srcInbound:  _source{path, "inbound_webhook"},
+		},
+		files:         []string{path},
+		federation:    unified.Federation,
+		srcFederation: _source{path, "federation"},
+		connection:    unified.Connection,
+		srcConnection: _source{path, "connection"},
+		moderation:    unified.Moderation,
+		srcModeration: _source{path, "moderation"},
+	}
+
+	/* A relative data dir is relative to the config file */
+	config.dataDir = resolveDir(filepath.Dir(path), config.dataDir, _DIR_DATA)
+	config.scriptsDir = resolveDir(filepath.Dir(path), config.scriptsDir, _DIR_SCRIPTS)
+
+	if config.federation.Interval == 0 {
+		config.federation.Interval = _FEDERATION_INTERVAL
+	}
+
+	for i, bot := range unified.Bots {
+		field := fmt.Sprintf("bots[%d]", i)
+
+		config.bots = append(config.bots, &_instance{
+			name:         bot.Name,
+			apiKey:       bot.ApiKey,
+			blist:        bot.Banlist,
+			greetings:    bot.Greetings,
+			pusers:       bot.Pusers,
+			srcName:      _source{path, field + ".name"},
+			srcApiKey:    _source{path, field + ".api_key"},
+			srcBlist:     _source{path, field + ".ban_list"},
+			srcGreetings: _source{path, field + ".greetings"},
+			srcPusers:    _source{path, field + ".priveleged_list"},
+
+			announcements:    bot.Announcements,
+			srcAnnouncements: _source{path, field + ".announcements"},
+
+			plugins:    resolvePlugins(filepath.Dir(path), bot.Plugins),
+			srcPlugins: _source{path, field + ".plugins"},
+
+			irc:    bot.IRC,
+			srcIRC: _source{path, field + ".irc"},
+
+			webhooks:    bot.Webhooks,
+			srcWebhooks: _source{path, field + ".webhooks"},
+			inbound:     bot.InboundWebhook,
+			srcInbound:  _source{path, field + ".inbound_webhook"},
+		})
+	}
+
+	return config, nil
+}
+
+/* A relative dir is relative to the config file */
+func resolveDir(base string, dir string, fallback string) string {
+	if len(dir) == 0 {
+		dir = fallback
+	}
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+
+	return dir
+}
+
+/*
+	A relative command path is relative to the config file. A bare command
+	name is looked up in PATH.
+*/
+func resolvePlugins(dir string, plugins []_plugin) []_plugin {
+	for i, plugin := range plugins {
+		if strings.ContainsAny(plugin.Command, "/"+string(filepath.Separator)) &&
+			!filepath.IsAbs(plugin.Command) {
+
+			plugins[i].Command = filepath.Join(dir, plugin.Command)
+		}
+	}
+
+	return plugins
 }
 
-func readPrivelege() (*_privelegedusers, error) {
+func readLegacy(dir string) (*_config, error) {
+	var banlist _banlist
+	var greetings _greetings
 	var privelege _privelegedusers
+	var token _token
+
+	fileBanlist := filepath.Join(dir, _DIR_CONFIG, _FILE_BANLIST)
+	fileGreetings := filepath.Join(dir, _DIR_CONFIG, _FILE_GREETINGS)
+	filePriveleged := filepath.Join(dir, _DIR_CONFIG, _FILE_PRIVELEGED)
+	fileToken := filepath.Join(dir, _DIR_TOKENS, _FILE_API_TOKEN)
+
+	if err := readYaml(fileBanlist, &banlist); err != nil {
+		return &_config{}, err
+	}
+
+	if err := readYaml(fileGreetings, &greetings); err != nil {
+		return &_config{}, err
+	}
+
+	if err := readYaml(filePriveleged, &privelege); err != nil {
+		return &_config{}, err
+	}
+
+	if err := readYaml(fileToken, &token); err != nil {
+		return &_config{}, err
+	}
+
+	return &_config{
+		_instance: _instance{
+			apiKey:       token.ApiKey,
+			blist:        banlist.Users,
+			greetings:    greetings.Msg,
+			pusers:       privelege.Users,
+			srcApiKey:    _source{fileToken, "api_key"},
+			srcBlist:     _source{fileBanlist, "users"},
+			srcGreetings: _source{fileGreetings, "msg"},
+			srcPusers:    _source{filePriveleged, "users"},
+			dataDir:      filepath.Join(dir, _DIR_DATA),
+			scriptsDir:   filepath.Join(dir, _DIR_SCRIPTS),
+		},
+		files: []string{
+			fileBanlist, fileGreetings, filePriveleged, fileToken},
+	}, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func findUnified(dir string) string {
+	for _, name := range _FILES_UNIFIED {
+		if path := filepath.Join(dir, name); exists(path) {
+			return path
+		}
+	}
 
-	raw, err := ioutil.ReadFile(_FILE_PRIVELEGED)
+	return ""
+}
+
+func hasConfig(dir string) bool {
+	return exists(filepath.Join(dir, _DIR_CONFIG)) || len(findUnified(dir)) > 0
+}
+
+/*
+	Without an explicit config dir, prefer the working directory (the old
+	behavior), then fall back to the directory holding the executable so
+	the bot can be started from anywhere.
+*/
+func resolveConfigDir(dir string) string {
+	if len(dir) > 0 {
+		return dir
+	}
+
+	if hasConfig(".") {
+		return "."
+	}
+
+	if exe, err := os.Executable(); err == nil {
+		if exeDir := filepath.Dir(exe); hasConfig(exeDir) {
+			return exeDir
+		}
+	}
+
+	return "."
+}
+
+func validateUsers(src _source, users []string) error {
+	for i, user := range users {
+		if !strings.Contains(user, "#") || strings.HasPrefix(user, "#") {
+			return errConfig(_source{src.file, fmt.Sprintf("%s[%d]", src.field, i)},
+				"'%s' must be of the form name#Gateway", user)
+		}
+	}
+
+	return nil
+}
+
+/* Ban list entries may also be patterns, or exemptions. See `ban.Parse`. */
+func validateBans(src _source, entries []string) error {
+	for i, entry := range entries {
+		field := _source{src.file, fmt.Sprintf("%s[%d]", src.field, i)}
+
+		if _, err := ban.Parse(entry); err != nil {
+			return errConfig(field, "%v", err)
+		}
+
+		name := strings.TrimPrefix(entry, "!")
+		if !ban.IsPattern(name) && (!strings.Contains(name, "#") || strings.HasPrefix(name, "#")) {
+			return errConfig(field, "'%s' must be of the form name#Gateway, or a pattern", entry)
+		}
+	}
+
+	return nil
+}
+
+/* Reserved for addressing every bot from the console */
+const _INSTANCE_ALL = "ALL"
+
+func validateAnnouncements(src _source, announcements []_announcement) error {
+	for i, announcement := range announcements {
+		field := func(name string) _source {
+			return _source{src.file, fmt.Sprintf("%s[%d].%s", src.field, i, name)}
+		}
+
+		if len(strings.TrimSpace(announcement.Message)) == 0 {
+			return errConfig(field("message"), "must not be empty")
+		}
+
+		if len(announcement.Every) > 0 == (len(announcement.Cron) > 0) {
+			return errConfig(field("every"), "exactly one of 'every' or 'cron' must be set")
+		}
+
+		if len(announcement.Every) > 0 {
+			every, err := time.ParseDuration(announcement.Every)
+			if err != nil {
+				return errConfig(field("every"), "%v", err)
+			}
+
+			if every < _ANNOUNCEMENT_MIN_INTERVAL {
+				return errConfig(field("every"), "must be at least %v", _ANNOUNCEMENT_MIN_INTERVAL)
+			}
+		}
+
+		if len(announcement.Cron) > 0 {
+			if _, err := cron.Parse(announcement.Cron); err != nil {
+				return errConfig(field("cron"), "%v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validatePlugins(src _source, plugins []_plugin, names map[string]interface{}) error {
+	for i, plugin := range plugins {
+		field := func(name string) _source {
+			return _source{src.file, fmt.Sprintf("%s[%d].%s", src.field, i, name)}
+		}
+
+		name := strings.ToUpper(plugin.Name)
+		if len(name) == 0 || strings.ContainsAny(name, " \t") {
+			return errConfig(field("name"),
+				"'%s' must be a non-empty name without spaces", plugin.Name)
+		}
+
+		if _, ok := names[name]; ok {
+			return errConfig(field("name"), "'%s' is already in use", plugin.Name)
+		}
+		names[name] = nil
+
+		if len(strings.TrimSpace(plugin.Command)) == 0 {
+			return errConfig(field("command"), "must not be empty")
+		}
+
+	scopes:
+		for _, scope := range plugin.Scopes {
+			for _, known := range _PLUGIN_SCOPES {
+				if strings.EqualFold(scope, known) {
+					continue scopes
+				}
+			}
+
+			return errConfig(field("scopes"), "unknown scope '%s'. Expected one of: %s",
+				scope, strings.Join(_PLUGIN_SCOPES, ", "))
+		}
+	}
+
+	return nil
+}
+
+func (c *_ircConfig) validate(src _source) error {
+	field := func(name string) _source {
+		return _source{src.file, src.field + "." + name}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return errConfig(field("server"), "expected host:port: %v", err)
+	}
+
+	if len(c.Nick) == 0 || strings.ContainsAny(c.Nick, " \t") {
+		return errConfig(field("nick"), "'%s' must be a non-empty nick without spaces", c.Nick)
+	}
+
+	if !strings.HasPrefix(c.Channel, "#") && !strings.HasPrefix(c.Channel, "&") {
+		return errConfig(field("channel"), "'%s' must start with '#' or '&'", c.Channel)
+	}
+
+	for name := range c.Nicks {
+		if !strings.Contains(name, "#") {
+			return errConfig(field("nicks"),
+				"'%s' should be of the form name#Gateway", name)
+		}
+	}
+
+	if len(c.RateLimit) == 0 {
+		c.RateLimit = _IRC_RATE_LIMIT
+	}
+	rate, err := time.ParseDuration(c.RateLimit)
 	if err != nil {
-		return &_privelegedusers{}, err
+		return errConfig(field("rate_limit"), "%v", err)
+	}
+	if rate < _IRC_MIN_RATE_LIMIT {
+		return errConfig(field("rate_limit"), "must be at least %v", _IRC_MIN_RATE_LIMIT)
 	}
 
-	if err := yaml.Unmarshal(raw, &privelege); err != nil {
-		return &_privelegedusers{}, err
+	return nil
+}
+
+func validateWebhooks(src _source, hooks []_webhookConfig) error {
+	for i, hook := range hooks {
+		field := func(name string) _source {
+			return _source{src.file, fmt.Sprintf("%s[%d].%s", src.field, i, name)}
+		}
+
+		if _, err := webhook.NewHook(hook.Url, hook.Template); err != nil {
+			return errConfig(field("url"), "%v", err)
+		}
+
+		if len(hook.Events) == 0 {
+			return errConfig(field("events"), "must list at least one of: %s",
+				strings.Join(webhook.EVENTS, ", "))
+		}
+
+	events:
+		for _, event := range hook.Events {
+			for _, known := range webhook.EVENTS {
+				if strings.EqualFold(event, known) {
+					if known == webhook.EVENT_KEYWORD && len(hook.Keywords) == 0 {
+						return errConfig(field("keywords"), "must not be empty for keyword events")
+					}
+					continue events
+				}
+			}
+
+			return errConfig(field("events"), "unknown event '%s'. Expected one of: %s",
+				event, strings.Join(webhook.EVENTS, ", "))
+		}
+
+		if err := validateUsers(field("watch"), hook.Watch); err != nil {
+			return err
+		}
 	}
 
-	return &privelege, nil
+	return nil
 }
 
-type _config struct {
-	blist     []string
-	greetings string
-	pusers    []string
+func (c *_inboundConfig) validate(src _source) error {
+	field := func(name string) _source {
+		return _source{src.file, src.field + "." + name}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return errConfig(field("listen"), "expected host:port: %v", err)
+	}
+
+	if len(c.Path) == 0 {
+		c.Path = "/"
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return errConfig(field("path"), "'%s' must start with '/'", c.Path)
+	}
+
+	if len(c.Secret) < _INBOUND_MIN_SECRET {
+		return errConfig(field("secret"), "must be at least %d characters", _INBOUND_MIN_SECRET)
+	}
+
+	return nil
+}
+
+func (c *_config) validate() error {
+	if len(c.bots) == 0 && len(strings.TrimSpace(c.apiKey)) == 0 {
+		return errConfig(c.srcApiKey, "api key must not be empty")
+	}
+
+	if len(c.bots) > 0 && len(c.apiKey) > 0 {
+		return errConfig(c.srcApiKey,
+			"must be set on each bot instead when a bots list is configured")
+	}
+
+	if err := validateBans(c.srcBlist, c.blist); err != nil {
+		return err
+	}
+
+	if err := validateUsers(c.srcPusers, c.pusers); err != nil {
+		return err
+	}
+
+	if err := validateAnnouncements(c.srcAnnouncements, c.announcements); err != nil {
+		return err
+	}
+
+	if err := c.federation.validate(c.srcFederation); err != nil {
+		return err
+	}
+
+	if err := c.connection.validate(c.srcConnection); err != nil {
+		return err
+	}
+
+	if err := c.moderation.validate(c.srcModeration); err != nil {
+		return err
+	}
+
+	if err := validatePlugins(c.srcPlugins, c.plugins, make(map[string]interface{})); err != nil {
+		return err
+	}
+
+	if c.irc != nil {
+		if len(c.bots) > 0 {
+			return errConfig(c.srcIRC,
+				"must be set on each bot instead when a bots list is configured")
+		}
+
+		if err := c.irc.validate(c.srcIRC); err != nil {
+			return err
+		}
+	}
+
+	if err := validateWebhooks(c.srcWebhooks, c.webhooks); err != nil {
+		return err
+	}
+
+	if c.inbound != nil {
+		if len(c.bots) > 0 {
+			return errConfig(c.srcInbound,
+				"must be set on each bot instead when a bots list is configured")
+		}
+
+		if err := c.inbound.validate(c.srcInbound); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]interface{})
+	for _, bot := range c.bots {
+		name := strings.ToUpper(bot.name)
+
+		if len(name) == 0 || strings.ContainsAny(name, " \t") {
+			return errConfig(bot.srcName,
+				"'%s' must be a non-empty name without spaces", bot.name)
+		}
+
+		if _, ok := names[name]; ok || strings.Compare(name, _INSTANCE_ALL) == 0 {
+			return errConfig(bot.srcName,
+				"'%s' is already in use", bot.name)
+		}
+		names[name] = nil
+
+		if len(strings.TrimSpace(bot.apiKey)) == 0 {
+			return errConfig(bot.srcApiKey, "api key must not be empty")
+		}
+
+		if err := validateBans(bot.srcBlist, bot.blist); err != nil {
+			return err
+		}
+
+		if err := validateUsers(bot.srcPusers, bot.pusers); err != nil {
+			return err
+		}
+
+		if err := validateAnnouncements(bot.srcAnnouncements, bot.announcements); err != nil {
+			return err
+		}
+
+		/* A bot's plugins share names with the top level ones */
+		plugins := make(map[string]interface{})
+		for _, plugin := range c.plugins {
+			plugins[strings.ToUpper(plugin.Name)] = nil
+		}
+		if err := validatePlugins(bot.srcPlugins, bot.plugins, plugins); err != nil {
+			return err
+		}
+
+		if bot.irc != nil {
+			if err := bot.irc.validate(bot.srcIRC); err != nil {
+				return err
+			}
+		}
+
+		if err := validateWebhooks(bot.srcWebhooks, bot.webhooks); err != nil {
+			return err
+		}
+
+		if bot.inbound != nil {
+			if err := bot.inbound.validate(bot.srcInbound); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *_federationConfig) validate(src _source) error {
+	field := func(name string) _source {
+		return _source{src.file, src.field + "." + name}
+	}
+
+	if f.Interval < 0 {
+		return errConfig(field("interval"), "must not be negative")
+	}
+
+	if len(f.Serve) > 0 && len(f.ServeFile) == 0 {
+		return errConfig(field("serve_file"), "must be set to serve a feed")
+	}
+
+	if len(f.Serve) > 0 && len(f.Secret) < _INBOUND_MIN_SECRET {
+		return errConfig(field("secret"), "must be at least %d characters to serve a feed",
+			_INBOUND_MIN_SECRET)
+	}
+
+	for i, feed := range f.Feeds {
+		if len(strings.TrimSpace(feed.Location)) == 0 {
+			return errConfig(field(fmt.Sprintf("feeds[%d].location", i)),
+				"must not be empty")
+		}
+
+		if _, err := federation.ParseTrust(feed.Trust); err != nil {
+			return errConfig(field(fmt.Sprintf("feeds[%d].trust", i)), "%v", err)
+		}
+
+		if feed.Publish && len(f.Name) == 0 {
+			return errConfig(field("name"), "must be set to publish to a feed")
+		}
+
+		if feed.Publish && federation.IsUrl(feed.Location) && len(feed.Secret) == 0 {
+			return errConfig(field(fmt.Sprintf("feeds[%d].secret", i)),
+				"must be set to publish to a feed over http")
+		}
+	}
+
+	return nil
+}
+
+func (c *_connectionConfig) validate(src _source) error {
+	for i, s := range c.Pins {
+		if _, err := pin.Parse(s); err != nil {
+			return errConfig(_source{src.file, fmt.Sprintf("%s.pins[%d]", src.field, i)},
+				"%v", err)
+		}
+	}
+
+	if c.Proxy != proxy.DIRECT && len(c.Proxy) > 0 {
+		if _, err := proxy.Parse(c.Proxy); err != nil {
+			return errConfig(_source{src.file, src.field + ".proxy"}, "%v", err)
+		}
+	}
+
+	if err := c.EndpointConfig().Validate(); err != nil {
+		return errConfig(src, "%v", err)
+	}
+
+	return nil
 }
 
-func (c *_config) Blist() []string {
-	return c.blist
+func (c *_moderationConfig) validate(src _source) error {
+	if c.Votes != nil {
+		if err := c.Votes.validate(_source{src.file, src.field + ".votes"}); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Strikes.validate(_source{src.file, src.field + ".strikes"}); err != nil {
+		return err
+	}
+
+	if err := c.Idle.validate(_source{src.file, src.field + ".idle"}); err != nil {
+		return err
+	}
+
+	return validateUsers(_source{src.file, src.field + ".lockdown.allow"}, c.Lockdown.Allow)
 }
 
-func (c *_config) Greetings() string {
-	return c.greetings
+func (c *_idleConfig) validate(src _source) error {
+	field := func(name string) _source {
+		return _source{src.file, src.field + "." + name}
+	}
+
+	if len(c.KickAfter) > 0 {
+		after, err := time.ParseDuration(c.KickAfter)
+		if err != nil {
+			return errConfig(field("kick_after"), "%v", err)
+		}
+		if after < _IDLE_MIN_KICK_AFTER {
+			return errConfig(field("kick_after"), "must be at least %v", _IDLE_MIN_KICK_AFTER)
+		}
+	}
+
+	if c.Capacity == 0 {
+		c.Capacity = _IDLE_CAPACITY
+	}
+	if c.Capacity < 2 {
+		return errConfig(field("capacity"), "must be at least 2")
+	}
+
+	if c.Headroom == 0 {
+		c.Headroom = _IDLE_HEADROOM
+	}
+	if c.Headroom < 1 || c.Headroom >= c.Capacity {
+		return errConfig(field("headroom"), "must be at least 1, and less than capacity (%d)", c.Capacity)
+	}
+
+	return nil
 }
 
-func (c *_config) Pusers() []string {
-	return c.pusers
+func (c *_strikesConfig) validate(src _source) error {
+	field := func(name string) _source {
+		return _source{src.file, src.field + "." + name}
+	}
+
+	if c.Kick == 0 {
+		c.Kick = _STRIKES_KICK
+	}
+	if c.Kick < 1 {
+		return errConfig(field("kick"), "must be at least 1")
+	}
+
+	if c.Ban == 0 {
+		c.Ban = _STRIKES_BAN
+	}
+	if c.Ban <= c.Kick {
+		return errConfig(field("ban"), "must be more than kick (%d)", c.Kick)
+	}
+
+	if len(c.BanFor) == 0 {
+		c.BanFor = _STRIKES_BAN_FOR
+	}
+	banFor, err := time.ParseDuration(c.BanFor)
+	if err != nil {
+		return errConfig(field("ban_for"), "%v", err)
+	}
+	if banFor < _STRIKES_MIN_BAN_FOR {
+		return errConfig(field("ban_for"), "must be at least %v", _STRIKES_MIN_BAN_FOR)
+	}
+
+	if len(c.Decay) == 0 {
+		c.Decay = _STRIKES_DECAY
+	}
+	decay, err := time.ParseDuration(c.Decay)
+	if err != nil {
+		return errConfig(field("decay"), "%v", err)
+	}
+	if decay < 0 {
+		return errConfig(field("decay"), "must not be negative")
+	}
+
+	return nil
 }
 
-func readConfig() (*_config, error) {
-	var config _config
+func (c *_votesConfig) validate(src _source) error {
+	field := func(name string) _source {
+		return _source{src.file, src.field + "." + name}
+	}
 
-	blist, err := readBanlist()
+	if len(c.Duration) == 0 {
+		c.Duration = _VOTE_DURATION
+	}
+	duration, err := time.ParseDuration(c.Duration)
 	if err != nil {
-		return &_config{}, err
+		return errConfig(field("duration"), "%v", err)
+	}
+	if duration < _VOTE_MIN_DURATION {
+		return errConfig(field("duration"), "must be at least %v", _VOTE_MIN_DURATION)
+	}
+
+	/* Nobody gets to kick on their own */
+	if c.Quorum == 0 {
+		c.Quorum = _VOTE_QUORUM
+	}
+	if c.Quorum < 2 {
+		return errConfig(field("quorum"), "must be at least 2")
+	}
+
+	if c.Ratio == 0 {
+		c.Ratio = _VOTE_RATIO
+	}
+	if c.Ratio <= 0.5 || c.Ratio > 1 {
+		return errConfig(field("ratio"), "must be more than 0.5, and at most 1")
 	}
 
-	greetings, err := readGreetings()
+	if len(c.Cooldown) == 0 {
+		c.Cooldown = _VOTE_COOLDOWN
+	}
+	cooldown, err := time.ParseDuration(c.Cooldown)
 	if err != nil {
-		return &_config{}, err
+		return errConfig(field("cooldown"), "%v", err)
+	}
+	if cooldown < 0 {
+		return errConfig(field("cooldown"), "must not be negative")
+	}
+
+	return nil
+}
+
+func readConfig(args *_args) (*_config, error) {
+	var config *_config
+	var err error
+
+	dir := resolveConfigDir(args.ConfigDir())
+	path := args.ConfigFile()
+	if len(path) == 0 {
+		path = findUnified(dir)
 	}
 
-	pusers, err := readPrivelege()
+	if len(path) > 0 {
+		config, err = readUnified(path)
+	} else {
+		config, err = readLegacy(dir)
+	}
 	if err != nil {
 		return &_config{}, err
 	}
 
-	config.blist = blist.Users
-	config.greetings = greetings.Msg
-	config.pusers = pusers.Users
+	applyEnv(config)
+
+	if err := config.validate(); err != nil {
+		return &_config{}, err
+	}
 
-	return &config, nil
+	return config, nil
 }
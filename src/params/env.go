@@ -0,0 +1,79 @@
+package params
+
+import (
+	"os"
+	"strings"
+)
+
+/*
+	Environment variables that override values read from config files.
+	Lists are comma separated, e.g.
+	`PEONBOT_BAN_LIST=name1#Azeroth,name2#USEast`.
+
+	The api key of a bot in a `bots` list is overridden by
+	`PEONBOT_<NAME>_API_KEY`, e.g. `PEONBOT_CLAN_API_KEY` for a bot named
+	`clan`.
+*/
+
+const _ENV_API_KEY = "PEONBOT_API_KEY"
+const _ENV_GREETINGS = "PEONBOT_GREETINGS"
+const _ENV_BANLIST = "PEONBOT_BAN_LIST"
+const _ENV_PRIVELEGED = "PEONBOT_PRIVELEGED_LIST"
+const _ENV_PREFIX = "PEONBOT_"
+const _ENV_SUFFIX_API_KEY = "_API_KEY"
+
+func envSource(name string) _source {
+	return _source{file: "$" + name, field: name}
+}
+
+func splitEnvList(value string) []string {
+	var list []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+/* Upper case, with anything that is not a letter or digit replaced by '_' */
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+}
+
+func applyEnv(config *_config) {
+	if value, ok := os.LookupEnv(_ENV_API_KEY); ok {
+		config.apiKey = value
+		config.srcApiKey = envSource(_ENV_API_KEY)
+	}
+
+	if value, ok := os.LookupEnv(_ENV_GREETINGS); ok {
+		config.greetings = value
+		config.srcGreetings = envSource(_ENV_GREETINGS)
+	}
+
+	if value, ok := os.LookupEnv(_ENV_BANLIST); ok {
+		config.blist = splitEnvList(value)
+		config.srcBlist = envSource(_ENV_BANLIST)
+	}
+
+	if value, ok := os.LookupEnv(_ENV_PRIVELEGED); ok {
+		config.pusers = splitEnvList(value)
+		config.srcPusers = envSource(_ENV_PRIVELEGED)
+	}
+
+	for _, bot := range config.bots {
+		name := _ENV_PREFIX + envName(bot.name) + _ENV_SUFFIX_API_KEY
+		if value, ok := os.LookupEnv(name); ok {
+			bot.apiKey = value
+			bot.srcApiKey = envSource(name)
+		}
+	}
+}
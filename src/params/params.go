@@ -1,6 +1,9 @@
 package params
 
-import "log"
+import (
+	"log"
+	"strings"
+)
 
 type _params struct {
 	Args   *_args
@@ -22,14 +25,11 @@ func New() (*_params, error) {
 	var err error
 
 	params.Args = getArgs()
-	params.Config, err = readConfig()
-	if err != nil {
-		return &_params{}, err
-	}
-	params.token, err = readToken()
+	params.Config, err = readConfig(params.Args)
 	if err != nil {
 		return &_params{}, err
 	}
+	params.token = params.Config.apiKey
 
 	if params.Args.Verbose() {
 		log.Printf("Args: %+v\n", params.Args)
@@ -39,3 +39,38 @@ func New() (*_params, error) {
 
 	return &params, nil
 }
+
+/*
+	Re-read the config files with the same command line args. The current
+	config is kept if the new one fails to load or validate. The token is
+	not reloaded, since changing it requires a new connection, and neither
+	are bots that were added to or removed from the config.
+*/
+func (p *_params) Reload() error {
+	config, err := readConfig(p.Args)
+	if err != nil {
+		return err
+	}
+
+	/* Bots are only started and connected once, so warn about changes */
+	for _, instance := range config.Instances() {
+		previous := p.Config.Instance(instance.Name())
+		if previous == nil {
+			log.Printf("Bot '%s' added to config. Restart to start it.\n",
+				instance.Name())
+			continue
+		}
+
+		if strings.Compare(instance.Token(), previous.Token()) != 0 {
+			log.Printf("Api key changed in config. Restart the bot to use it.\n")
+		}
+	}
+
+	p.Config = config
+
+	if p.Args.Verbose() {
+		log.Printf("Reloaded config: %+v\n", p.Config)
+	}
+
+	return nil
+}
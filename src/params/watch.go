@@ -0,0 +1,68 @@
+package params
+
+import (
+	"os"
+	"time"
+)
+
+/*
+	Polls the config files for changes. Polling is used instead of
+	filesystem notifications so that editors which replace a file on save
+	are handled the same as ones that write it in place.
+*/
+
+const _WATCH_INTERVAL = time.Duration(2) * time.Second
+
+type _fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampFiles(files []string) map[string]_fileStamp {
+	stamps := make(map[string]_fileStamp)
+
+	for _, file := range files {
+		/* A missing file gets a zero stamp, so it changes once it is back */
+		info, err := os.Stat(file)
+		if err != nil {
+			stamps[file] = _fileStamp{}
+			continue
+		}
+
+		stamps[file] = _fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	return stamps
+}
+
+func stampsChanged(before map[string]_fileStamp, after map[string]_fileStamp) bool {
+	for file, stamp := range after {
+		if before[file] != stamp {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	Watch the files the config was read from, and call `onChange` whenever
+	any of them is modified. Blocks forever, so start it on its own
+	goroutine.
+*/
+func (p *_params) Watch(onChange func()) {
+	watchFiles(p.Config.Files(), _WATCH_INTERVAL, onChange)
+}
+
+func watchFiles(files []string, interval time.Duration, onChange func()) {
+	stamps := stampFiles(files)
+
+	for range time.Tick(interval) {
+		latest := stampFiles(files)
+		if stampsChanged(stamps, latest) {
+			onChange()
+		}
+
+		stamps = latest
+	}
+}
@@ -0,0 +1,41 @@
+package params
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFilesChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), _FILE_BANLIST)
+	writeTestFile(t, path, "users:\n")
+
+	changed := make(chan struct{}, 1)
+	go watchFiles([]string{path}, time.Duration(10)*time.Millisecond, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	writeTestFile(t, path, "users:\n  - BannedUser#USEast\n")
+
+	select {
+	case <-changed:
+	case <-time.After(time.Duration(2) * time.Second):
+		t.Errorf("Expected a change to be reported, but none was.")
+	}
+}
+
+func TestStampsChangedMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), _FILE_BANLIST)
+
+	before := stampFiles([]string{path})
+	writeTestFile(t, path, "users:\n")
+	after := stampFiles([]string{path})
+
+	if !stampsChanged(before, after) {
+		t.Errorf("A file that was created should count as changed.")
+	}
+}
@@ -1,8 +1,20 @@
 package peonbot
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"peonbot/ban"
+	"peonbot/endpoint"
+	"peonbot/irc"
+	"peonbot/pin"
+	"peonbot/plugin"
+	"peonbot/script"
+	"peonbot/session"
 	"peonbot/verbose"
+	"peonbot/webhook"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -16,35 +28,106 @@ type WebsocketClient interface {
 	WriteJSON(interface{}) error
 }
 
+/*
+	A bot's state is owned by its event loop. Only the event loop reads or
+	changes it once the loop is running, and other goroutines (reading the
+	websocket, stdin, plugins, the IRC bridge, webhooks, ban feeds, and the
+	terminal UI) hand it work over channels instead. Setup, i.e. the Set
+	and Add methods and `Start`, happens before the loop is started.
+
+	Every goroutine the bot starts returns once `ctx` is done, which it is
+	when the event loop returns, or `Stop` is called.
+*/
 type _bot struct {
+	Printf  func(string, ...interface{})
 	Vprintf func(string, ...interface{})
 
+	name string /* set when running more than one bot in a process */
+
 	token string
+	pins  []pin.Pin /* battle.net certificate pins, none to only check the name */
+	proxy string    /* proxy url, or proxy.DIRECT; empty for the environment's */
+
+	endpoints []endpoint.Endpoint /* tried in order; v1's if none */
+	version   string              /* API version of the endpoint connected to */
 
 	Conn      *websocket.Conn
+	keepalive *_keepalive /* pings on Conn */
+	health    string      /* one of the STATE_ constants, see setState */
+	lastEvent time.Time   /* when an event was last read */
+	connected time.Time   /* when the bot last joined its channel */
+	early     [][]byte    /* events read while logging in, for `listen` */
+
+	reconnecting bool /* while `reconnect` logs in off the event loop */
+
 	userTable map[int]string
-	rid       int /* request id used to communicate with bot API */
+	userFlags map[int][]string /* flags of users that have any, e.g. Moderator */
+	rid       int              /* request id used to communicate with bot API */
 
 	chbnt chan []byte /* responses from websocket */
 	cherr chan error
-	chsin chan string /* string input from stdin */
+	chsin chan string   /* string input from stdin */
+	chrld chan struct{} /* config reload requests */
+	chfed chan _feedUpdate
+	chplg chan plugin.Request  /* action requests from plugins */
+	chirc chan irc.Message     /* messages from the bridged IRC channel */
+	chweb chan webhook.Message /* messages from the inbound webhook */
+	chsnp chan chan Snapshot   /* snapshot requests, e.g. from the terminal UI */
+	chrcn chan _reconnect      /* new connections made by `reconnect` */
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	recorder *session.Recorder
+	replay   *_replayClient /* set while replaying a recorded session */
+
+	fedName string /* source name bans are published under */
+	feeds   []*_feed
+
+	dataDir    string
+	unreadable map[string]error /* data files that could not be loaded, so are never saved */
+	schedules  []*_schedule
+	scheduleId int /* last schedule id handed out */
+	strikes    []*_struck
+	escalate   _strikePolicy /* when strikes are acted on */
+	commands   _commands
+	plugins    []*_plugin
+	scripts    *script.Engine
+	bridge     *_bridge
+	webhooks   []*_webhook
+	sender     *webhook.Sender
+	voting     *_voting /* nil unless `.votekick` is enabled */
+	lockdown   _lockdown
+	idle       _idle
+	polls      []*_poll /* the last one is open, unless closed */
+
+	channel string /* name of the channel the bot is in */
 
 	blist     map[string]interface{}
+	rules     []*ban.Rule /* `blist` parsed, nil until needed again, see banRules */
 	greetings string
 	pusers    map[string]interface{}
+
+	configBlist  map[string]interface{} /* the part of `blist` read from config */
+	configPusers map[string]interface{} /* the part of `pusers` read from config */
 }
 
 const _PEONBOT_USERID = -59
 const _PEONBOT_USERNAME = "*SELF"
 
+/* How often the event loop runs timed work, e.g. scheduled announcements */
+const _TICK_INTERVAL = time.Second
+
 func New(token string, blist []string, greetings string, pusers []string) *_bot {
 	var bot _bot
 
+	bot.Printf = log.Printf
 	bot.Vprintf = verbose.Vprintf
 
 	bot.token = token
 
 	bot.userTable = make(map[int]string)
+	bot.userFlags = make(map[int][]string)
 	bot.addSelfToUserTable()
 
 	bot.rid = 0
@@ -52,6 +135,15 @@ func New(token string, blist []string, greetings string, pusers []string) *_bot
 	bot.chbnt = make(chan []byte)
 	bot.cherr = make(chan error)
 	bot.chsin = make(chan string)
+	bot.chrld = make(chan struct{}, 1)
+	bot.chfed = make(chan _feedUpdate)
+	bot.chplg = make(chan plugin.Request)
+	bot.chirc = make(chan irc.Message)
+	bot.chweb = make(chan webhook.Message, _WEBHOOK_INBOUND_QUEUE)
+	bot.chsnp = make(chan chan Snapshot)
+	bot.chrcn = make(chan _reconnect)
+
+	bot.ctx, bot.cancel = context.WithCancel(context.Background())
 
 	bot.blist = make(map[string]interface{})
 	bot.addToBanlist(blist...)
@@ -64,11 +156,34 @@ func New(token string, blist []string, greetings string, pusers []string) *_bot
 	bot.addPrivToSelf()
 	bot.addPrivelegedUsers(pusers...)
 
+	bot.configBlist = toBanSet(blist...)
+	bot.configPusers = toUserSet(pusers...)
+
 	bot.Vprintf("%+v\n", bot)
 
 	return &bot
 }
 
+/*
+	Name the bot, and prefix everything it logs with its name, so the output
+	of several bots sharing a console can be told apart.
+*/
+func (bot *_bot) SetName(name string) {
+	bot.name = name
+
+	prefix := fmt.Sprintf("[%s] ", name)
+	bot.Printf = func(msg string, vargs ...interface{}) {
+		log.Printf(prefix+msg, vargs...)
+	}
+	bot.Vprintf = func(msg string, vargs ...interface{}) {
+		verbose.Vprintf(prefix+msg, vargs...)
+	}
+}
+
+func (bot *_bot) Name() string {
+	return bot.name
+}
+
 func (bot *_bot) addSelfToUserTable() {
 	bot.userTable[_PEONBOT_USERID] = _PEONBOT_USERNAME
 }
@@ -102,14 +217,16 @@ func (bot *_bot) rmPrivelegedUser(pusers ...string) {
 
 func (bot *_bot) addToBanlist(busers ...string) {
 	for _, buser := range busers {
-		bot.blist[strings.ToUpper(buser)] = nil
+		bot.blist[ban.Key(buser)] = nil
 	}
+	bot.banlistChanged()
 }
 
 func (bot *_bot) rmFromBanlist(busers ...string) {
 	for _, buser := range busers {
-		delete(bot.blist, strings.ToUpper(buser))
+		delete(bot.blist, ban.Key(buser))
 	}
+	bot.banlistChanged()
 }
 
 /* XXX: Greetings is not implemented. */
@@ -135,15 +252,138 @@ func (bot *_bot) Chsin() chan string {
 	return bot.chsin
 }
 
+func (bot *_bot) Chrld() chan struct{} {
+	return bot.chrld
+}
+
 func (bot *_bot) ListenWebsocket() {
+	bot.listen(bot.keepalive, bot.early)
+}
+
+/*
+	Pass on `early` events, then read from the connection until it fails,
+	or nothing is read in time, not even a pong
+*/
+func (bot *_bot) listen(k *_keepalive, early [][]byte) {
+	for _, data := range early {
+		if !bot.deliver(data) {
+			return
+		}
+	}
+
 	for {
-		_, data, err := bot.Conn.ReadMessage()
+		_, data, err := k.conn.ReadMessage()
 		if err != nil {
-			bot.cherr <- err
-			continue
+			select {
+			case bot.cherr <- &_connError{conn: k.conn, err: err}:
+			case <-bot.ctx.Done():
+			}
+			return
+		}
+		k.read()
+
+		if !bot.deliver(data) {
+			return
 		}
+	}
+}
+
+/* Hand a frame to the event loop. False if the bot has stopped. */
+func (bot *_bot) deliver(data []byte) bool {
+	bot.recordInbound(data)
 
-		bot.chbnt <- data
+	select {
+	case bot.chbnt <- data:
+		return true
+	case <-bot.ctx.Done():
+		return false
 	}
+}
+
+/* Stop the event loop, and with it every goroutine the bot started */
+func (bot *_bot) Stop() {
+	bot.cancel()
+}
+
+/*
+	Returns the ban list and priveleged user list of the named bot to apply
+	when the config is reloaded. Passed in by the caller so this package
+	does not need to know where config comes from.
+*/
+type ReloadFunc func(name string) ([]string, []string, error)
+
+/*
+	Handle events, stdin messages, reload requests, and timed work until
+	reading from the websocket fails, the api key is rejected when
+	reconnecting, or the bot is stopped. Returns why it ended, or nil if the
+	bot was stopped.
+*/
+func (bot *_bot) EventLoop(reload ReloadFunc) error {
+	ticker := time.NewTicker(_TICK_INTERVAL)
+	defer ticker.Stop()
+	defer bot.cancel()
+
+	for {
+		select {
+		case <-bot.ctx.Done():
+			bot.setState(STATE_DISCONNECTED, "stopped")
+			return nil
+		case event := <-bot.Chbnt():
+			bot.lastEvent = time.Now()
+			if err := bot.HandleEvent(event); err != nil {
+				bot.Vprintf("Got error from handling event: %v\n", err)
+			}
+		case err := <-bot.Cherr():
+			if bot.stale(err) {
+				continue
+			}
+			bot.Vprintf("Got error reading from websocket: %v\n", err)
+			bot.setState(STATE_DISCONNECTED, err.Error())
+			return err
+		case msg := <-bot.Chsin():
+			bot.HandleMessage(bot.client(), msg)
+		case <-bot.Chrld():
+			blist, pusers, err := reload(bot.Name())
+			if err != nil {
+				bot.Printf("Could not reload config: %v\n", err)
+				continue
+			}
+			bot.Reload(bot.client(), blist, pusers)
+		case update := <-bot.chfed:
+			bot.applyFeed(bot.client(), update)
+		case request := <-bot.chplg:
+			if err := bot.handlePluginRequest(bot.client(), request); err != nil {
+				bot.Printf("Could not process plugin request: %v\n", err)
+			}
+		case message := <-bot.chirc:
+			bot.relayFromIrc(bot.client(), message, time.Now())
+		case message := <-bot.chweb:
+			bot.handleInboundWebhook(bot.client(), message)
+		case ch := <-bot.chsnp:
+			ch <- bot.snapshot()
+		case result := <-bot.chrcn:
+			if err := bot.reconnected(result); err != nil {
+				bot.Printf("%v\n", err)
+			}
+			/* The old connection will not last long with a revoked key */
+			if IsAuthError(result.err) {
+				bot.setState(STATE_DISCONNECTED, result.err.Error())
+				return result.err
+			}
+		case now := <-ticker.C:
+			bot.tick(bot.client(), now)
+		}
+	}
+}
 
+/* Run timed work. Only call from the event loop. */
+func (bot *_bot) tick(client WebsocketClient, now time.Time) {
+	bot.checkHealth(now)
+	bot.runSchedules(client, now)
+	bot.runVotes(client, now)
+	bot.runPolls(client, now)
+	bot.runStrikes(client, now)
+	bot.runIdle(client, now)
+	bot.runCommands(now)
+	bot.loadScripts(client)
 }
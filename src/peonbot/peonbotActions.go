@@ -2,8 +2,11 @@ package peonbot
 
 import (
 	"fmt"
-	"log"
+	"peonbot/ban"
+	"peonbot/federation"
+	"peonbot/webhook"
 	"strings"
+	"time"
 )
 
 const _ACTION_KICK = ".KICK"
@@ -16,6 +19,13 @@ const _ACTION_ADDPRIV = ".ADDPRIV"
 const _ACTION_RMPRIV = ".RMPRIV"
 const _ACTION_ADDBAN = ".ADDBAN"
 const _ACTION_RMBAN = ".RMBAN"
+const _ACTION_RELOAD = ".RELOAD"
+
+/* Actions that do not take any parameters */
+var _ACTIONS_NO_PARAMS = map[string]interface{}{
+	_ACTION_RELOAD:   nil,
+	_ACTION_LISTCMDS: nil,
+}
 
 /*
 	XXX: Not implementing this any further until I can figure out how to
@@ -29,7 +39,23 @@ func errActionIgnoreIncomplete(message string) error {
 
 func (bot *_bot) errActionUserDne(errmsg string) error {
 	bot.Vprintf("Dumping user table: %v\n", bot.userTable)
-	return fmt.Errorf(errmsg)
+	return fmt.Errorf("%s", errmsg)
+}
+
+/* Whether `handleAction` takes the message as an action, even if it then fails */
+func (bot *_bot) isAction(event _event) bool {
+	if !bot.isPriveleged(event.Payload.UserId) {
+		return false
+	}
+
+	word := strings.ToUpper(strings.SplitN(event.Payload.Message, " ", 2)[0])
+	for _, action := range _STDIN_ACTIONS {
+		if strings.Compare(word, action) == 0 {
+			return true
+		}
+	}
+
+	return false
 }
 
 func handleAction(client WebsocketClient, bot *_bot, event _event) error {
@@ -46,9 +72,11 @@ func handleAction(client WebsocketClient, bot *_bot, event _event) error {
 	parts := strings.Split(event.Payload.Message, " ")
 	/*
 		Ensure action command has a sufficient amount of information. All
-		actions will have a .action verb, and at least one other parameter.
+		actions will have a .action verb, and at least one other parameter,
+		unless they are known to not take any.
 	*/
-	if len(parts) < 2 {
+	_, noParams := _ACTIONS_NO_PARAMS[strings.ToUpper(parts[0])]
+	if len(parts) < 2 && !noParams {
 		return errActionIgnoreIncomplete(
 			fmt.Sprintf("Insufficient amount of information: %v\n", parts))
 	}
@@ -139,6 +167,11 @@ func handleAction(client WebsocketClient, bot *_bot, event _event) error {
 		handleActionRmpriv(bot, target)
 		break
 	case _ACTION_ADDBAN:
+		if ban.IsPattern(parts[1]) {
+			handleActionBanPattern(client, bot, _ACTION_ADDBAN, parts[1], event)
+			break
+		}
+
 		target, acceptable := getTarget(client, bot, parts[1], event)
 		if !acceptable {
 			return errActionIgnoreIncomplete(
@@ -147,6 +180,11 @@ func handleAction(client WebsocketClient, bot *_bot, event _event) error {
 		handleActionAddBan(client, bot, target)
 		break
 	case _ACTION_RMBAN:
+		if ban.IsPattern(parts[1]) {
+			handleActionBanPattern(client, bot, _ACTION_RMBAN, parts[1], event)
+			break
+		}
+
 		target, acceptable := getTarget(client, bot, parts[1], event)
 		if !acceptable {
 			return errActionIgnoreIncomplete(
@@ -154,6 +192,108 @@ func handleAction(client WebsocketClient, bot *_bot, event _event) error {
 		}
 		handleActionRmBan(client, bot, target)
 		break
+	case _ACTION_RELOAD:
+		handleActionReload(bot)
+		break
+	case _ACTION_REMIND:
+		if err := handleActionRemind(client, bot, parts, event); err != nil {
+			return err
+		}
+		break
+	case _ACTION_SCHEDULE:
+		if err := handleActionSchedule(client, bot, parts, event); err != nil {
+			return err
+		}
+		break
+	case _ACTION_POLL:
+		if err := handleActionPoll(client, bot, event, time.Now()); err != nil {
+			return err
+		}
+		break
+	case _ACTION_ADDCMD:
+		if err := handleActionAddCmd(client, bot, event); err != nil {
+			return err
+		}
+		break
+	case _ACTION_RMCMD:
+		if err := handleActionRmCmd(client, bot, parts[1], event); err != nil {
+			return err
+		}
+		break
+	case _ACTION_ADDTRIGGER:
+		if err := handleActionAddTrigger(client, bot, event); err != nil {
+			return err
+		}
+		break
+	case _ACTION_RMTRIGGER:
+		if err := handleActionRmTrigger(client, bot, event); err != nil {
+			return err
+		}
+		break
+	case _ACTION_LISTCMDS:
+		handleActionListCmds(client, bot, event)
+		break
+	case _ACTION_WARN:
+		target, acceptable := getTarget(client, bot, parts[1], event)
+		if !acceptable {
+			return errActionIgnoreIncomplete(
+				fmt.Sprintf("Invalid target: %s", parts[1]))
+		}
+		handleActionWarn(client, bot, target, parts[2:], event, time.Now())
+		break
+	case _ACTION_STRIKES:
+		target, acceptable := getTarget(client, bot, parts[1], event)
+		if !acceptable {
+			return errActionIgnoreIncomplete(
+				fmt.Sprintf("Invalid target: %s", parts[1]))
+		}
+		handleActionStrikes(client, bot, target, event)
+		break
+	case _ACTION_CLEARSTRIKES:
+		target, acceptable := getTarget(client, bot, parts[1], event)
+		if !acceptable {
+			return errActionIgnoreIncomplete(
+				fmt.Sprintf("Invalid target: %s", parts[1]))
+		}
+		handleActionClearStrikes(client, bot, target, event)
+		break
+	case _ACTION_LOCKDOWN:
+		if err := handleActionLockdown(client, bot, parts[1], event); err != nil {
+			return err
+		}
+		break
+	case _ACTION_ALLOW:
+		target, acceptable := getTarget(client, bot, parts[1], event)
+		if !acceptable {
+			return errActionIgnoreIncomplete(
+				fmt.Sprintf("Invalid target: %s", parts[1]))
+		}
+		handleActionAllow(client, bot, target, event)
+		break
+	case _ACTION_DISALLOW:
+		target, acceptable := getTarget(client, bot, parts[1], event)
+		if !acceptable {
+			return errActionIgnoreIncomplete(
+				fmt.Sprintf("Invalid target: %s", parts[1]))
+		}
+		handleActionDisallow(client, bot, target, event)
+		break
+	case _ACTION_IDLE:
+		target, acceptable := getTarget(client, bot, parts[1], event)
+		if !acceptable {
+			return errActionIgnoreIncomplete(
+				fmt.Sprintf("Invalid target: %s", parts[1]))
+		}
+		handleActionIdle(client, bot, target, event, time.Now())
+		break
+	case _ACTION_BANTEST:
+		target, acceptable := getTarget(client, bot, parts[1], event)
+		if !acceptable {
+			return errActionIgnoreIncomplete(
+				fmt.Sprintf("Invalid target: %s", parts[1]))
+		}
+		handleActionBanTest(client, bot, target, event)
+		break
 	default:
 		return fmt.Errorf("Unrecognized action: %v", event)
 	}
@@ -203,6 +343,16 @@ func sendNotification(client WebsocketClient, bot *_bot, message string, event _
 	}
 }
 
+/* Reply to whoever sent the action. Actions from stdin are answered on the console. */
+func reply(client WebsocketClient, bot *_bot, event _event, message string) {
+	if event.Payload.UserId == _PEONBOT_USERID {
+		bot.Printf("[Bot log message] %s\n", message)
+		return
+	}
+
+	sendNotification(client, bot, message, event)
+}
+
 func handleActionKick(client WebsocketClient, bot *_bot, username string) error {
 	uid := bot.lookupUid(username)
 	if uid == -1 {
@@ -218,7 +368,12 @@ func _handleActionKick(client WebsocketClient, bot *_bot, uid int) error {
 	request := bot.createRequestKick(uid)
 	bot.Vprintf("Sending request: %+v\n", request)
 
-	return client.WriteJSON(request)
+	if err := client.WriteJSON(request); err != nil {
+		return err
+	}
+
+	bot.webhookModeration(webhook.EVENT_KICK, bot.userTable[uid])
+	return nil
 }
 
 func handleActionBan(client WebsocketClient, bot *_bot, username string) error {
@@ -236,14 +391,24 @@ func _handleActionBan(client WebsocketClient, bot *_bot, uid int) error {
 	request := bot.createRequestBan(uid)
 	bot.Vprintf("Sending request: %+v\n", request)
 
-	return client.WriteJSON(request)
+	if err := client.WriteJSON(request); err != nil {
+		return err
+	}
+
+	bot.webhookModeration(webhook.EVENT_BAN, bot.userTable[uid])
+	return nil
 }
 
 func handleActionUnban(client WebsocketClient, bot *_bot, username string) error {
 	request := bot.createRequestUnban(username)
 	bot.Vprintf("Sending request: %+v\n", request)
 
-	return client.WriteJSON(request)
+	if err := client.WriteJSON(request); err != nil {
+		return err
+	}
+
+	bot.webhookModeration(webhook.EVENT_UNBAN, username)
+	return nil
 }
 
 func handleActionSay(client WebsocketClient, bot *_bot, message ...string) error {
@@ -293,24 +458,33 @@ func _handleActionDesignate(client WebsocketClient, bot *_bot, uid int) error {
 
 func handleActionAddpriv(bot *_bot, target string) {
 	bot.addPrivelegedUsers(target)
-	log.Printf("[Bot log message] Privelege added: %s\n", target)
+	bot.Printf("[Bot log message] Privelege added: %s\n", target)
 }
 
 func handleActionRmpriv(bot *_bot, target string) {
 	bot.rmPrivelegedUser(target)
-	log.Printf("[Bot log message] Privelege removed: %s\n", target)
+	bot.Printf("[Bot log message] Privelege removed: %s\n", target)
 }
 
 func handleActionAddBan(client WebsocketClient, bot *_bot, target string) {
 	bot.addToBanlist(target)
-	log.Printf("[Bot log message] Added to banlist: %s\n", target)
-	if _, ok := bot.blist[strings.ToUpper(target)]; ok {
+	bot.Printf("[Bot log message] Added to banlist: %s\n", target)
+	/* An exemption may still let them in */
+	if bot.banned(target) {
 		_ = handleActionBan(client, bot, target)
 	}
+	bot.publishBan(target, federation.ACTION_BAN)
 }
 
 func handleActionRmBan(client WebsocketClient, bot *_bot, target string) {
 	bot.rmFromBanlist(target)
-	log.Printf("[Bot log message] Removed from banlist: %s\n", target)
+	bot.liftTimedBan(target)
+	bot.Printf("[Bot log message] Removed from banlist: %s\n", target)
 	_ = handleActionUnban(client, bot, target)
+	bot.publishBan(target, federation.ACTION_UNBAN)
+}
+
+func handleActionReload(bot *_bot) {
+	bot.RequestReload()
+	bot.Printf("[Bot log message] Config reload requested.\n")
 }
@@ -0,0 +1,209 @@
+package peonbot
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+	Logging in: the bot authenticates with its api key, then asks to join
+	its channel, and the server answers each request with a response that
+	has a status if it failed. A rejected key is final, but other failures
+	are retried a few times, within a time limit.
+*/
+
+const _RESPONSE_AUTH = "Botapiauth.AuthenticateResponse"
+const _RESPONSE_CONN = "Botapichat.ConnectResponse"
+
+/* Status codes, as listed in the API documentation */
+const _STATUS_TIMED_OUT = 5
+const _STATUS_RATE_LIMITED = 8
+
+type _status struct {
+	Area int `json:"area"`
+	Code int `json:"code"`
+}
+
+func (s *_status) failed() bool {
+	return s != nil && s.Code != 0
+}
+
+func (s *_status) String() string {
+	var reason string
+	switch s.Code {
+	case _STATUS_TIMED_OUT:
+		reason = "request timed out"
+	case _STATUS_RATE_LIMITED:
+		reason = "rate limited"
+	default:
+		reason = "failed"
+	}
+
+	return fmt.Sprintf("%s (area %d, code %d)", reason, s.Area, s.Code)
+}
+
+/* The api key was rejected. Trying again will not help. */
+type _authError struct {
+	status *_status
+}
+
+func (e *_authError) Error() string {
+	return fmt.Sprintf(
+		"The api key was rejected, it may be invalid or revoked: %s", e.status)
+}
+
+/* Whether `err` is from the api key being rejected, e.g. to exit with */
+func IsAuthError(err error) bool {
+	_, ok := err.(*_authError)
+	return ok
+}
+
+type _retry struct {
+	attempts int           /* at most */
+	timeout  time.Duration /* for all attempts, including waiting */
+	backoff  time.Duration /* before the second attempt, doubling after */
+	response time.Duration /* to wait for each response */
+	limited  time.Duration /* to wait at least, when rate limited */
+}
+
+var _START_RETRY = _retry{
+	attempts: 5,
+	timeout:  2 * time.Minute,
+	backoff:  3 * time.Second,
+	response: 15 * time.Second,
+	limited:  30 * time.Second,
+}
+
+/*
+	Log in, until it succeeds, the key is rejected, or `retry` runs out.
+	Connections from failed attempts are closed.
+*/
+func (bot *_bot) login(retry _retry) error {
+	deadline := time.Now().Add(retry.timeout)
+	wait := retry.backoff
+
+	for attempt := 1; ; attempt++ {
+		before := bot.Conn
+		err := bot.loginOnce(retry.response)
+		if err == nil {
+			return nil
+		}
+
+		if bot.Conn != before {
+			_ = bot.Conn.Close()
+		}
+		bot.setState(STATE_DISCONNECTED, err.Error())
+
+		if IsAuthError(err) || attempt == retry.attempts {
+			return err
+		}
+
+		if limited, ok := err.(*_responseError); ok &&
+			limited.status.Code == _STATUS_RATE_LIMITED && wait < retry.limited {
+			wait = retry.limited
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("Gave up after %d attempts: %v", attempt, err)
+		}
+
+		bot.Printf("Could not log in (attempt %d of %d), trying again in %s: %v\n",
+			attempt, retry.attempts, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (bot *_bot) loginOnce(timeout time.Duration) error {
+	bot.setState(STATE_CONNECTING, "")
+	if err := bot.connect(); err != nil {
+		return err
+	}
+	bot.Printf("Connected: %s (API %s)\n", bot.Conn.UnderlyingConn().RemoteAddr(), bot.version)
+	/* Until the server says the bot joined its channel */
+	bot.setState(STATE_AUTHENTICATING, "")
+	bot.lastEvent = time.Now()
+	bot.early = nil
+
+	/* Requests are numbered as they are made */
+	if err := bot.authenticate(bot.client(), bot.Token()); err != nil {
+		return err
+	}
+	if err := bot.awaitResponse(_RESPONSE_AUTH, bot.rid, timeout); err != nil {
+		if failed, ok := err.(*_responseError); ok && !failed.retryable() {
+			return &_authError{status: failed.status}
+		}
+		return err
+	}
+
+	if err := bot.connectBot(bot.client()); err != nil {
+		return err
+	}
+
+	/* Joining the channel is as good as being told it will be */
+	return bot.awaitResponse(_RESPONSE_CONN, bot.rid, timeout, _EVENT_CONNECT)
+}
+
+/* A request failed, with the status the server gave */
+type _responseError struct {
+	command string
+	status  *_status
+}
+
+func (e *_responseError) Error() string {
+	return fmt.Sprintf("%s %s", e.command, e.status)
+}
+
+func (e *_responseError) retryable() bool {
+	return e.status.Code == _STATUS_TIMED_OUT || e.status.Code == _STATUS_RATE_LIMITED
+}
+
+/*
+	Read until the response to request `rid`, or one of `events`, within
+	`timeout`. Events read in the meantime are kept for `listen` to pass
+	on, as are `events`.
+*/
+func (bot *_bot) awaitResponse(command string, rid int, timeout time.Duration,
+	events ...string) error {
+
+	_ = bot.Conn.SetReadDeadline(time.Now().Add(timeout))
+	defer bot.keepalive.read()
+
+	for {
+		_, data, err := bot.Conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("No %s: %v", command, err)
+		}
+
+		event, err := bot.codec().decode(data)
+		if err != nil {
+			return fmt.Errorf("Could not decode %s: %v", command, err)
+		}
+
+		if event.Command != command {
+			bot.early = append(bot.early, data)
+			for _, done := range events {
+				if event.Command == done {
+					return nil
+				}
+			}
+			continue
+		}
+		if event.RequestId != rid {
+			bot.Vprintf("Got %s for request %d, expected %d\n", command, event.RequestId, rid)
+		}
+
+		if event.Status.failed() {
+			return &_responseError{command: command, status: event.Status}
+		}
+
+		return nil
+	}
+}
+
+/* Log responses to requests that failed, e.g. from being rate limited */
+func (bot *_bot) handleResponse(event _event) {
+	if event.Status.failed() {
+		bot.Printf("Request %d failed: %s\n", event.RequestId,
+			&_responseError{command: event.Command, status: event.Status})
+	}
+}
@@ -0,0 +1,173 @@
+package peonbot
+
+import (
+	"net/http"
+	"peonbot/endpoint"
+	"peonbot/pin/pintest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var _TEST_RETRY = _retry{
+	attempts: 3,
+	timeout:  5 * time.Second,
+	backoff:  10 * time.Millisecond,
+	response: 200 * time.Millisecond,
+	limited:  20 * time.Millisecond,
+}
+
+/*
+	Stands in for battle.net logging a bot in. `respond` is given the
+	number of the connection, and each request, and returns the frames to
+	answer with.
+*/
+func startLoginServer(t *testing.T, respond func(int, _request) []string) (*_bot, *int32) {
+	leaf, err := pintest.NewLeaf(nil, _X509_EXPECTED_NAME)
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
+
+	var connections int32
+	upgrader := &websocket.Upgrader{}
+	addr, stop := startTLSServer(leaf.TLS(), http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			n := int(atomic.AddInt32(&connections, 1))
+
+			for {
+				var request _request
+				if err := conn.ReadJSON(&request); err != nil {
+					return
+				}
+				for _, frame := range respond(n, request) {
+					_ = conn.WriteMessage(websocket.TextMessage, []byte(frame))
+				}
+			}
+		}))
+	t.Cleanup(stop)
+
+	bot := getTestbot()
+	bot.endpoints = []endpoint.Endpoint{endpointAt(addr)}
+	t.Cleanup(func() {
+		if bot.Conn != nil {
+			bot.Conn.Close()
+		}
+	})
+
+	return bot, &connections
+}
+
+const _TEST_AUTH_OK = `{"command": "Botapiauth.AuthenticateResponse", "request_id": 1}`
+const _TEST_CONN_OK = `{"command": "Botapichat.ConnectResponse", "request_id": 2}`
+const _TEST_CONNECTED = `{"command": "Botapichat.ConnectEventRequest", "request_id": 1, "payload": {"channel": "Clan Peon"}}`
+
+func TestLogin(t *testing.T) {
+	bot, connections := startLoginServer(t, func(n int, request _request) []string {
+		if request.Command == _REQUEST_AUTH {
+			return []string{_TEST_AUTH_OK}
+		}
+		/* The channel is joined before the response says it will be */
+		return []string{_TEST_CONNECTED, _TEST_CONN_OK}
+	})
+
+	if err := bot.login(_TEST_RETRY); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+
+	if atomic.LoadInt32(connections) != 1 {
+		t.Errorf("Expected: 1 connection, Actual: %d", atomic.LoadInt32(connections))
+	}
+	if bot.state() != STATE_AUTHENTICATING {
+		t.Errorf("Expected: %s, Actual: %s", STATE_AUTHENTICATING, bot.state())
+	}
+	if len(bot.early) != 1 || string(bot.early[0]) != _TEST_CONNECTED {
+		t.Errorf("Expected: connect event kept, Actual: %s", bot.early)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	bot, connections := startLoginServer(t, func(n int, request _request) []string {
+		return []string{`{"command": "Botapiauth.AuthenticateResponse", "request_id": 1,
+			"status": {"area": 6, "code": 2}}`}
+	})
+
+	err := bot.login(_TEST_RETRY)
+	if !IsAuthError(err) || !strings.Contains(err.Error(), "invalid or revoked") {
+		t.Errorf("Expected: api key rejected, Actual: %v", err)
+	}
+	if atomic.LoadInt32(connections) != 1 {
+		t.Errorf("Expected: no retries, Actual: %d connections", atomic.LoadInt32(connections))
+	}
+	if bot.state() != STATE_DISCONNECTED {
+		t.Errorf("Expected: %s, Actual: %s", STATE_DISCONNECTED, bot.state())
+	}
+}
+
+func TestLoginRateLimited(t *testing.T) {
+	bot, connections := startLoginServer(t, func(n int, request _request) []string {
+		if n == 1 {
+			return []string{`{"command": "Botapiauth.AuthenticateResponse", "request_id": 1,
+				"status": {"area": 6, "code": 8}}`}
+		}
+		if request.Command == _REQUEST_AUTH {
+			return []string{_TEST_AUTH_OK}
+		}
+		/* Without a response, joining the channel is enough */
+		return []string{_TEST_CONNECTED}
+	})
+	lines := captureLog(bot)
+
+	if err := bot.login(_TEST_RETRY); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+	if atomic.LoadInt32(connections) != 2 {
+		t.Errorf("Expected: 2 connections, Actual: %d", atomic.LoadInt32(connections))
+	}
+	if output := strings.Join(*lines, ""); !strings.Contains(output, "rate limited (area 6, code 8)") {
+		t.Errorf("Expected: rate limiting logged, Actual: %s", output)
+	}
+}
+
+func TestLoginNoResponse(t *testing.T) {
+	bot, connections := startLoginServer(t, func(n int, request _request) []string {
+		return nil
+	})
+	_ = captureLog(bot)
+
+	err := bot.login(_TEST_RETRY)
+	if err == nil || IsAuthError(err) || !strings.Contains(err.Error(), "No "+_RESPONSE_AUTH) {
+		t.Errorf("Expected: no response, Actual: %v", err)
+	}
+	if atomic.LoadInt32(connections) != int32(_TEST_RETRY.attempts) {
+		t.Errorf("Expected: %d connections, Actual: %d", _TEST_RETRY.attempts, atomic.LoadInt32(connections))
+	}
+
+	/* Out of time before out of attempts */
+	retry := _TEST_RETRY
+	retry.timeout = time.Millisecond
+	if err := bot.login(retry); err == nil || !strings.Contains(err.Error(), "Gave up after 1 attempts") {
+		t.Errorf("Expected: to give up, Actual: %v", err)
+	}
+}
+
+func TestHandleResponse(t *testing.T) {
+	bot := getTestbot()
+	lines := captureLog(bot)
+
+	_ = bot.HandleEvent([]byte(`{"command": "Botapichat.SendMessageResponse", "request_id": 5}`))
+	_ = bot.HandleEvent([]byte(`{"command": "Botapichat.SendMessageResponse", "request_id": 6,
+		"status": {"area": 8, "code": 8}}`))
+
+	expected := "Request 6 failed: Botapichat.SendMessageResponse rate limited (area 8, code 8)\n"
+	if strings.Join(*lines, "") != expected {
+		t.Errorf("Expected: %s, Actual: %s", expected, *lines)
+	}
+}
@@ -0,0 +1,91 @@
+package peonbot
+
+import (
+	"fmt"
+	"peonbot/ban"
+	"time"
+)
+
+/*
+	Ban list entries can be patterns, e.g. `troll*#*`, or exemptions, e.g.
+	`!TrollHunter#Azeroth`, as well as names. See `ban.Parse`. Patterns are
+	only ever matched against users as they join, or are in the channel, so
+	removing one does not unban anyone, and they are not published to
+	federated feeds.
+*/
+
+const _ACTION_BANTEST = ".BANTEST"
+
+/*
+	The ban list as rules, sorted with `ban.Sort`. Entries that are not
+	valid are skipped. Parsed once, until the ban list changes.
+*/
+func (bot *_bot) banRules() []*ban.Rule {
+	if bot.rules != nil {
+		return bot.rules
+	}
+
+	rules := []*ban.Rule{}
+	for _, entry := range sortedUsers(bot.blist) {
+		rule, err := ban.Parse(entry)
+		if err != nil {
+			bot.Vprintf("Skipping ban list entry: %v\n", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	ban.Sort(rules)
+
+	bot.rules = rules
+	return rules
+}
+
+/* Call whenever `blist` changes, so its rules are parsed again */
+func (bot *_bot) banlistChanged() {
+	bot.rules = nil
+}
+
+/* The entry that decides whether the user is banned, if any, and whether they are */
+func (bot *_bot) banRule(user string) (*ban.Rule, bool) {
+	return ban.Find(bot.banRules(), user)
+}
+
+func (bot *_bot) banned(user string) bool {
+	_, banned := bot.banRule(user)
+	return banned
+}
+
+/* `.addban` and `.rmban` with a pattern or exemption, instead of a name */
+func handleActionBanPattern(client WebsocketClient, bot *_bot, action string, entry string, event _event) {
+	if _, err := ban.Parse(entry); err != nil {
+		reply(client, bot, event, err.Error())
+		return
+	}
+
+	if action == _ACTION_ADDBAN {
+		bot.addToBanlist(entry)
+		bot.Printf("[Bot log message] Added to banlist: %s\n", entry)
+	} else {
+		bot.rmFromBanlist(entry)
+		bot.Printf("[Bot log message] Removed from banlist: %s\n", entry)
+	}
+
+	/* Removing an exemption may ban someone, too */
+	bot.enforceBanlist(client)
+}
+
+/* `.bantest name#Gateway` says whether, and why, the user would be banned */
+func handleActionBanTest(client WebsocketClient, bot *_bot, target string, event _event) {
+	rule, banned := bot.banRule(target)
+
+	switch {
+	case banned:
+		reply(client, bot, event, fmt.Sprintf("%s would be banned by %s", target, rule.Entry))
+	case bot.timedBan(target, time.Now()):
+		reply(client, bot, event, fmt.Sprintf("%s is serving a timed ban", target))
+	case rule != nil:
+		reply(client, bot, event, fmt.Sprintf("%s is exempt by %s", target, rule.Entry))
+	default:
+		reply(client, bot, event, fmt.Sprintf("%s is not banned", target))
+	}
+}
@@ -0,0 +1,128 @@
+package peonbot
+
+import (
+	"peonbot/federation"
+	"strings"
+	"testing"
+)
+
+func TestBanPatterns(t *testing.T) {
+	bot := getTestbot()
+	bot.addToBanlist("troll*#*", `/^spam\d+#/`, "!TrollHunter#Azeroth")
+
+	for _, test := range []struct {
+		uid    int
+		name   string
+		banned bool
+	}{
+		{80, "Troll1#Azeroth", true},
+		{81, "troll2#Lordaeron", true},
+		{82, "SPAM42#Azeroth", true},
+		{83, "TrollHunter#Azeroth", false},
+		{84, "TrollHunter#Lordaeron", true},
+		{85, "Peon#Azeroth", false},
+	} {
+		sent := join(bot, test.uid, test.name)
+		if banned := strings.Contains(sent, _REQUEST_BAN); banned != test.banned {
+			t.Errorf("%s: Expected: banned %v, Actual: %s", test.name, test.banned, sent)
+		}
+	}
+}
+
+func TestHandleActionAddBanPattern(t *testing.T) {
+	bot := getTestbot()
+
+	/* Users in the channel that a new pattern matches are banned */
+	client := getEchoClient()
+	if err := handleAction(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".addban testuser6?#*")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if len(client.requests) != 1 || client.request.Command != _REQUEST_BAN ||
+		client.request.Payload.(_payloadAction).UserId != _TEST_USERID_61 {
+
+		t.Errorf("Expected: %d banned, Actual: %+v", _TEST_USERID_61, client.requests)
+	}
+
+	/* An exemption lets them back in */
+	_ = handleAction(getEchoClient(), bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN,
+		".addban !"+_TEST_USERNAME_TESTUSER61_GATEWAY))
+	if sent := join(bot, _TEST_USERID_61, _TEST_USERNAME_TESTUSER61_GATEWAY); len(sent) != 0 {
+		t.Errorf("Expected: exempt, Actual: %s", sent)
+	}
+
+	/* Removing the exemption bans them again */
+	client = getEchoClient()
+	_ = handleAction(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN,
+		".rmban !"+_TEST_USERNAME_TESTUSER61_GATEWAY))
+	if client.request.Command != _REQUEST_BAN {
+		t.Errorf("Expected: %d banned, Actual: %+v", _TEST_USERID_61, client.requests)
+	}
+
+	/* Removing a pattern unbans no one */
+	client = getEchoClient()
+	_ = handleAction(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".rmban testuser6?#*"))
+	if len(bot.blist) != 1 || len(client.requests) != 0 {
+		t.Errorf("Expected: only the banned user left, nothing sent, Actual: %v, %+v", bot.blist, client.requests)
+	}
+
+	/* Invalid patterns are refused */
+	client = getEchoClient()
+	_ = handleAction(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".addban /troll(/"))
+	if len(bot.blist) != 1 || !strings.Contains(client.request.Payload.(_payloadMessage).Message, "not a valid") {
+		t.Errorf("Expected: pattern refused, Actual: %v, %+v", bot.blist, client.request)
+	}
+}
+
+func TestHandleActionBanTest(t *testing.T) {
+	bot := getTestbot()
+	bot.addToBanlist("troll*#*", "!TrollHunter#Azeroth")
+
+	for target, expected := range map[string]string{
+		"Troll1#Azeroth":      "Troll1#Azeroth would be banned by TROLL*#*",
+		"TrollHunter#Azeroth": "TrollHunter#Azeroth is exempt by !TROLLHUNTER#AZEROTH",
+		"Peon#Azeroth":        "Peon#Azeroth is not banned",
+	} {
+		client := getEchoClient()
+		if err := handleAction(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".bantest "+target)); err != nil {
+			t.Fatalf("Expected nil, but got an error: %v", err)
+		}
+
+		/* A dry run, so nothing but the answer is sent */
+		if len(client.requests) != 1 || client.request.Payload.(_payloadMessage).Message != expected {
+			t.Errorf("Expected: %s, Actual: %+v", expected, client.requests)
+		}
+	}
+}
+
+func TestBanRulesFollowBanlist(t *testing.T) {
+	bot := getTestbot()
+	feed := getTestFeed(federation.TrustFull)
+	troll := "Troll#Azeroth"
+	banEntry := federation.NewEntry(troll, federation.ACTION_BAN, "other")
+	unbanEntry := federation.NewEntry(troll, federation.ACTION_UNBAN, "other")
+
+	for _, step := range []struct {
+		name   string
+		change func()
+		banned bool
+	}{
+		{"nothing yet", func() {}, false},
+		{"pattern added", func() { bot.addToBanlist("troll*#*") }, true},
+		{"exemption added", func() { bot.addToBanlist("!" + troll) }, false},
+		{"exemption removed", func() { bot.rmFromBanlist("!" + troll) }, true},
+		{"pattern removed", func() { bot.rmFromBanlist("troll*#*") }, false},
+		{"banned by feed", func() { applyTestEntries(getEchoClient(), bot, feed, banEntry) }, true},
+		{"unbanned by feed", func() { applyTestEntries(getEchoClient(), bot, feed, banEntry, unbanEntry) }, false},
+		{"banned in config", func() {
+			bot.Reload(getEchoClient(), []string{_TEST_USERNAME_BANNED_BANNEDUSER159, troll}, []string{_TEST_USERNAME_PRIVUSER155})
+		}, true},
+		{"unbanned in config", func() {
+			bot.Reload(getEchoClient(), []string{_TEST_USERNAME_BANNED_BANNEDUSER159}, []string{_TEST_USERNAME_PRIVUSER155})
+		}, false},
+	} {
+		step.change()
+		if banned := bot.banned(troll); banned != step.banned {
+			t.Errorf("%s: Expected: banned %v, Actual: %v", step.name, step.banned, banned)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package peonbot
+
+import (
+	"fmt"
+	"peonbot/irc"
+	"strings"
+	"time"
+)
+
+/*
+	Bridge between the bot's channel and an IRC channel. Channel messages,
+	joins and leaves are relayed to IRC, and what is said on IRC is said in
+	the channel with the IRC nick in front.
+
+	To keep messages from bouncing back and forth, the bridge never relays
+	its own nick, nicks on its ignore list, or text it relayed the other
+	way moments ago.
+*/
+
+const _MSG_EMOTE = "EMOTE"
+
+/* Messages from IRC may burst up to this many before the rate limit applies */
+const _BRIDGE_BURST = 5
+
+/* Relayed text seen again within this window is an echo */
+const _BRIDGE_ECHO_WINDOW = 30 * time.Second
+
+type _limiter struct {
+	interval time.Duration /* time to earn one token */
+	burst    int
+	tokens   float64
+	last     time.Time
+}
+
+func newLimiter(interval time.Duration, burst int) *_limiter {
+	return &_limiter{interval: interval, burst: burst, tokens: float64(burst)}
+}
+
+func (l *_limiter) allow(now time.Time) bool {
+	if !l.last.IsZero() {
+		l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
+
+type _bridge struct {
+	client  *irc.Client
+	nicks   map[string]string      /* battle.net name (upper case) to IRC nick */
+	names   map[string]string      /* IRC nick (lower case) to battle.net name */
+	ignore  map[string]interface{} /* IRC nicks (lower case) */
+	limiter *_limiter
+
+	relayed map[string]time.Time /* text relayed either way, to spot echoes */
+}
+
+/*
+	Connect to IRC and relay between the bot's channel and `config.Channel`.
+	Stays connected, and reconnects, until `StopBridge` is called.
+*/
+func (bot *_bot) AddBridge(config irc.Config, nicks map[string]string, ignore []string, rate time.Duration) {
+	bridge := &_bridge{
+		client:  irc.New(config),
+		nicks:   make(map[string]string),
+		names:   make(map[string]string),
+		ignore:  make(map[string]interface{}),
+		limiter: newLimiter(rate, _BRIDGE_BURST),
+		relayed: make(map[string]time.Time),
+	}
+	bridge.client.Printf = bot.Printf
+
+	for name, nick := range nicks {
+		bridge.nicks[strings.ToUpper(name)] = nick
+		bridge.names[strings.ToLower(nick)] = name
+	}
+	for _, nick := range ignore {
+		bridge.ignore[strings.ToLower(nick)] = nil
+	}
+
+	bot.bridge = bridge
+	bot.Printf("Bridging to IRC channel %s on %s\n", config.Channel, config.Server)
+
+	go bridge.client.Run(bot.chirc)
+}
+
+func (bot *_bot) StopBridge() {
+	if bot.bridge != nil {
+		bot.bridge.client.Stop()
+	}
+}
+
+/* Remember relayed text, and forget text older than the echo window */
+func (b *_bridge) remember(text string, now time.Time) {
+	for seen, at := range b.relayed {
+		if now.Sub(at) > _BRIDGE_ECHO_WINDOW {
+			delete(b.relayed, seen)
+		}
+	}
+
+	b.relayed[text] = now
+}
+
+func (b *_bridge) isEcho(text string, now time.Time) bool {
+	at, ok := b.relayed[text]
+	return ok && now.Sub(at) <= _BRIDGE_ECHO_WINDOW
+}
+
+func (b *_bridge) ircNick(user string) string {
+	if nick, ok := b.nicks[strings.ToUpper(user)]; ok {
+		return nick
+	}
+
+	return user
+}
+
+func (b *_bridge) bnetName(nick string) string {
+	if name, ok := b.names[strings.ToLower(nick)]; ok {
+		return name
+	}
+
+	return nick
+}
+
+/* Relay a line to IRC, unless it is an echo. Only call from the event loop. */
+func (bot *_bot) relayToIrc(text string, echoOf string) {
+	if bot.bridge == nil {
+		return
+	}
+
+	now := time.Now()
+	if bot.bridge.isEcho(echoOf, now) {
+		bot.Vprintf("Not relaying echo to IRC: %s\n", text)
+		return
+	}
+
+	if err := bot.bridge.client.Say(text); err != nil {
+		bot.Printf("Could not relay to IRC: %v\n", err)
+		return
+	}
+	bot.bridge.remember(text, now)
+}
+
+/* Relay what was said in the channel to IRC. Only call from the event loop. */
+func (bot *_bot) bridgeMessage(event _event) {
+	if bot.bridge == nil {
+		return
+	}
+
+	nick := bot.bridge.ircNick(bot.userTable[event.Payload.UserId])
+	message := event.Payload.Message
+
+	switch strings.ToUpper(event.Payload.Type) {
+	case _MSG_CHAN:
+		bot.relayToIrc(fmt.Sprintf("<%s> %s", nick, message), message)
+	case _MSG_EMOTE:
+		bot.relayToIrc(fmt.Sprintf("* %s %s", nick, message), message)
+	}
+}
+
+func (bot *_bot) bridgeJoin(user string) {
+	if bot.bridge == nil || bot.joinGrace(time.Now()) {
+		return
+	}
+
+	bot.relayToIrc(fmt.Sprintf("* %s has joined the channel", bot.bridge.ircNick(user)), "")
+}
+
+func (bot *_bot) bridgeLeave(user string) {
+	if bot.bridge == nil {
+		return
+	}
+
+	bot.relayToIrc(fmt.Sprintf("* %s has left the channel", bot.bridge.ircNick(user)), "")
+}
+
+/* Say what was said on IRC in the channel. Only call from the event loop. */
+func (bot *_bot) relayFromIrc(client WebsocketClient, message irc.Message, now time.Time) {
+	bridge := bot.bridge
+	if bridge == nil {
+		return
+	}
+
+	if strings.EqualFold(message.Nick, bridge.client.Nick()) {
+		return
+	}
+
+	if _, ok := bridge.ignore[strings.ToLower(message.Nick)]; ok {
+		return
+	}
+
+	if bridge.isEcho(message.Text, now) {
+		bot.Vprintf("Not relaying echo from IRC: %s\n", message.Text)
+		return
+	}
+
+	if !bridge.limiter.allow(now) {
+		bot.Printf("Not relaying from IRC, rate limited: <%s> %s\n",
+			message.Nick, message.Text)
+		return
+	}
+
+	name := bridge.bnetName(message.Nick)
+	text := fmt.Sprintf("<%s> %s", name, message.Text)
+	if message.Action {
+		text = fmt.Sprintf("* %s %s", name, message.Text)
+	}
+
+	if err := handleActionSay(client, bot, text); err != nil {
+		bot.Printf("Could not relay from IRC: %v\n", err)
+		return
+	}
+	bridge.remember(text, now)
+}
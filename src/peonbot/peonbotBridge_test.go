@@ -0,0 +1,137 @@
+package peonbot
+
+import (
+	"peonbot/irc"
+	"peonbot/irc/irctest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := newLimiter(time.Second, 2)
+	now := time.Now()
+
+	if !limiter.allow(now) || !limiter.allow(now) {
+		t.Fatalf("Burst should be allowed")
+	}
+
+	if limiter.allow(now.Add(500 * time.Millisecond)) {
+		t.Errorf("Should be rate limited after the burst")
+	}
+
+	if !limiter.allow(now.Add(1100 * time.Millisecond)) {
+		t.Errorf("A token should have been earned back")
+	}
+}
+
+func getBridgedTestbot(t *testing.T, server *irctest.Server) *_bot {
+	testbot := getTestbot()
+	testbot.chirc = make(chan irc.Message)
+	testbot.AddBridge(irc.Config{Server: server.Addr, Nick: "peonbot", Channel: "#peon"},
+		map[string]string{_TEST_USERNAME_TESTUSER61_GATEWAY: "sixtyone"},
+		[]string{"otherbridge"}, time.Second)
+	t.Cleanup(testbot.StopBridge)
+
+	select {
+	case <-server.Joined():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Bridge never joined the IRC channel")
+	}
+
+	return testbot
+}
+
+func expectIrc(t *testing.T, server *irctest.Server, expected string) {
+	select {
+	case actual := <-server.Received():
+		if actual != expected {
+			t.Fatalf("Expected: %s, Actual: %s", expected, actual)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for: %s", expected)
+	}
+}
+
+func TestBridgeToIrc(t *testing.T) {
+	server := irctest.NewServer()
+	defer server.Close()
+	testbot := getBridgedTestbot(t, server)
+
+	testbot.bridgeMessage(getUserMessage(_TEST_USERID_61, _MSG_CHAN, "hello irc"))
+	expectIrc(t, server, "#peon <sixtyone> hello irc")
+
+	/* Whispers stay private */
+	testbot.bridgeMessage(getUserMessage(_TEST_USERID_59, _MSG_WHISPER, "secret"))
+	testbot.bridgeLeave(_TEST_USERNAME_TESTUSER59)
+	expectIrc(t, server, "#peon * TestUser59 has left the channel")
+}
+
+func TestBridgeFromIrc(t *testing.T) {
+	server := irctest.NewServer()
+	defer server.Close()
+	testbot := getBridgedTestbot(t, server)
+
+	server.Say("sixtyone", "#peon", "hello battle.net")
+	server.Say("otherbridge", "#peon", "<someone> relayed twice")
+	server.Say("grunt", "#peon", "\x01ACTION waves\x01")
+
+	client := getEchoClient()
+	for i := 0; i < 3; i++ {
+		select {
+		case message := <-testbot.chirc:
+			testbot.relayFromIrc(client, message, time.Now())
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for IRC messages")
+		}
+	}
+
+	var said []string
+	for _, request := range client.requests {
+		said = append(said, request.Payload.(_payloadMessage).Message)
+	}
+
+	expected := "<" + _TEST_USERNAME_TESTUSER61_GATEWAY + "> hello battle.net|* grunt waves"
+	if strings.Join(said, "|") != expected {
+		t.Errorf("Expected: %s, Actual: %s", expected, strings.Join(said, "|"))
+	}
+}
+
+func TestBridgeLoopPrevention(t *testing.T) {
+	server := irctest.NewServer()
+	defer server.Close()
+	testbot := getBridgedTestbot(t, server)
+
+	client := getEchoClient()
+	now := time.Now()
+	testbot.relayFromIrc(client, irc.Message{Nick: "grunt", Text: "hi"}, now)
+
+	/* Battle.net echoes the relayed message back */
+	testbot.bridgeMessage(getUserMessage(_TEST_USERID_59, _MSG_CHAN, "<grunt> hi"))
+
+	/* Our own nick, and text we just relayed to IRC */
+	testbot.relayFromIrc(client, irc.Message{Nick: "PeonBot", Text: "hi"}, now)
+	testbot.bridgeMessage(getUserMessage(_TEST_USERID_59, _MSG_CHAN, "ping"))
+	expectIrc(t, server, "#peon <TestUser59> ping")
+	testbot.relayFromIrc(client, irc.Message{Nick: "grunt", Text: "<TestUser59> ping"}, now)
+
+	if len(client.requests) != 1 {
+		t.Errorf("Only the first message should be relayed: %+v", client.requests)
+	}
+}
+
+func TestBridgeRateLimit(t *testing.T) {
+	server := irctest.NewServer()
+	defer server.Close()
+	testbot := getBridgedTestbot(t, server)
+
+	client := getEchoClient()
+	now := time.Now()
+	for i := 0; i < _BRIDGE_BURST+3; i++ {
+		testbot.relayFromIrc(client, irc.Message{Nick: "grunt", Text: strings.Repeat("a", i+1)}, now)
+	}
+
+	if len(client.requests) != _BRIDGE_BURST {
+		t.Errorf("Expected: %d, Actual: %d", _BRIDGE_BURST, len(client.requests))
+	}
+}
@@ -15,11 +15,22 @@ func (bot *_bot) ListenStdin() {
 			continue
 		}
 
-		bot.chsin <- msg
+		select {
+		case bot.chsin <- msg:
+		case <-bot.ctx.Done():
+			return
+		}
 	}
 }
 
 func (bot *_bot) HandleMessage(client WebsocketClient, message string) error {
+	if handled, err := bot.handleConsole(client, message); handled {
+		if err != nil {
+			bot.consolef("%v", err)
+		}
+		return err
+	}
+
 	/* Handle actions sent from stdin */
 	switch isAction(message) {
 	case true:
@@ -33,6 +44,31 @@ func (bot *_bot) HandleMessage(client WebsocketClient, message string) error {
 
 const _STDIN_ACTION_DELIMITER = "/"
 
+/* Actions that can be issued from the console, e.g. `/kick name#Azeroth` */
+var _STDIN_ACTIONS = []string{
+	_ACTION_KICK, _ACTION_BAN, _ACTION_UNBAN, _ACTION_SAY, _ACTION_WHISPER,
+	_ACTION_DESIGNATE, _ACTION_ADDPRIV, _ACTION_RMPRIV, _ACTION_ADDBAN,
+	_ACTION_RMBAN, _ACTION_RELOAD, _ACTION_REMIND, _ACTION_SCHEDULE,
+	_ACTION_ADDCMD, _ACTION_RMCMD, _ACTION_ADDTRIGGER, _ACTION_RMTRIGGER,
+	_ACTION_LISTCMDS, _ACTION_WARN, _ACTION_STRIKES, _ACTION_CLEARSTRIKES,
+	_ACTION_LOCKDOWN, _ACTION_ALLOW, _ACTION_DISALLOW, _ACTION_BANTEST,
+	_ACTION_IDLE, _ACTION_POLL,
+}
+
+/* Console commands and actions as they are typed, for completion */
+func StdinCommands() []string {
+	var commands []string
+	for _, command := range _CONSOLE_COMMANDS {
+		commands = append(commands, strings.ToLower(command))
+	}
+	for _, action := range _STDIN_ACTIONS {
+		commands = append(commands,
+			_STDIN_ACTION_DELIMITER+strings.ToLower(action[1:]))
+	}
+
+	return commands
+}
+
 func isAction(message string) bool {
 	if strings.Compare(
 		string(message[0]), _STDIN_ACTION_DELIMITER) == 0 {
@@ -0,0 +1,399 @@
+package peonbot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Custom commands and auto-responders, defined from chat at runtime and
+	saved to the data dir. A command replies when a message starts with its
+	trigger, e.g. `!rules`. An auto-responder replies when a message matches
+	its pattern (a case insensitive regular expression). Replies go back
+	where the message came from, so a whispered command is whispered back.
+
+	Responses may use template variables:
+	  {user}    - who sent the message
+	  {channel} - the channel the bot is in
+	  {count}   - how many times the command has been used
+*/
+
+const _ACTION_ADDCMD = ".ADDCMD"
+const _ACTION_RMCMD = ".RMCMD"
+const _ACTION_ADDTRIGGER = ".ADDTRIGGER"
+const _ACTION_RMTRIGGER = ".RMTRIGGER"
+const _ACTION_LISTCMDS = ".LISTCMDS"
+
+const _FILE_COMMANDS = "commands.yaml"
+
+/* Who may use a command */
+const _LEVEL_ALL = "ALL"
+const _LEVEL_PRIV = "PRIV"
+
+const _OPTION_COOLDOWN = "COOLDOWN"
+const _OPTION_LEVEL = "LEVEL"
+
+const _COMMAND_COOLDOWN = time.Duration(10) * time.Second
+const _COMMAND_MIN_COOLDOWN = time.Second
+
+/* Usage counts are saved at most this often, rather than on every use */
+const _COMMAND_SAVE_INTERVAL = time.Minute
+
+type _command struct {
+	Trigger  string `yaml:"trigger,omitempty"`
+	Pattern  string `yaml:"pattern,omitempty"`
+	Response string `yaml:"response"`
+	Cooldown string `yaml:"cooldown"`
+	Level    string `yaml:"level"`
+	Count    int    `yaml:"count"`
+
+	regexp   *regexp.Regexp
+	cooldown time.Duration
+	lastUsed time.Time
+}
+
+type _commands struct {
+	Commands []*_command `yaml:"commands"`
+	Triggers []*_command `yaml:"triggers"`
+
+	saveAt time.Time /* when changed usage counts are due to be saved, zero if none */
+}
+
+func (c *_command) String() string {
+	name := c.Trigger
+	if len(c.Pattern) > 0 {
+		name = fmt.Sprintf("'%s'", c.Pattern)
+	}
+
+	return fmt.Sprintf("%s -> \"%s\" (%s, cooldown %s, used %d times)",
+		name, c.Response, strings.ToLower(c.Level), c.Cooldown, c.Count)
+}
+
+func (c *_command) init() error {
+	if len(c.Level) == 0 {
+		c.Level = _LEVEL_ALL
+	}
+	c.Level = strings.ToUpper(c.Level)
+	if c.Level != _LEVEL_ALL && c.Level != _LEVEL_PRIV {
+		return fmt.Errorf("Unknown level '%s'. Expected all, or priv.", c.Level)
+	}
+
+	c.cooldown = _COMMAND_COOLDOWN
+	if len(c.Cooldown) > 0 {
+		cooldown, err := time.ParseDuration(c.Cooldown)
+		if err != nil {
+			return err
+		}
+		if cooldown < _COMMAND_MIN_COOLDOWN {
+			return fmt.Errorf("Cooldown must be at least %v.", _COMMAND_MIN_COOLDOWN)
+		}
+		c.cooldown = cooldown
+	}
+	c.Cooldown = c.cooldown.String()
+
+	if len(c.Pattern) > 0 {
+		re, err := regexp.Compile("(?i)" + c.Pattern)
+		if err != nil {
+			return err
+		}
+		c.regexp = re
+	}
+
+	return nil
+}
+
+func (c *_command) matches(message string) bool {
+	if c.regexp != nil {
+		return c.regexp.MatchString(message)
+	}
+
+	word := strings.SplitN(message, " ", 2)[0]
+	return strings.Compare(strings.ToUpper(word), strings.ToUpper(c.Trigger)) == 0
+}
+
+/*
+	Split chat input into words, keeping "quoted phrases" together as a
+	single word without the quotes.
+*/
+func splitArgs(message string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	quoted, inWord := false, false
+
+	for _, r := range message {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case r == ' ' && !quoted:
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("Unterminated quote: %s", message)
+	}
+
+	if inWord {
+		args = append(args, word.String())
+	}
+
+	return args, nil
+}
+
+/*
+	Parse `<name> <response...> [cooldown=30s] [level=all|priv]`. Returns
+	the name and a command holding the rest.
+*/
+func parseCommandArgs(message string) (string, *_command, error) {
+	args, err := splitArgs(message)
+	if err != nil {
+		return "", nil, err
+	}
+
+	/* Drop the action itself */
+	args = args[1:]
+
+	command := &_command{}
+	for len(args) > 0 {
+		option := strings.SplitN(args[len(args)-1], "=", 2)
+		if len(option) != 2 {
+			break
+		}
+
+		switch strings.ToUpper(option[0]) {
+		case _OPTION_COOLDOWN:
+			command.Cooldown = option[1]
+		case _OPTION_LEVEL:
+			command.Level = option[1]
+		default:
+			return "", nil, fmt.Errorf("Unknown option '%s'.", option[0])
+		}
+		args = args[:len(args)-1]
+	}
+
+	if len(args) < 2 || len(strings.TrimSpace(args[0])) == 0 {
+		return "", nil, fmt.Errorf("Expected a name and a response: %s", message)
+	}
+
+	command.Response = strings.Join(args[1:], " ")
+	if err := command.init(); err != nil {
+		return "", nil, err
+	}
+
+	return args[0], command, nil
+}
+
+func (bot *_bot) loadCommands() error {
+	var saved _commands
+
+	if err := bot.loadData(_FILE_COMMANDS, &saved); err != nil {
+		return err
+	}
+
+	for _, list := range [][]*_command{saved.Commands, saved.Triggers} {
+		for _, command := range list {
+			if err := command.init(); err != nil {
+				bot.Printf("Dropping saved command %+v: %v\n", command, err)
+				continue
+			}
+
+			if len(command.Pattern) > 0 {
+				bot.commands.Triggers = append(bot.commands.Triggers, command)
+			} else {
+				bot.commands.Commands = append(bot.commands.Commands, command)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (bot *_bot) saveCommands() {
+	bot.commands.saveAt = time.Time{}
+	if err := bot.saveData(_FILE_COMMANDS, &bot.commands); err != nil {
+		bot.Printf("Could not save commands: %v\n", err)
+	}
+}
+
+/* Removes the first command matching `match`, returning whether one did */
+func rmCommand(list []*_command, match func(*_command) bool) ([]*_command, bool) {
+	for i, command := range list {
+		if match(command) {
+			return append(list[:i], list[i+1:]...), true
+		}
+	}
+
+	return list, false
+}
+
+/* `.addcmd !rules "No smurfing, be nice" cooldown=30s level=all` */
+func handleActionAddCmd(client WebsocketClient, bot *_bot, event _event) error {
+	trigger, command, err := parseCommandArgs(event.Payload.Message)
+	if err != nil {
+		reply(client, bot, event, err.Error())
+		return err
+	}
+
+	if strings.HasPrefix(trigger, ".") || strings.HasPrefix(trigger, _STDIN_ACTION_DELIMITER) {
+		err := fmt.Errorf("Command '%s' must not start with '.' or '/'.", trigger)
+		reply(client, bot, event, err.Error())
+		return err
+	}
+	command.Trigger = trigger
+
+	/* Replace a command with the same trigger */
+	bot.commands.Commands, _ = rmCommand(bot.commands.Commands, func(c *_command) bool {
+		return strings.Compare(strings.ToUpper(c.Trigger), strings.ToUpper(trigger)) == 0
+	})
+	bot.commands.Commands = append(bot.commands.Commands, command)
+	bot.saveCommands()
+
+	reply(client, bot, event, fmt.Sprintf("Added command %s", command))
+	return nil
+}
+
+/* `.addtrigger "clan war" "Clan wars are on Fridays" cooldown=1m` */
+func handleActionAddTrigger(client WebsocketClient, bot *_bot, event _event) error {
+	pattern, command, err := parseCommandArgs(event.Payload.Message)
+	if err == nil {
+		command.Pattern = pattern
+		err = command.init()
+	}
+	if err != nil {
+		reply(client, bot, event, err.Error())
+		return err
+	}
+
+	bot.commands.Triggers = append(bot.commands.Triggers, command)
+	bot.saveCommands()
+
+	reply(client, bot, event, fmt.Sprintf("Added trigger %s", command))
+	return nil
+}
+
+func handleActionRmCmd(client WebsocketClient, bot *_bot, trigger string, event _event) error {
+	var ok bool
+
+	bot.commands.Commands, ok = rmCommand(bot.commands.Commands, func(c *_command) bool {
+		return strings.Compare(strings.ToUpper(c.Trigger), strings.ToUpper(trigger)) == 0
+	})
+	if !ok {
+		err := fmt.Errorf("No command '%s'.", trigger)
+		reply(client, bot, event, err.Error())
+		return err
+	}
+	bot.saveCommands()
+
+	reply(client, bot, event, fmt.Sprintf("Removed command %s", trigger))
+	return nil
+}
+
+/* `.rmtrigger "clan war"`, with the pattern exactly as it was added */
+func handleActionRmTrigger(client WebsocketClient, bot *_bot, event _event) error {
+	var ok bool
+
+	args, err := splitArgs(event.Payload.Message)
+	if err != nil {
+		reply(client, bot, event, err.Error())
+		return err
+	}
+	pattern := strings.Join(args[1:], " ")
+
+	bot.commands.Triggers, ok = rmCommand(bot.commands.Triggers, func(c *_command) bool {
+		return strings.Compare(c.Pattern, pattern) == 0
+	})
+	if !ok {
+		err := fmt.Errorf("No trigger '%s'.", pattern)
+		reply(client, bot, event, err.Error())
+		return err
+	}
+	bot.saveCommands()
+
+	reply(client, bot, event, fmt.Sprintf("Removed trigger '%s'", pattern))
+	return nil
+}
+
+func handleActionListCmds(client WebsocketClient, bot *_bot, event _event) {
+	if len(bot.commands.Commands)+len(bot.commands.Triggers) == 0 {
+		reply(client, bot, event, "No commands or triggers defined.")
+	}
+
+	for _, command := range bot.commands.Commands {
+		reply(client, bot, event, command.String())
+	}
+	for _, command := range bot.commands.Triggers {
+		reply(client, bot, event, command.String())
+	}
+}
+
+func (bot *_bot) isPriveleged(uid int) bool {
+	_, ok := bot.pusers[strings.ToUpper(bot.userTable[uid])]
+	return ok
+}
+
+/*
+	Reply to the first command or auto-responder the message matches.
+	Commands are checked before auto-responders. Messages from the console,
+	and actions, e.g. the `.addtrigger` that defines a trigger, never
+	trigger anything.
+*/
+func (bot *_bot) handleCustomCommand(client WebsocketClient, event _event) {
+	if event.Payload.UserId == _PEONBOT_USERID || len(event.Payload.Message) == 0 || bot.isAction(event) {
+		return
+	}
+
+	now := time.Now()
+	for _, list := range [][]*_command{bot.commands.Commands, bot.commands.Triggers} {
+		for _, command := range list {
+			if !command.matches(event.Payload.Message) {
+				continue
+			}
+
+			if command.Level == _LEVEL_PRIV && !bot.isPriveleged(event.Payload.UserId) {
+				continue
+			}
+
+			if now.Sub(command.lastUsed) < command.cooldown {
+				bot.Vprintf("Command on cooldown: %s\n", command)
+				return
+			}
+
+			command.lastUsed = now
+			command.Count++
+			if bot.commands.saveAt.IsZero() {
+				bot.commands.saveAt = now.Add(_COMMAND_SAVE_INTERVAL)
+			}
+
+			sendNotification(client, bot, bot.expandTemplate(command, event), event)
+			return
+		}
+	}
+}
+
+/* Save usage counts once they are due. Only call from the event loop. */
+func (bot *_bot) runCommands(now time.Time) {
+	if bot.commands.saveAt.IsZero() || now.Before(bot.commands.saveAt) {
+		return
+	}
+
+	bot.saveCommands()
+}
+
+func (bot *_bot) expandTemplate(command *_command, event _event) string {
+	return strings.NewReplacer(
+		"{user}", bot.userTable[event.Payload.UserId],
+		"{channel}", bot.channel,
+		"{count}", strconv.Itoa(command.Count),
+	).Replace(command.Response)
+}
@@ -0,0 +1,196 @@
+package peonbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func getUserMessage(uid int, mtype string, message string) _event {
+	return getAction(_EVENT_MSG, _payload{
+		UserId:  uid,
+		Message: message,
+		Type:    mtype,
+	})
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`.addcmd !rules "No smurfing, be nice"  level=all`)
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	expected := []string{".addcmd", "!rules", "No smurfing, be nice", "level=all"}
+	if strings.Join(args, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected: %v, Actual: %v", expected, args)
+	}
+
+	if _, err := splitArgs(`.addcmd !rules "No smurfing`); err == nil {
+		t.Errorf("Expected an error, but got nil.")
+	}
+}
+
+func TestCustomCommand(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	testbot.channel = "Clan Peon"
+
+	if err := handleAction(client, testbot, getUserMessage(_TEST_USERID_155, _MSG_CHAN,
+		`.addcmd !rules "Welcome to {channel}, {user}. Be nice! ({count})" cooldown=1m`)); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	client = getEchoClient()
+	testbot.handleCustomCommand(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN, "!RULES please"))
+
+	expected := "Welcome to Clan Peon, TestUser59. Be nice! (1)"
+	if client.request.Command != _REQUEST_MSG ||
+		client.request.Payload.(_payloadMessage).Message != expected {
+		t.Fatalf("Expected: %s, Actual: %+v", expected, client.request)
+	}
+
+	/* Still on cooldown */
+	client = getEchoClient()
+	testbot.handleCustomCommand(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN, "!rules"))
+	if len(client.requests) != 0 {
+		t.Errorf("Command should be on cooldown, but replied: %+v", client.requests)
+	}
+
+	testbot.commands.Commands[0].lastUsed = time.Now().Add(-time.Hour)
+	testbot.handleCustomCommand(client, getUserMessage(_TEST_USERID_59, _MSG_WHISPER, "!rules"))
+	if client.request.Command != _REQUEST_WHISPER {
+		t.Errorf("Whispered command should be whispered back: %+v", client.request)
+	}
+}
+
+func TestCustomCommandPrivLevel(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	if err := handleAction(client, testbot, getUserMessage(_TEST_USERID_155, _MSG_CHAN,
+		".addcmd !secret Officers only level=priv")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	client = getEchoClient()
+	testbot.handleCustomCommand(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN, "!secret"))
+	if len(client.requests) != 0 {
+		t.Errorf("Unpriveleged user should not be able to use the command: %+v", client.requests)
+	}
+
+	testbot.handleCustomCommand(client, getUserMessage(_TEST_USERID_155, _MSG_CHAN, "!secret"))
+	if client.request.Payload.(_payloadMessage).Message != "Officers only" {
+		t.Errorf("Priveleged user should be able to use the command: %+v", client.request)
+	}
+}
+
+func TestCustomTrigger(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	testbot.dataDir = t.TempDir()
+
+	add := getUserMessage(_TEST_USERID_155, _MSG_CHAN, `.addtrigger "clan ?war" "Clan wars are on Fridays"`)
+	if err := handleAction(client, testbot, add); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	/* The action that defines it does not trigger it */
+	client = getEchoClient()
+	testbot.handleCustomCommand(client, add)
+	if len(client.requests) != 0 {
+		t.Errorf("Actions should not trigger commands: %+v", client.requests)
+	}
+
+	client = getEchoClient()
+	testbot.handleCustomCommand(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN,
+		"when is the next CLANWAR?"))
+	if client.request.Payload.(_payloadMessage).Message != "Clan wars are on Fridays" {
+		t.Errorf("Trigger should have replied: %+v", client.request)
+	}
+
+	/* Usage counts are saved with the next tick that is due, not on every use */
+	restarted := getTestbot()
+	if err := restarted.SetDataDir(testbot.dataDir); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if len(restarted.commands.Triggers) != 1 || restarted.commands.Triggers[0].Count != 0 {
+		t.Fatalf("Usage count should not have been saved yet: %+v", restarted.commands)
+	}
+	testbot.runCommands(time.Now())
+	testbot.runCommands(time.Now().Add(_COMMAND_SAVE_INTERVAL))
+
+	/* Triggers are persisted */
+	restarted = getTestbot()
+	if err := restarted.SetDataDir(testbot.dataDir); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if len(restarted.commands.Triggers) != 1 || restarted.commands.Triggers[0].Count != 1 {
+		t.Fatalf("Trigger should have been saved: %+v", restarted.commands)
+	}
+
+	if err := handleAction(client, restarted, getUserMessage(_TEST_USERID_155, _MSG_CHAN,
+		`.rmtrigger "clan ?war"`)); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if len(restarted.commands.Triggers) != 0 {
+		t.Errorf("Trigger should have been removed: %+v", restarted.commands.Triggers)
+	}
+}
+
+func TestCustomCommandRejects(t *testing.T) {
+	testbot := getTestbot()
+
+	for _, message := range []string{
+		".addcmd .kick nope",
+		".addcmd !rules",
+		".addcmd !rules hi level=admin",
+		".addcmd !rules hi cooldown=0s",
+		".addcmd !rules hi color=red",
+		`.addtrigger "(unclosed" hi`,
+		`.addtrigger "" hi`,
+		`.addtrigger " " hi`,
+		`.addcmd "" hi`,
+		".rmcmd !missing",
+		`.rmtrigger "missing"`,
+		`.rmtrigger "unclosed`,
+	} {
+		client := getEchoClient()
+		if err := handleAction(client, testbot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, message)); err == nil {
+			t.Errorf("Expected an error for '%s', but got nil.", message)
+		}
+
+		/* And the user is told why */
+		if len(client.requests) != 1 {
+			t.Errorf("Expected a reply for '%s', but got: %+v", message, client.requests)
+		}
+	}
+}
+
+func TestCustomCommandIgnoresStdin(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	if err := handleAction(client, testbot, getUserMessage(_TEST_USERID_155, _MSG_CHAN,
+		".addcmd !rules Be nice")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	client = getEchoClient()
+	testbot.handleCustomCommand(client, testbot.getStdinAction("!rules"))
+	if len(client.requests) != 0 {
+		t.Errorf("Console messages should not trigger commands: %+v", client.requests)
+	}
+}
+
+func TestHandleEventConnect(t *testing.T) {
+	testbot := getTestbot()
+
+	if err := testbot.HandleEvent([]byte(`{"command":"Botapichat.ConnectEventRequest",` +
+		`"request_id":1,"payload":{"channel":"Clan Peon"}}`)); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if testbot.channel != "Clan Peon" {
+		t.Errorf("Expected: %s, Actual: %s", "Clan Peon", testbot.channel)
+	}
+}
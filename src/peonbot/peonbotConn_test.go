@@ -2,10 +2,21 @@ package peonbot
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"peonbot/endpoint"
+	"peonbot/pin"
+	"peonbot/pin/pintest"
+	"peonbot/proxy"
+	"peonbot/proxy/proxytest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -85,155 +96,332 @@ func connectToEchoServer(testbot *_bot, port string, endpoint string) error {
 	return err
 }
 
-type mockDialerBeforeEcs struct{}
-
-func (d *mockDialerBeforeEcs) Dial(addr string, header http.Header) (*websocket.Conn, *http.Response, error) {
-	return nil, nil, nil
+/*
+	A websocket server over tls, with a locally generated certificate
+	standing in for battle.net's.
+*/
+func startTLSEchoServer(cert tls.Certificate) (string, func()) {
+	return startTLSServer(cert, tlsEchoHandler(&websocket.Upgrader{}))
 }
 
-const _TEST_WSEP_TESTCHECKSERVERCERTBEFOREECS = "TestCheckServerCertBeforeEcs"
-const _ECHO_SERVER_PORT_5962 = "5962"
-
-func TestCheckServerCertBeforeErrConnSuceeded(t *testing.T) {
-	go startEchoServer(
-		_ECHO_SERVER_PORT_5962,
-		_TEST_WSEP_TESTCHECKSERVERCERTBEFOREECS)
-
-	dialer := mockDialerBeforeEcs{}
+func startTLSServer(cert tls.Certificate, handler http.Handler) (string, func()) {
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
 
-	addr := fmt.Sprintf("%s:%s/%s", _ECHO_SERVER_ADDR, _ECHO_SERVER_PORT_5962,
-		_TEST_WSEP_TESTCHECKSERVERCERTBEFOREECS)
+	return "wss://" + server.Listener.Addr().String(), server.Close
+}
 
-	if err := checkServerCertBefore(&dialer, addr); err == nil {
-		t.Errorf("Expected an error, but got nil.")
+func tlsEchoHandler(upgrader *websocket.Upgrader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		/* Echo until the client hangs up */
+		for {
+			mtype, raw, err := conn.ReadMessage()
+			if err != nil || conn.WriteMessage(mtype, raw) != nil {
+				return
+			}
+		}
 	}
 }
 
-type mockDialerBeforeEcf struct{}
-
-func (d *mockDialerBeforeEcf) Dial(addr string, header http.Header) (*websocket.Conn, *http.Response, error) {
-	return nil, nil, fmt.Errorf("Error: connection failed.")
+func endpointAt(addr string) endpoint.Endpoint {
+	return endpoint.Endpoint{Version: endpoint.V1, URL: addr}
 }
 
-const _TEST_WSEP_TESTCHECKSERVERCERTBEFOREECF = "TestCheckServerCertBeforeEcf"
-const _ECHO_SERVER_PORT_5963 = "5963"
+func TestDial(t *testing.T) {
+	ca, err := pintest.NewCert(nil, true, time.Now().Add(-time.Hour),
+		time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
+	leaf, err := pintest.NewLeaf(ca, "*."+_X509_EXPECTED_NAME, _X509_EXPECTED_NAME)
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
 
-func TestCheckServerCertBeforeErrConnFailed(t *testing.T) {
-	go startEchoServer(
-		_ECHO_SERVER_PORT_5963,
-		_TEST_WSEP_TESTCHECKSERVERCERTBEFOREECF)
+	addr, stop := startTLSEchoServer(leaf.TLS(ca))
+	defer stop()
 
-	dialer := mockDialerBeforeEcf{}
+	/* Not signed by a trusted root, but for the right name */
+	testbot := getTestbot()
+	if err := testbot.dial(endpointAt(addr)); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+	testbot.Conn.Close()
+
+	/* Pinned to the issuer */
+	if err := testbot.SetPins([]string{pin.SPKI(ca.Cert)}); err != nil {
+		t.Fatalf("Could not set pins: %v", err)
+	}
+	if err := testbot.dial(endpointAt(addr)); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+	testbot.Conn.Close()
 
-	addr := fmt.Sprintf("%s:%s/%s", _ECHO_SERVER_ADDR, _ECHO_SERVER_PORT_5963,
-		_TEST_WSEP_TESTCHECKSERVERCERTBEFOREECF)
+	/* Pinned to something else */
+	other, _ := pintest.NewLeaf(nil, _X509_EXPECTED_NAME)
+	_ = testbot.SetPins([]string{pin.SPKI(other.Cert)})
+	if err := testbot.dial(endpointAt(addr)); err == nil {
+		t.Errorf("Expected: error for a certificate that is not pinned, Actual: nil")
+	}
 
-	if err := checkServerCertBefore(&dialer, addr); err == nil {
-		t.Errorf("Expected an error, but got nil.")
+	if err := testbot.SetPins([]string{"md5/abc"}); err == nil {
+		t.Errorf("Expected: error for an invalid pin, Actual: nil")
 	}
 }
 
-type mockDialerBeforeUA struct{}
+func TestDialWrongName(t *testing.T) {
+	leaf, err := pintest.NewLeaf(nil, "example.com")
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
 
-func (d *mockDialerBeforeUA) Dial(addr string, header http.Header) (*websocket.Conn, *http.Response, error) {
-	return nil, nil, fmt.Errorf(_X509_UNKNOWN_AUTH)
+	addr, stop := startTLSEchoServer(leaf.TLS())
+	defer stop()
+
+	testbot := getTestbot()
+	if err := testbot.dial(endpointAt(addr)); err == nil {
+		t.Errorf("Expected: error for a certificate for example.com, Actual: nil")
+	}
 }
 
-const _TEST_WSEP_TESTCHECKSERVERCERTBEFOREUA = "TestCheckServerCertBeforeUa"
-const _ECHO_SERVER_PORT_5964 = "5964"
+/* Through stand-ins for proxies, which forward to the tls echo server */
+func TestDialProxy(t *testing.T) {
+	ca, err := pintest.NewCert(nil, true, time.Now().Add(-time.Hour),
+		time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
+	leaf, err := pintest.NewLeaf(ca, _X509_EXPECTED_NAME)
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
 
-func TestCheckServerCertBeforeUa(t *testing.T) {
-	go startEchoServer(
-		_ECHO_SERVER_PORT_5964,
-		_TEST_WSEP_TESTCHECKSERVERCERTBEFOREUA)
+	server, stop := startTLSEchoServer(leaf.TLS(ca))
+	defer stop()
+	upstream := strings.TrimPrefix(server, "wss://")
 
-	dialer := mockDialerBeforeUA{}
+	/* Loopback addresses are never proxied, so ask for battle.net's name */
+	const addr = "wss://" + _X509_EXPECTED_NAME + ":443/v1/rpc/chat"
 
-	addr := fmt.Sprintf("%s:%s/%s", _ECHO_SERVER_ADDR, _ECHO_SERVER_PORT_5964,
-		_TEST_WSEP_TESTCHECKSERVERCERTBEFOREUA)
+	for _, newProxy := range []func(string, string, string) (*proxytest.Proxy, error){
+		proxytest.NewHTTP, proxytest.NewSOCKS5,
+	} {
+		p, err := newProxy(upstream, "peon", "zugzug")
+		if err != nil {
+			t.Fatalf("Could not start proxy: %v", err)
+		}
+		defer p.Close()
 
-	if err := checkServerCertBefore(&dialer, addr); err != nil {
-		t.Errorf("Checking server cert before should not have returned as error, but it did: %v\n", err)
-	}
-}
+		testbot := getTestbot()
+		_ = testbot.SetPins([]string{pin.SPKI(ca.Cert)})
+		if err := testbot.SetProxy(p.URL()); err != nil {
+			t.Fatalf("Could not set proxy: %v", err)
+		}
+		if err := testbot.dial(endpointAt(addr)); err != nil {
+			t.Fatalf("%s: Expected: nil, Actual: %v", p.URL(), err)
+		}
+		testbot.Conn.Close()
+
+		if requested := p.Requested(); len(requested) != 1 || requested[0] != _X509_EXPECTED_NAME+":443" {
+			t.Errorf("Expected: %s:443, Actual: %v", _X509_EXPECTED_NAME, requested)
+		}
 
-type mockDialerAfterEcs struct{}
+		/* Still verified through the proxy */
+		other, _ := pintest.NewLeaf(nil, _X509_EXPECTED_NAME)
+		_ = testbot.SetPins([]string{pin.SPKI(other.Cert)})
+		if err := testbot.dial(endpointAt(addr)); err == nil {
+			t.Errorf("Expected: error for a certificate that is not pinned, Actual: nil")
+		}
 
-func (d *mockDialerAfterEcs) Dial(network string, addr string, tlsConfig *tls.Config) (*tls.Conn, error) {
-	return nil, nil
+		/* Wrong credentials, without the password in the error */
+		_ = testbot.SetPins(nil)
+		_ = testbot.SetProxy(strings.Replace(p.URL(), "zugzug", "wrong", 1))
+		if err := testbot.dial(endpointAt(addr)); err == nil || strings.Contains(err.Error(), "wrong") {
+			t.Errorf("Expected: error without the password, Actual: %v", err)
+		}
+	}
+
+	if err := getTestbot().SetProxy("https://127.0.0.1:3128"); err == nil {
+		t.Errorf("Expected: error for an https proxy, Actual: nil")
+	}
 }
 
-const _TEST_WSEP_TESTCHECKSERVERCERTAFTERECS = "TestServerCertAfterEcs"
-const _ECHO_SERVER_PORT_5965 = "5965"
+func TestDialProxyEnvironment(t *testing.T) {
+	leaf, err := pintest.NewLeaf(nil, _X509_EXPECTED_NAME)
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
 
-func TestCheckServerCertAfterErrConnSucceeded(t *testing.T) {
-	go startEchoServer(
-		_ECHO_SERVER_PORT_5965,
-		_TEST_WSEP_TESTCHECKSERVERCERTAFTERECS)
+	server, stop := startTLSEchoServer(leaf.TLS())
+	defer stop()
 
-	addr := fmt.Sprintf("%s:%s/%s", _ECHO_SERVER_ADDR, _ECHO_SERVER_PORT_5965,
-		_TEST_WSEP_TESTCHECKSERVERCERTAFTERECS)
+	p, err := proxytest.NewHTTP(strings.TrimPrefix(server, "wss://"), "", "")
+	if err != nil {
+		t.Fatalf("Could not start proxy: %v", err)
+	}
+	defer p.Close()
+
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		value, ok := os.LookupEnv(name)
+		os.Unsetenv(name)
+		if ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+	os.Setenv("HTTPS_PROXY", p.URL())
 
-	dialer := mockDialerAfterEcs{}
+	const addr = "wss://" + _X509_EXPECTED_NAME + ":443/v1/rpc/chat"
 
-	if err := checkServerCertAfter(&dialer, addr, uuid.New().String()); err == nil {
-		t.Errorf("Expected an error, but got nil.")
+	testbot := getTestbot()
+	if err := testbot.dial(endpointAt(addr)); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
 	}
-}
+	testbot.Conn.Close()
 
-type mockDialerAfterEcf struct {
-	fakeServerName string
-}
+	if requested := p.Requested(); len(requested) != 1 {
+		t.Errorf("Expected: 1 connection through the proxy, Actual: %v", requested)
+	}
 
-func (d *mockDialerAfterEcf) Dial(network string, addr string,
-	tlsConfig *tls.Config) (*tls.Conn, error) {
+	/* Dialed directly, so not connected, as the name does not resolve */
+	const unresolved = "wss://peonbot.invalid:443/v1/rpc/chat"
+	os.Setenv("NO_PROXY", "peonbot.invalid")
+	if err := testbot.dial(endpointAt(unresolved)); err == nil {
+		t.Errorf("Expected: error connecting directly, Actual: nil")
+	}
+	os.Unsetenv("NO_PROXY")
+	_ = testbot.SetProxy(proxy.DIRECT)
+	if err := testbot.dial(endpointAt(unresolved)); err == nil {
+		t.Errorf("Expected: error connecting directly, Actual: nil")
+	}
 
-	return nil, fmt.Errorf("Error: connection failed")
+	if requested := p.Requested(); len(requested) != 1 {
+		t.Errorf("Expected: no more connections through the proxy, Actual: %v", requested)
+	}
 }
 
-const _TEST_WSEP_TESTCHECKSERVERCERTAFTERECF = "TestServerCertAfterEcf"
-const _ECHO_SERVER_PORT_5966 = "5966"
+/*
+	A server that only upgrades on v1's path, and only with the json
+	subprotocol and an api version header
+*/
+func TestConnectEndpoints(t *testing.T) {
+	leaf, err := pintest.NewLeaf(nil, _X509_EXPECTED_NAME)
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
 
-func TestCheckServerCertAfterErrConnFailed(t *testing.T) {
-	go startEchoServer(
-		_ECHO_SERVER_PORT_5966,
-		_TEST_WSEP_TESTCHECKSERVERCERTAFTERECF)
+	echo := tlsEchoHandler(&websocket.Upgrader{Subprotocols: []string{"json"}})
+	addr, stop := startTLSServer(leaf.TLS(), http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path != "/v1/rpc/chat":
+				http.NotFound(w, r)
+			case r.Header.Get("Sec-WebSocket-Protocol") != "json" ||
+				r.Header.Get("X-Api-Version") != "1":
+				http.Error(w, "missing api version", http.StatusBadRequest)
+			default:
+				echo(w, r)
+			}
+		}))
+	defer stop()
+
+	var endpoints []endpoint.Endpoint
+	for _, version := range []string{endpoint.V3, endpoint.V2, endpoint.V1} {
+		endpoints = append(endpoints, endpoint.Endpoint{
+			Version: version,
+			URL:     fmt.Sprintf("%s/%s/rpc/chat", addr, version),
+		})
+	}
 
-	addr := fmt.Sprintf("%s:%s/%s", _ECHO_SERVER_ADDR, _ECHO_SERVER_PORT_5966,
-		_TEST_WSEP_TESTCHECKSERVERCERTAFTERECF)
+	/* Without the handshake the server requires */
+	testbot := getTestbot()
+	testbot.endpoints = endpoints
+	err = testbot.connect()
+	if err == nil || !strings.Contains(err.Error(), "400 Bad Request: missing api version") {
+		t.Errorf("Expected: bad handshake with the reason, Actual: %v", err)
+	}
 
-	dialer := mockDialerAfterEcf{fakeServerName: uuid.New().String()}
+	for i := range endpoints {
+		endpoints[i].Subprotocols = []string{"json"}
+		endpoints[i].Header = http.Header{"X-Api-Version": []string{"1"}}
+	}
+	if err := testbot.connect(); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+	defer testbot.Conn.Close()
+
+	if testbot.version != endpoint.V1 {
+		t.Errorf("Expected: %s, Actual: %s", endpoint.V1, testbot.version)
+	}
+	if protocol := testbot.Conn.Subprotocol(); protocol != "json" {
+		t.Errorf("Expected: json, Actual: %s", protocol)
+	}
 
-	if err := checkServerCertAfter(&dialer, addr, dialer.fakeServerName); err == nil {
-		t.Errorf("Expected an error, but got nil.")
+	/* Not worth trying another version for */
+	testbot.endpoints = []endpoint.Endpoint{
+		{Version: endpoint.V2, URL: "wss://peonbot.invalid/v2/rpc/chat"},
+		endpoints[2],
+	}
+	if err := testbot.connect(); err == nil {
+		t.Errorf("Expected: error connecting to an unresolved name, Actual: nil")
 	}
 }
 
-type mockDialerAfterSN struct {
-	fakeServerName string
+/* A version whose requests and events are shaped differently */
+type _testCodec struct{}
+
+func (_testCodec) encode(request _request) interface{} {
+	return map[string]interface{}{"method": request.Command, "id": request.RequestId}
 }
 
-func (d *mockDialerAfterSN) Dial(network string, addr string,
-	tlsConfig *tls.Config) (*tls.Conn, error) {
+func (_testCodec) decode(raw []byte) (_event, error) {
+	var event _event
+	if err := json.Unmarshal(raw, &event.Payload); err != nil {
+		return event, err
+	}
+	event.Command = _EVENT_CONNECT
 
-	return nil, fmt.Errorf(fmt.Sprintf("%s %s", _X509_SERVER_NAME, d.fakeServerName))
+	return event, nil
 }
 
-const _TEST_WSEP_TESTCHECKSERVERCERTAFTERSN = "TestServerCertAfterSN"
-const _ECHO_SERVER_PORT_5967 = "5967"
+func TestCodec(t *testing.T) {
+	_CODECS["test"] = _testCodec{}
+	defer delete(_CODECS, "test")
 
-func TestCheckServerCertAfterServerName(t *testing.T) {
-	go startEchoServer(
-		_ECHO_SERVER_PORT_5967,
-		_TEST_WSEP_TESTCHECKSERVERCERTAFTERSN)
+	testbot := getTestbot()
+	testbot.version = "test"
+	testbot.replay = &_replayClient{}
 
-	addr := fmt.Sprintf("%s:%s/%s", _ECHO_SERVER_ADDR, _ECHO_SERVER_PORT_5967,
-		_TEST_WSEP_TESTCHECKSERVERCERTAFTERSN)
+	if err := testbot.connectBot(testbot.client()); err != nil {
+		t.Fatalf("Could not send request: %v", err)
+	}
+	expected := fmt.Sprintf(`{"id":%d,"method":"%s"}`, testbot.rid, _REQUEST_CONN)
+	if len(testbot.replay.sent) != 1 || string(testbot.replay.sent[0]) != expected {
+		t.Errorf("Expected: %s, Actual: %s", expected, testbot.replay.sent)
+	}
 
-	dialer := mockDialerAfterSN{fakeServerName: uuid.New().String()}
+	if err := testbot.HandleEvent([]byte(`{"channel": "Clan Test"}`)); err != nil {
+		t.Fatalf("Could not handle event: %v", err)
+	}
+	if testbot.channel != "Clan Test" {
+		t.Errorf("Expected: Clan Test, Actual: %s", testbot.channel)
+	}
 
-	if err := checkServerCertAfter(&dialer, addr, dialer.fakeServerName); err != nil {
-		t.Errorf("Checking server cert after should not have returned as error, but it did: %v\n", err)
+	/* Unknown versions, e.g. before connecting, are v1 */
+	testbot.version = ""
+	testbot.replay.sent = nil
+	_ = testbot.connectBot(testbot.client())
+	if len(testbot.replay.sent) != 1 || !strings.Contains(string(testbot.replay.sent[0]), `"command"`) {
+		t.Errorf("Expected: a v1 request, Actual: %s", testbot.replay.sent)
 	}
 }
 
@@ -0,0 +1,324 @@
+package peonbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"peonbot/verbose"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	Commands only the operator can run, from stdin or the terminal UI. They
+	are handled before a line is turned into a chat action, so nothing said
+	or whispered on Battle.net can reach them.
+*/
+const _CONSOLE_USERS = "/USERS"
+const _CONSOLE_STATE = "/STATE"
+const _CONSOLE_BANLIST = "/BANLIST"
+const _CONSOLE_PRIVLIST = "/PRIVLIST"
+const _CONSOLE_RECONNECT = "/RECONNECT"
+const _CONSOLE_RAW = "/RAW"
+const _CONSOLE_DEBUG = "/DEBUG"
+
+var _CONSOLE_COMMANDS = []string{
+	_CONSOLE_USERS, _CONSOLE_STATE, _CONSOLE_BANLIST, _CONSOLE_PRIVLIST,
+	_CONSOLE_RECONNECT, _CONSOLE_RAW, _CONSOLE_DEBUG,
+}
+
+func (bot *_bot) consolef(msg string, vargs ...interface{}) {
+	bot.Printf("[Bot log message] "+msg+"\n", vargs...)
+}
+
+/* Returns false if the line is not a console command */
+func (bot *_bot) handleConsole(client WebsocketClient, message string) (bool, error) {
+	parts := strings.SplitN(strings.TrimSpace(message), " ", 2)
+	args := ""
+	if len(parts) > 1 {
+		args = strings.TrimSpace(parts[1])
+	}
+
+	switch strings.ToUpper(parts[0]) {
+	case _CONSOLE_USERS:
+		bot.consoleUsers()
+	case _CONSOLE_STATE:
+		bot.consoleState()
+	case _CONSOLE_BANLIST:
+		bot.consoleBanlist()
+	case _CONSOLE_PRIVLIST:
+		bot.consolePrivlist()
+	case _CONSOLE_RECONNECT:
+		return true, bot.reconnect()
+	case _CONSOLE_RAW:
+		return true, bot.consoleRaw(client, args)
+	case _CONSOLE_DEBUG:
+		return true, bot.consoleDebug(args)
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (bot *_bot) consoleUsers() {
+	var uids []int
+	for uid := range bot.userTable {
+		if uid != _PEONBOT_USERID {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return strings.ToUpper(bot.userTable[uids[i]]) <
+			strings.ToUpper(bot.userTable[uids[j]])
+	})
+
+	bot.consolef("%d users in %s:", len(uids), bot.channel)
+	for _, uid := range uids {
+		user := bot.userTable[uid]
+
+		var notes []string
+		notes = append(notes, bot.userFlags[uid]...)
+		if _, ok := bot.pusers[strings.ToUpper(user)]; ok {
+			notes = append(notes, "priveleged")
+		}
+
+		line := fmt.Sprintf("  %s (id %d)", user, uid)
+		if len(notes) > 0 {
+			line += " " + strings.Join(notes, ", ")
+		}
+		bot.consolef("%s", line)
+	}
+}
+
+func (bot *_bot) consoleState() {
+	snapshot := bot.snapshot()
+
+	if len(bot.name) > 0 {
+		bot.consolef("Bot: %s", bot.name)
+	}
+	bot.consolef("State: %s", snapshot.State)
+	bot.consolef("Channel: %s", bot.channel)
+	bot.consolef("Users: %d, banned: %d, priveleged: %d",
+		len(snapshot.Members), len(bot.blist), len(bot.pusers)-1)
+	bot.consolef("Last request id: %d", bot.rid)
+	bot.consolef("Schedules: %d, commands: %d, triggers: %d",
+		len(bot.schedules), len(bot.commands.Commands), len(bot.commands.Triggers))
+
+	if len(bot.feeds) > 0 {
+		bot.consolef("Ban feeds: %d, publishing as '%s'", len(bot.feeds), bot.fedName)
+	}
+	for _, plugin := range bot.plugins {
+		bot.consolef("Plugin: %s", plugin.host.Name())
+	}
+	if bot.scripts != nil {
+		bot.consolef("Scripts: %s", strings.Join(bot.scripts.Scripts(), ", "))
+	}
+	if bot.bridge != nil {
+		bot.consolef("IRC bridge: %s as %s", bot.bridge.client.Channel(),
+			bot.bridge.client.Nick())
+	}
+	if len(bot.webhooks) > 0 {
+		bot.consolef("Webhooks: %d", len(bot.webhooks))
+	}
+
+	bot.consolef("Queued: %d", snapshot.Queue)
+	bot.consolef("Debug logging: %s", onOff(verbose.Enabled()))
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+
+	return "off"
+}
+
+func sortedUsers(set map[string]interface{}) []string {
+	var users []string
+	for user := range set {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	return users
+}
+
+func (bot *_bot) consoleBanlist() {
+	users := sortedUsers(bot.blist)
+
+	bot.consolef("%d banned:", len(users))
+	for _, user := range users {
+		if from, federated := bot.blist[user].(_banSource); federated {
+			bot.consolef("  %s (from %s via %s)", user, from.source, from.feed)
+			continue
+		}
+		bot.consolef("  %s", user)
+	}
+}
+
+func (bot *_bot) consolePrivlist() {
+	var users []string
+	for _, user := range sortedUsers(bot.pusers) {
+		if strings.Compare(user, strings.ToUpper(_PEONBOT_USERNAME)) != 0 {
+			users = append(users, user)
+		}
+	}
+
+	bot.consolef("%d priveleged:", len(users))
+	for _, user := range users {
+		bot.consolef("  %s", user)
+	}
+}
+
+/*
+	Send a request as is, e.g.
+	`/raw {"command": "Botapichat.SendEmoteRequest", "payload": {"message": "waves"}}`.
+	A request id is filled in if it is left out.
+*/
+func (bot *_bot) consoleRaw(client WebsocketClient, args string) error {
+	var raw struct {
+		Command   string          `json:"command"`
+		RequestId *int            `json:"request_id"`
+		Payload   json.RawMessage `json:"payload"`
+	}
+
+	if err := json.Unmarshal([]byte(args), &raw); err != nil {
+		return fmt.Errorf("Could not parse request: %v", err)
+	}
+	if len(raw.Command) == 0 {
+		return fmt.Errorf("Request must have a command.")
+	}
+
+	request := bot.createRequest(raw.Command)
+	if raw.RequestId != nil {
+		request.RequestId = *raw.RequestId
+	}
+	if len(raw.Payload) > 0 {
+		request.Payload = raw.Payload
+	}
+
+	if err := client.WriteJSON(request); err != nil {
+		return err
+	}
+
+	bot.consolef("Sent %s (request id %d)", request.Command, request.RequestId)
+	return nil
+}
+
+func (bot *_bot) consoleDebug(args string) error {
+	switch strings.ToUpper(args) {
+	case "ON":
+		verbose.SetPrinter(true)
+	case "OFF":
+		verbose.SetPrinter(false)
+	case "":
+	default:
+		return fmt.Errorf("Usage: /debug on|off")
+	}
+
+	bot.consolef("Debug logging: %s", onOff(verbose.Enabled()))
+	return nil
+}
+
+/*
+	Log in again on a new websocket connection, and drop the old one once
+	it is made. Logging in can take minutes with retries, so it happens off
+	the event loop, which keeps using the old connection until `reconnected`
+	is handed the new one over `chrcn`. Only call from the event loop.
+*/
+func (bot *_bot) reconnect() error {
+	if bot.reconnecting {
+		return fmt.Errorf("Already reconnecting.")
+	}
+	bot.reconnecting = true
+	bot.consolef("Reconnecting...")
+
+	login := bot.loginCopy()
+	go func() {
+		err := login.Start()
+		select {
+		case bot.chrcn <- _reconnect{login: login, err: err}:
+		case <-bot.ctx.Done():
+			if err == nil {
+				_ = login.Conn.Close()
+			}
+		}
+	}()
+
+	return nil
+}
+
+/* The outcome of logging in again, see `reconnect` */
+type _reconnect struct {
+	login *_bot /* holds the new connection */
+	err   error
+}
+
+/* A bot with only what logging in needs, so it can log in off the event loop */
+func (bot *_bot) loginCopy() *_bot {
+	return &_bot{
+		Printf:    bot.Printf,
+		Vprintf:   bot.Vprintf,
+		name:      bot.name,
+		token:     bot.token,
+		pins:      bot.pins,
+		proxy:     bot.proxy,
+		endpoints: bot.endpoints,
+		health:    bot.health,
+		rid:       bot.rid,
+		ctx:       bot.ctx,
+		cancel:    bot.cancel,
+	}
+}
+
+/*
+	Switch to the connection `reconnect` made, or keep the old one if it
+	could not be made. Users are forgotten, as the server sends everyone
+	in the channel again on connect. Only call from the event loop.
+*/
+func (bot *_bot) reconnected(result _reconnect) error {
+	bot.reconnecting = false
+	if result.err != nil {
+		return fmt.Errorf("Could not reconnect: %v", result.err)
+	}
+
+	if bot.Conn != nil {
+		_ = bot.Conn.Close()
+	}
+
+	login := result.login
+	bot.Conn, bot.version, bot.keepalive = login.Conn, login.version, login.keepalive
+	bot.health, bot.lastEvent, bot.early = login.health, login.lastEvent, login.early
+	if login.rid > bot.rid {
+		bot.rid = login.rid
+	}
+
+	bot.channel = ""
+	bot.userTable = make(map[int]string)
+	bot.userFlags = make(map[int][]string)
+	bot.idle.seen = make(map[int]time.Time)
+	bot.idle.kicked = make(map[int]interface{})
+	bot.addSelfToUserTable()
+
+	go bot.listen(bot.keepalive, bot.early)
+
+	return nil
+}
+
+/* Errors from a connection that has since been replaced are ignored */
+type _connError struct {
+	conn *websocket.Conn
+	err  error
+}
+
+func (e *_connError) Error() string {
+	return e.err.Error()
+}
+
+func (bot *_bot) stale(err error) bool {
+	connErr, ok := err.(*_connError)
+	return ok && connErr.conn != bot.Conn
+}
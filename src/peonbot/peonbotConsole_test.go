@@ -0,0 +1,221 @@
+package peonbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"peonbot/verbose"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/* Collects what the bot logs */
+func captureLog(bot *_bot) *[]string {
+	var lines []string
+	bot.Printf = func(msg string, vargs ...interface{}) {
+		lines = append(lines, fmt.Sprintf(msg, vargs...))
+	}
+
+	return &lines
+}
+
+func TestConsoleUsers(t *testing.T) {
+	bot := getTestbot()
+	bot.channel = "Clan Peon"
+	bot.setUserFlags(_TEST_USERID_59, []string{"Moderator"})
+	lines := captureLog(bot)
+
+	if err := bot.HandleMessage(getEchoClient(), "/users"); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+
+	output := strings.Join(*lines, "")
+	for _, expected := range []string{
+		"3 users in Clan Peon:",
+		"PrivUser155#Azeroth (id 155) priveleged\n",
+		"TestUser59 (id 59) Moderator\n",
+		"TestUser61#Gateway (id 61)\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected: %s, Actual: %s", expected, output)
+		}
+	}
+	if strings.Contains(output, _PEONBOT_USERNAME) {
+		t.Errorf("Expected: bot left out, Actual: %s", output)
+	}
+}
+
+func TestConsoleLists(t *testing.T) {
+	bot := getTestbot()
+	bot.blist["FEDERATED#AZEROTH"] = _banSource{feed: "bans.jsonl", source: "other"}
+	lines := captureLog(bot)
+
+	_ = bot.HandleMessage(getEchoClient(), "/banlist")
+	_ = bot.HandleMessage(getEchoClient(), "/PRIVLIST")
+
+	output := strings.Join(*lines, "")
+	for _, expected := range []string{
+		"2 banned:",
+		"  BANNEDUSER159\n",
+		"  FEDERATED#AZEROTH (from other via bans.jsonl)\n",
+		"1 priveleged:",
+		"  PRIVUSER155#AZEROTH\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected: %s, Actual: %s", expected, output)
+		}
+	}
+}
+
+func TestConsoleState(t *testing.T) {
+	bot := getTestbot()
+	bot.handleConnect(_event{Payload: _payload{Channel: "Clan Peon"}})
+	lines := captureLog(bot)
+
+	_ = bot.HandleMessage(getEchoClient(), "/state")
+
+	output := strings.Join(*lines, "")
+	for _, expected := range []string{
+		"State: connected",
+		"Channel: Clan Peon",
+		"Users: 3, banned: 1, priveleged: 1",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected: %s, Actual: %s", expected, output)
+		}
+	}
+}
+
+func TestConsoleRaw(t *testing.T) {
+	bot := getTestbot()
+	client := getEchoClient()
+	_ = captureLog(bot)
+
+	err := bot.HandleMessage(client,
+		`/raw {"command": "Botapichat.SendEmoteRequest", "payload": {"message": "waves"}}`)
+	if err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+
+	raw, _ := json.Marshal(client.request)
+	expected := `{"command":"Botapichat.SendEmoteRequest","request_id":1,"payload":{"message":"waves"}}`
+	if string(raw) != expected {
+		t.Errorf("Expected: %s, Actual: %s", expected, raw)
+	}
+
+	_ = bot.HandleMessage(client, `/raw {"command": "Botapichat.DisconnectRequest", "request_id": 40}`)
+	if client.request.RequestId != 40 || client.request.Command != _REQUEST_DISC {
+		t.Errorf("Expected: disconnect with id 40, Actual: %+v", client.request)
+	}
+
+	for _, invalid := range []string{`/raw`, `/raw {"command": `, `/raw {"payload": {}}`} {
+		if err := bot.HandleMessage(client, invalid); err == nil {
+			t.Errorf("Expected: error for %s, Actual: nil", invalid)
+		}
+	}
+	if len(client.requests) != 2 {
+		t.Errorf("Expected: 2 requests, Actual: %d", len(client.requests))
+	}
+}
+
+func TestConsoleDebug(t *testing.T) {
+	bot := getTestbot()
+	_ = captureLog(bot)
+	defer verbose.SetPrinter(verbose.Enabled())
+
+	_ = bot.HandleMessage(getEchoClient(), "/debug on")
+	if !verbose.Enabled() {
+		t.Errorf("Expected: debug on, Actual: off")
+	}
+
+	_ = bot.HandleMessage(getEchoClient(), "/debug off")
+	if verbose.Enabled() {
+		t.Errorf("Expected: debug off, Actual: on")
+	}
+
+	if err := bot.HandleMessage(getEchoClient(), "/debug maybe"); err == nil {
+		t.Errorf("Expected: usage error, Actual: nil")
+	}
+}
+
+func TestConsoleNotFromChat(t *testing.T) {
+	bot := getTestbot()
+	client := getEchoClient()
+	lines := captureLog(bot)
+
+	for _, message := range []string{"/users", ".users", "/raw {\"command\": \"x\"}", ".raw {\"command\": \"x\"}"} {
+		event := getUserMessage(_TEST_USERID_155, _MSG_WHISPER, message)
+		_ = handleAction(client, bot, event)
+		bot.handleCustomCommand(client, event)
+	}
+
+	if output := strings.Join(*lines, ""); strings.Contains(output, "users in") {
+		t.Errorf("Expected: no console output, Actual: %s", output)
+	}
+	if len(client.requests) != 0 {
+		t.Errorf("Expected: no requests, Actual: %+v", client.requests)
+	}
+}
+
+func TestStaleConnError(t *testing.T) {
+	bot := getTestbot()
+	bot.Conn = &websocket.Conn{}
+
+	if bot.stale(&_connError{conn: bot.Conn, err: fmt.Errorf("closed")}) {
+		t.Errorf("Expected: error from current connection, Actual: stale")
+	}
+	if !bot.stale(&_connError{conn: &websocket.Conn{}, err: fmt.Errorf("closed")}) {
+		t.Errorf("Expected: error from old connection to be stale, Actual: not stale")
+	}
+}
+
+func TestConsoleReconnect(t *testing.T) {
+	bot, connections := startLoginServer(t, func(n int, request _request) []string {
+		if request.Command == _REQUEST_AUTH {
+			return []string{_TEST_AUTH_OK}
+		}
+		return []string{_TEST_CONNECTED, _TEST_CONN_OK}
+	})
+	bot.chrcn = make(chan _reconnect)
+	defer bot.Stop()
+
+	if err := bot.login(_TEST_RETRY); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+	old := bot.Conn
+	bot.active(_TEST_USERID_59, time.Now())
+	bot.idle.kicked = map[int]interface{}{_TEST_USERID_60: nil}
+
+	if err := bot.HandleMessage(getEchoClient(), "/reconnect"); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+	if err := bot.HandleMessage(getEchoClient(), "/reconnect"); err == nil {
+		t.Errorf("Expected an error while already reconnecting, but got nil.")
+	}
+
+	/* The event loop keeps the old connection until handed the new one */
+	var result _reconnect
+	select {
+	case result = <-bot.chrcn:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected: a new connection, Actual: none")
+	}
+	if bot.Conn != old {
+		t.Errorf("Expected: the old connection kept until reconnected")
+	}
+
+	if err := bot.reconnected(result); err != nil {
+		t.Fatalf("Expected: nil, Actual: %v", err)
+	}
+	if bot.Conn == old || atomic.LoadInt32(connections) != 2 {
+		t.Errorf("Expected: a second connection in use, Actual: %d connections",
+			atomic.LoadInt32(connections))
+	}
+	if len(bot.userTable) != 1 || len(bot.idle.seen) != 0 || len(bot.idle.kicked) != 0 {
+		t.Errorf("Expected: users forgotten, Actual: %v %v %v",
+			bot.userTable, bot.idle.seen, bot.idle.kicked)
+	}
+}
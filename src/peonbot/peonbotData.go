@@ -0,0 +1,109 @@
+package peonbot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+	State that should outlive the bot (e.g. schedules added from chat) is
+	kept as yaml files in the bot's data dir. Without a data dir, nothing
+	is persisted.
+*/
+
+/*
+	Set the data dir, and load any state that was persisted to it. Every
+	file is loaded, even if another could not be. Files that could not be
+	loaded are never saved, so what is in them is not lost to empty state.
+*/
+func (bot *_bot) SetDataDir(dir string) error {
+	var failed []string
+
+	bot.dataDir = dir
+	bot.unreadable = nil
+
+	for _, load := range []func() error{
+		bot.loadSchedules,
+		bot.loadStrikes,
+		bot.loadLockdown,
+		bot.loadPolls,
+		bot.loadCommands,
+	} {
+		if err := load(); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+/* Read a data file into `v`. A missing file leaves `v` untouched. */
+func (bot *_bot) loadData(name string, v interface{}) error {
+	if len(bot.dataDir) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(bot.dataDir, name)
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		err = yaml.Unmarshal(raw, v)
+	}
+	if err != nil {
+		err = fmt.Errorf("Could not load '%s': %v", path, err)
+		if bot.unreadable == nil {
+			bot.unreadable = make(map[string]error)
+		}
+		bot.unreadable[name] = err
+		return err
+	}
+
+	return nil
+}
+
+/* Replace a data file atomically, so a crash never leaves half of it */
+func (bot *_bot) saveData(name string, v interface{}) error {
+	if len(bot.dataDir) == 0 {
+		return nil
+	}
+
+	if err, ok := bot.unreadable[name]; ok {
+		return fmt.Errorf("Not saving '%s', so it is not lost: %v", name, err)
+	}
+
+	raw, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(bot.dataDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(bot.dataDir, name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(bot.dataDir, name))
+}
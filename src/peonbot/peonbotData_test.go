@@ -0,0 +1,48 @@
+package peonbot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetDataDirUnreadableFile(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := "schedules: [not closed"
+
+	for name, content := range map[string]string{
+		_FILE_SCHEDULES: corrupt,
+		_FILE_COMMANDS:  "commands:\n- trigger: '!rules'\n  response: Be nice\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Expected nil, but got an error: %v", err)
+		}
+	}
+
+	bot := getTestbot()
+	err := bot.SetDataDir(dir)
+	if err == nil || !strings.Contains(err.Error(), _FILE_SCHEDULES) {
+		t.Errorf("Expected: an error about %s, Actual: %v", _FILE_SCHEDULES, err)
+	}
+
+	/* Files after the unreadable one are still loaded */
+	if len(bot.commands.Commands) != 1 {
+		t.Errorf("Expected: 1 command loaded, Actual: %+v", bot.commands)
+	}
+
+	/* The unreadable file is left as it was */
+	if err := bot.saveSchedules(); err == nil {
+		t.Errorf("Expected: an error saving %s, Actual: nil", _FILE_SCHEDULES)
+	}
+	raw, _ := ioutil.ReadFile(filepath.Join(dir, _FILE_SCHEDULES))
+	if string(raw) != corrupt {
+		t.Errorf("Expected: %s left as it was, Actual: %s", _FILE_SCHEDULES, raw)
+	}
+
+	/* Others are saved as usual */
+	bot.saveCommands()
+	if err := bot.SetDataDir(dir); err == nil || strings.Contains(err.Error(), _FILE_COMMANDS) {
+		t.Errorf("Expected: only %s unreadable, Actual: %v", _FILE_SCHEDULES, err)
+	}
+}
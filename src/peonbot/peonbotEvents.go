@@ -1,35 +1,47 @@
 package peonbot
 
 import (
-	"encoding/json"
 	"fmt"
-	"log"
+	"peonbot/plugin"
+	"peonbot/script"
 	"strings"
+	"time"
 )
 
 const _EVENT_MSG = "Botapichat.MessageEventRequest"
 const _EVENT_USERUPDATE = "Botapichat.UserUpdateEventRequest"
 const _EVENT_USEREXIT = "Botapichat.UserLeaveEventRequest"
+const _EVENT_CONNECT = "Botapichat.ConnectEventRequest"
 const _MSG_CHAN = "CHANNEL"
 const _MSG_WHISPER = "WHISPER"
 
+/*
+	On connecting, the server announces everyone already in the channel as
+	if they just joined. Joins this soon after connecting are not relayed
+	to IRC, or sent to webhooks.
+*/
+const _JOIN_GRACE = 5 * time.Second
+
+/* Events, and responses to requests, which have a status if they failed */
 type _event struct {
-	Command   string `json:"command"`
-	RequestId int    `json:"request_id"`
+	Command   string   `json:"command"`
+	RequestId int      `json:"request_id"`
+	Status    *_status `json:"status"`
 	Payload   _payload
 }
 
 type _payload struct {
-	ToonName string `json:"toon_name"`
-	UserId   int    `json:"user_id"`
-	Type     string `json:"type"`
-	Message  string `json:"message"`
+	ToonName string   `json:"toon_name"`
+	UserId   int      `json:"user_id"`
+	Type     string   `json:"type"`
+	Message  string   `json:"message"`
+	Channel  string   `json:"channel"`
+	Flag     []string `json:"flag"`
 }
 
 func (bot *_bot) HandleEvent(raw []byte) error {
-	var event _event
-
-	if err := json.Unmarshal(raw, &event); err != nil {
+	event, err := bot.codec().decode(raw)
+	if err != nil {
 		return err
 	}
 	bot.setRid(event.RequestId)
@@ -39,11 +51,24 @@ func (bot *_bot) HandleEvent(raw []byte) error {
 	switch event.Command {
 	case _EVENT_MSG:
 		/* Handle action if issued from a priveleged user */
-		if err := handleAction(bot.Conn, bot, event); err != nil {
+		if err := handleAction(bot.client(), bot, event); err != nil {
 			bot.Vprintf("Could not process action: %v\n", err)
 		}
 
 		bot.handleUserMessage(event)
+		bot.handleVote(bot.client(), event, time.Now())
+		bot.handlePollVote(bot.client(), event)
+		bot.handleCustomCommand(bot.client(), event)
+		bot.publishPluginEvent(plugin.Event{
+			Event:   plugin.EVENT_MESSAGE,
+			User:    bot.userTable[event.Payload.UserId],
+			Type:    strings.ToLower(event.Payload.Type),
+			Message: event.Payload.Message,
+		})
+		bot.runScripts(bot.client(), script.HOOK_MESSAGE,
+			bot.userTable[event.Payload.UserId], event.Payload.Message)
+		bot.bridgeMessage(event)
+		bot.webhookMessage(event)
 		break
 	case _EVENT_USERUPDATE:
 		bot.handleUserUpdate(event)
@@ -51,6 +76,13 @@ func (bot *_bot) HandleEvent(raw []byte) error {
 	case _EVENT_USEREXIT:
 		bot.handleUserExit(event)
 		break
+	case _EVENT_CONNECT:
+		bot.handleConnect(event)
+		break
+	case _RESPONSE_AUTH, _RESPONSE_CONN, _RESPONSE_MSG, _RESPONSE_WHISPER,
+		_RESPONSE_BAN, _RESPONSE_UNBAN, _RESPONSE_KICK, _RESPONSE_DESIGN:
+		bot.handleResponse(event)
+		break
 	default:
 		return fmt.Errorf("Received unknown event from server: %+v\n", event)
 	}
@@ -58,13 +90,27 @@ func (bot *_bot) HandleEvent(raw []byte) error {
 	return nil
 }
 
+func (bot *_bot) handleConnect(event _event) {
+	bot.channel = event.Payload.Channel
+	bot.Printf("Joined channel: %s\n", bot.channel)
+	bot.setState(STATE_CONNECTED, "")
+	bot.connected = time.Now()
+}
+
+/* Whether joins are most likely the server announcing who is already in the channel */
+func (bot *_bot) joinGrace(now time.Time) bool {
+	return now.Sub(bot.connected) < _JOIN_GRACE
+}
+
 func (bot *_bot) handleUserMessage(event _event) {
+	bot.active(event.Payload.UserId, time.Now())
+
 	switch strings.ToUpper(event.Payload.Type) {
 	case _MSG_CHAN:
-		log.Printf("[%s] %s\n", bot.userTable[event.Payload.UserId],
+		bot.Printf("[%s] %s\n", bot.userTable[event.Payload.UserId],
 			event.Payload.Message)
 	case _MSG_WHISPER:
-		log.Printf(">>> [FROM: %s] %s\n", bot.userTable[event.Payload.UserId],
+		bot.Printf(">>> [FROM: %s] %s\n", bot.userTable[event.Payload.UserId],
 			event.Payload.Message)
 	}
 }
@@ -75,14 +121,24 @@ func (bot *_bot) handleUserMessage(event _event) {
 	update event with a coinciding `user_id`.
 */
 func (bot *_bot) handleUserUpdate(event _event) {
+	bot.setUserFlags(event.Payload.UserId, event.Payload.Flag)
+
 	if strings.Compare(bot.userTable[event.Payload.UserId], "") != 0 {
 		goto ban_list
 	}
 
 	bot.userTable[event.Payload.UserId] = event.Payload.ToonName
+	bot.active(event.Payload.UserId, time.Now())
 
-	log.Printf("> %s has joined the channel.\n",
+	bot.Printf("> %s has joined the channel.\n",
 		bot.userTable[event.Payload.UserId])
+	bot.publishPluginEvent(plugin.Event{
+		Event: plugin.EVENT_JOIN,
+		User:  event.Payload.ToonName,
+	})
+	bot.runScripts(bot.client(), script.HOOK_JOIN, event.Payload.ToonName)
+	bot.bridgeJoin(event.Payload.ToonName)
+	bot.webhookJoin(event.Payload.ToonName)
 
 	/*
 		XXX: Disabling this for now. Bot whispers everyone upon joining the
@@ -94,11 +150,14 @@ func (bot *_bot) handleUserUpdate(event _event) {
 	// }
 
 ban_list:
-	if _, ok := bot.blist[strings.ToUpper(
-		bot.userTable[event.Payload.UserId])]; ok {
+	if bot.banned(bot.userTable[event.Payload.UserId]) ||
+		bot.timedBan(bot.userTable[event.Payload.UserId], time.Now()) {
 
-		_ = _handleActionBan(bot.Conn, bot, event.Payload.UserId)
+		_ = _handleActionBan(bot.client(), bot, event.Payload.UserId)
+		return
 	}
+
+	bot.enforceLockdown(bot.client(), event.Payload.UserId)
 }
 
 func (bot *_bot) handleUserExit(event _event) {
@@ -106,8 +165,16 @@ func (bot *_bot) handleUserExit(event _event) {
 		No need to check if user exists until it is shown that spurious or
 		duplicate user exit events are sent from the server
 	*/
-	log.Printf("< %s has left the channel.\n",
+	bot.Printf("< %s has left the channel.\n",
 		bot.userTable[event.Payload.UserId])
+	bot.publishPluginEvent(plugin.Event{
+		Event: plugin.EVENT_LEAVE,
+		User:  bot.userTable[event.Payload.UserId],
+	})
+	bot.runScripts(bot.client(), script.HOOK_LEAVE, bot.userTable[event.Payload.UserId])
+	bot.bridgeLeave(bot.userTable[event.Payload.UserId])
 
 	delete(bot.userTable, event.Payload.UserId)
+	delete(bot.userFlags, event.Payload.UserId)
+	bot.forgetActivity(event.Payload.UserId)
 }
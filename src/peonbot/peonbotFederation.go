@@ -0,0 +1,178 @@
+package peonbot
+
+import (
+	"peonbot/ban"
+	"peonbot/federation"
+	"strings"
+	"time"
+)
+
+/*
+	Ban federation. Bans read from shared feeds are merged into the ban
+	list, attributed to the feed and source they came from. Bans made with
+	`.addban` and `.rmban` are published to feeds that allow it.
+
+	Entries in the ban list from config or `.addban` have a nil value, and
+	federated entries have a `_banSource` value. A local entry always wins:
+	feeds never overwrite it, and unbans from feeds never lift it.
+*/
+
+type _banSource struct {
+	feed   string
+	source string
+}
+
+type _feed struct {
+	store   federation.Store
+	trust   federation.Trust
+	publish bool
+	offset  int /* number of entries already applied */
+}
+
+type _feedUpdate struct {
+	feed    *_feed
+	entries []federation.Entry
+}
+
+/* The source name this bot publishes its bans under */
+func (bot *_bot) SetFederationName(name string) {
+	bot.fedName = name
+}
+
+func (bot *_bot) AddFeed(store federation.Store, trust federation.Trust, publish bool) {
+	bot.feeds = append(bot.feeds, &_feed{
+		store:   store,
+		trust:   trust,
+		publish: publish,
+	})
+}
+
+/*
+	Fetch every feed on an interval, and hand the entries to the event
+	loop. Blocks until the bot stops, so start it on its own goroutine.
+	Feeds are only read here, and are not changed once it is started.
+*/
+func (bot *_bot) PollFeeds(interval time.Duration) {
+	for {
+		for _, feed := range bot.feeds {
+			entries, err := feed.store.Fetch()
+			if err != nil {
+				bot.Vprintf("Could not fetch ban feed '%s': %v\n", feed.store, err)
+				continue
+			}
+
+			select {
+			case bot.chfed <- _feedUpdate{feed: feed, entries: entries}:
+			case <-bot.ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-bot.ctx.Done():
+			return
+		}
+	}
+}
+
+/* Apply entries the feed has not seen yet. Only call from the event loop. */
+func (bot *_bot) applyFeed(client WebsocketClient, update _feedUpdate) {
+	feed := update.feed
+
+	/* The feed was reset. Bans are idempotent, so start over. */
+	if len(update.entries) < feed.offset {
+		feed.offset = 0
+	}
+
+	for _, entry := range update.entries[feed.offset:] {
+		bot.applyFeedEntry(client, feed, entry)
+	}
+	feed.offset = len(update.entries)
+}
+
+func (bot *_bot) applyFeedEntry(client WebsocketClient, feed *_feed, entry federation.Entry) {
+	/* Skip what this bot published itself */
+	if strings.Compare(entry.Source, bot.fedName) == 0 {
+		return
+	}
+
+	attribution := _banSource{feed: feed.store.String(), source: entry.Source}
+	if ban.IsPattern(entry.User) {
+		bot.Printf("[Bot log message] Ignoring %s of %s from %s (%s): patterns are not shared\n",
+			entry.Action, entry.User, attribution.source, attribution.feed)
+		return
+	}
+	user := ban.Key(entry.User)
+
+	if feed.trust == federation.TrustWatch {
+		bot.Printf("[Bot log message] Ignoring %s of %s from %s (%s): feed is watch only\n",
+			entry.Action, entry.User, attribution.source, attribution.feed)
+		return
+	}
+
+	current, banned := bot.blist[user]
+
+	switch entry.Action {
+	case federation.ACTION_BAN:
+		if banned {
+			return
+		}
+
+		bot.blist[user] = attribution
+		bot.banlistChanged()
+		bot.Printf("[Bot log message] Added to banlist: %s (from %s via %s)\n",
+			entry.User, attribution.source, attribution.feed)
+
+		if uid := bot.lookupUid(entry.User); uid != -1 {
+			_ = _handleActionBan(client, bot, uid)
+		}
+	case federation.ACTION_UNBAN:
+		if !banned {
+			return
+		}
+
+		from, federated := current.(_banSource)
+		_, configured := bot.configBlist[user]
+		if !federated || configured {
+			bot.Vprintf("Ignoring unban of %s from %s: banned locally\n",
+				entry.User, attribution.source)
+			return
+		}
+
+		if feed.trust != federation.TrustFull &&
+			strings.Compare(from.source, entry.Source) != 0 {
+
+			bot.Vprintf("Ignoring unban of %s from %s: banned by %s\n",
+				entry.User, attribution.source, from.source)
+			return
+		}
+
+		delete(bot.blist, user)
+		bot.banlistChanged()
+		bot.Printf("[Bot log message] Removed from banlist: %s (from %s via %s)\n",
+			entry.User, attribution.source, attribution.feed)
+		_ = handleActionUnban(client, bot, entry.User)
+	}
+}
+
+/* Publish a ban decision to every feed that allows it, without blocking */
+func (bot *_bot) publishBan(user string, action string) {
+	if len(bot.fedName) == 0 {
+		return
+	}
+
+	entry := federation.NewEntry(user, action, bot.fedName)
+	for _, feed := range bot.feeds {
+		if !feed.publish {
+			continue
+		}
+
+		go func(store federation.Store) {
+			if err := store.Publish(entry); err != nil {
+				bot.Printf("Could not publish %s of %s to '%s': %v\n",
+					action, user, store, err)
+			}
+		}(feed.store)
+	}
+}
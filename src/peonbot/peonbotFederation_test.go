@@ -0,0 +1,211 @@
+package peonbot
+
+import (
+	"path/filepath"
+	"peonbot/federation"
+	"strings"
+	"testing"
+	"time"
+)
+
+const _TEST_FEDERATION_NAME = "clan"
+
+func getTestFeed(trust federation.Trust) *_feed {
+	return &_feed{
+		store: federation.NewStore("feed.json", ""),
+		trust: trust,
+	}
+}
+
+func applyTestEntries(client WebsocketClient, testbot *_bot, feed *_feed, entries ...federation.Entry) {
+	testbot.applyFeed(client, _feedUpdate{feed: feed, entries: entries})
+}
+
+func TestApplyFeedBan(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	applyTestEntries(client, testbot, getTestFeed(federation.TrustStandard),
+		federation.NewEntry(_TEST_USERNAME_TESTUSER61_GATEWAY, federation.ACTION_BAN, "other"))
+
+	from, ok := testbot.blist[strings.ToUpper(_TEST_USERNAME_TESTUSER61_GATEWAY)].(_banSource)
+	if !ok || strings.Compare("other", from.source) != 0 {
+		t.Errorf("Ban should be attributed to its source, but got: %+v", from)
+	}
+
+	if client.request.Command != _REQUEST_BAN ||
+		client.request.Payload.(_payloadAction).UserId != _TEST_USERID_61 {
+		t.Errorf("User in channel should have been banned, but got: %+v", client.request)
+	}
+}
+
+func TestApplyFeedIgnoresPatterns(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	blist := len(testbot.blist)
+
+	applyTestEntries(client, testbot, getTestFeed(federation.TrustFull),
+		federation.NewEntry("*#*", federation.ACTION_BAN, "other"),
+		federation.NewEntry("!"+_TEST_USERNAME_TESTUSER61_GATEWAY, federation.ACTION_BAN, "other"),
+		federation.NewEntry(`/^testuser\d+#/`, federation.ACTION_BAN, "other"))
+
+	if len(testbot.blist) != blist || len(client.requests) != 0 {
+		t.Errorf("Patterns from a feed should be ignored, but got: %v, %+v", testbot.blist, client.requests)
+	}
+}
+
+func TestApplyFeedOnlyNewEntries(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	feed := getTestFeed(federation.TrustStandard)
+
+	ban := federation.NewEntry(_TEST_USERNAME_TESTUSER61_GATEWAY, federation.ACTION_BAN, "other")
+	applyTestEntries(client, testbot, feed, ban)
+	applyTestEntries(client, testbot, feed, ban)
+
+	if len(client.requests) != 1 {
+		t.Errorf("Entries should only be applied once, but got: %+v", client.requests)
+	}
+}
+
+func TestApplyFeedUnbanStandardTrust(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	feed := getTestFeed(federation.TrustStandard)
+	user := strings.ToUpper(_TEST_USERNAME_TESTUSER60 + "#Azeroth")
+
+	applyTestEntries(client, testbot, feed,
+		federation.NewEntry(user, federation.ACTION_BAN, "other"),
+		federation.NewEntry(user, federation.ACTION_UNBAN, "someone"))
+
+	if _, ok := testbot.blist[user]; !ok {
+		t.Errorf("Unban from a different source should have been ignored.")
+	}
+
+	applyTestEntries(client, testbot, feed,
+		federation.NewEntry(user, federation.ACTION_BAN, "other"),
+		federation.NewEntry(user, federation.ACTION_UNBAN, "someone"),
+		federation.NewEntry(user, federation.ACTION_UNBAN, "other"))
+
+	if _, ok := testbot.blist[user]; ok {
+		t.Errorf("Unban from the same source should have lifted the ban.")
+	}
+}
+
+func TestApplyFeedUnbanFullTrust(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	user := strings.ToUpper(_TEST_USERNAME_TESTUSER60 + "#Azeroth")
+
+	applyTestEntries(client, testbot, getTestFeed(federation.TrustFull),
+		federation.NewEntry(user, federation.ACTION_BAN, "other"),
+		federation.NewEntry(user, federation.ACTION_UNBAN, "someone"))
+
+	if _, ok := testbot.blist[user]; ok {
+		t.Errorf("Unban from a fully trusted feed should have lifted the ban.")
+	}
+
+	if client.request.Command != _REQUEST_UNBAN {
+		t.Errorf("Expected: %s, Actual: %s", _REQUEST_UNBAN, client.request.Command)
+	}
+}
+
+func TestApplyFeedNeverLiftsLocalBan(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	applyTestEntries(client, testbot, getTestFeed(federation.TrustFull),
+		federation.NewEntry(_TEST_USERNAME_BANNED_BANNEDUSER159+"#Azeroth",
+			federation.ACTION_UNBAN, "other"),
+		federation.NewEntry(_TEST_USERNAME_BANNED_BANNEDUSER159,
+			federation.ACTION_UNBAN, "other"))
+
+	if _, ok := testbot.blist[strings.ToUpper(_TEST_USERNAME_BANNED_BANNEDUSER159)]; !ok {
+		t.Errorf("Local ban should never be lifted by a feed.")
+	}
+
+	if len(client.requests) != 0 {
+		t.Errorf("No requests should have been sent, but got: %+v", client.requests)
+	}
+}
+
+func TestApplyFeedWatchAndOwnEntries(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	testbot.SetFederationName(_TEST_FEDERATION_NAME)
+
+	applyTestEntries(client, testbot, getTestFeed(federation.TrustWatch),
+		federation.NewEntry(_TEST_USERNAME_TESTUSER61_GATEWAY, federation.ACTION_BAN, "other"))
+	applyTestEntries(client, testbot, getTestFeed(federation.TrustFull),
+		federation.NewEntry(_TEST_USERNAME_TESTUSER61_GATEWAY, federation.ACTION_BAN,
+			_TEST_FEDERATION_NAME))
+
+	if _, ok := testbot.blist[strings.ToUpper(_TEST_USERNAME_TESTUSER61_GATEWAY)]; ok {
+		t.Errorf("Entries from watch only feeds, or from the bot itself, should be ignored.")
+	}
+}
+
+func TestAddBanPublishes(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	testbot.SetFederationName(_TEST_FEDERATION_NAME)
+
+	store := federation.NewStore(filepath.Join(t.TempDir(), "bans.json"), "")
+	testbot.AddFeed(store, federation.TrustStandard, true)
+
+	handleActionAddBan(client, testbot, _TEST_USERNAME_TESTUSER61_GATEWAY)
+
+	/* Publishing happens in the background */
+	var entries []federation.Entry
+	for i := 0; i < 100 && len(entries) == 0; i++ {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+		entries, _ = store.Fetch()
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected: %d entry, Actual: %d", 1, len(entries))
+	}
+
+	if entries[0].Source != _TEST_FEDERATION_NAME || entries[0].Action != federation.ACTION_BAN {
+		t.Errorf("Unexpected entry published: %+v", entries[0])
+	}
+}
+
+func TestReloadKeepsFederatedBans(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	user := strings.ToUpper(_TEST_USERNAME_TESTUSER60 + "#Azeroth")
+
+	applyTestEntries(client, testbot, getTestFeed(federation.TrustStandard),
+		federation.NewEntry(user, federation.ACTION_BAN, "other"))
+
+	testbot.Reload(client, nil, nil)
+
+	if _, ok := testbot.blist[user].(_banSource); !ok {
+		t.Errorf("Federated ban should survive a reload.")
+	}
+}
+
+func TestReloadKeepsFederatedAttribution(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	feed := getTestFeed(federation.TrustStandard)
+	user := strings.ToUpper(_TEST_USERNAME_TESTUSER60 + "#Azeroth")
+	banned := federation.NewEntry(user, federation.ACTION_BAN, "other")
+
+	applyTestEntries(client, testbot, feed, banned)
+
+	/* The same user is added to config, and config is reloaded twice */
+	for i := 0; i < 2; i++ {
+		testbot.Reload(client, []string{_TEST_USERNAME_BANNED_BANNEDUSER159, user}, []string{_TEST_USERNAME_PRIVUSER155})
+	}
+	if from, ok := testbot.blist[user].(_banSource); !ok || from.source != "other" {
+		t.Errorf("Federated ban should keep its source, Actual: %+v", testbot.blist[user])
+	}
+
+	/* The feed cannot lift it while it is in config */
+	applyTestEntries(client, testbot, feed, banned, federation.NewEntry(user, federation.ACTION_UNBAN, "other"))
+	if _, ok := testbot.blist[user]; !ok {
+		t.Errorf("Ban in config should not be lifted by a feed.")
+	}
+}
@@ -0,0 +1,135 @@
+package peonbot
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+	Bots running in the same process, one per api key, sharing a console.
+	Bots are added before any goroutine uses the group.
+	Lines from stdin are routed to a bot by prefixing them with its name,
+	e.g. `@clan /kick name#Azeroth`, or `@all hi` to send to every bot.
+	With a single bot, no prefix is needed.
+*/
+type _group struct {
+	bots []*_bot
+}
+
+const _STDIN_INSTANCE_DELIMITER = "@"
+const _STDIN_INSTANCE_ALL = "ALL"
+
+/* How long to wait on a bot that is not reading from stdin anymore */
+const _STDIN_SEND_TIMEOUT = time.Duration(3) * time.Second
+
+func NewGroup() *_group {
+	return &_group{}
+}
+
+func (g *_group) Add(bot *_bot) {
+	g.bots = append(g.bots, bot)
+}
+
+func (g *_group) Bots() []*_bot {
+	return g.bots
+}
+
+func (g *_group) Stop() {
+	for _, bot := range g.bots {
+		bot.Stop()
+	}
+}
+
+func (g *_group) RequestReload() {
+	for _, bot := range g.bots {
+		bot.RequestReload()
+	}
+}
+
+func (g *_group) lookupBot(name string) *_bot {
+	for _, bot := range g.bots {
+		if strings.Compare(strings.ToUpper(bot.Name()),
+			strings.ToUpper(name)) == 0 {
+			return bot
+		}
+	}
+
+	return nil
+}
+
+/* Returns the bots a stdin line is addressed to, and the line sans prefix */
+func (g *_group) route(line string) ([]*_bot, string, error) {
+	if !strings.HasPrefix(line, _STDIN_INSTANCE_DELIMITER) {
+		if len(g.bots) == 1 {
+			return g.bots, line, nil
+		}
+
+		return nil, "", fmt.Errorf(
+			"Prefix the line with the bot it is for, e.g. '@%s %s'.",
+			g.bots[0].Name(), line)
+	}
+
+	parts := strings.SplitN(line[1:], " ", 2)
+	if len(parts) < 2 || len(strings.TrimSpace(parts[1])) == 0 {
+		return nil, "", fmt.Errorf("Nothing to send to '%s'.", parts[0])
+	}
+
+	if strings.Compare(strings.ToUpper(parts[0]), _STDIN_INSTANCE_ALL) == 0 {
+		return g.bots, parts[1], nil
+	}
+
+	bot := g.lookupBot(parts[0])
+	if bot == nil {
+		return nil, "", fmt.Errorf("No bot named '%s'.", parts[0])
+	}
+
+	return []*_bot{bot}, parts[1], nil
+}
+
+func (g *_group) ListenStdin() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := g.Input(line); err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+}
+
+/* Route a console line to the bots it is addressed to */
+func (g *_group) Input(line string) error {
+	bots, msg, err := g.route(line)
+	if err != nil {
+		return err
+	}
+
+	for _, bot := range bots {
+		select {
+		case bot.chsin <- msg:
+		case <-bot.ctx.Done():
+			bot.Printf("Stopped. Dropped: %s\n", msg)
+		case <-time.After(_STDIN_SEND_TIMEOUT):
+			bot.Printf("Not accepting input. Dropped: %s\n", msg)
+		}
+	}
+
+	return nil
+}
+
+func (g *_group) Snapshots() []Snapshot {
+	snapshots := make([]Snapshot, len(g.bots))
+	for i, bot := range g.bots {
+		snapshots[i] = bot.Snapshot()
+	}
+
+	return snapshots
+}
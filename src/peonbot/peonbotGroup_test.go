@@ -0,0 +1,86 @@
+package peonbot
+
+import (
+	"strings"
+	"testing"
+)
+
+func getTestGroup(names ...string) *_group {
+	group := NewGroup()
+	for _, name := range names {
+		bot := getTestbot()
+		bot.SetName(name)
+		group.Add(bot)
+	}
+
+	return group
+}
+
+func TestRouteSingleBot(t *testing.T) {
+	group := NewGroup()
+	group.Add(getTestbot())
+
+	bots, msg, err := group.route("/kick " + _TEST_USERNAME_TESTUSER61_GATEWAY)
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(bots) != 1 || strings.Compare("/kick "+_TEST_USERNAME_TESTUSER61_GATEWAY, msg) != 0 {
+		t.Errorf("Line should be sent to the only bot unchanged, but got: %s", msg)
+	}
+}
+
+func TestRouteNamedBot(t *testing.T) {
+	group := getTestGroup("clan", "op")
+
+	bots, msg, err := group.route("@OP hello there")
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(bots) != 1 || strings.Compare("op", bots[0].Name()) != 0 {
+		t.Errorf("Line should be routed to bot 'op', but got: %v", bots)
+	}
+
+	if strings.Compare("hello there", msg) != 0 {
+		t.Errorf("Expected: %s, Actual: %s", "hello there", msg)
+	}
+}
+
+func TestRouteAllBots(t *testing.T) {
+	group := getTestGroup("clan", "op")
+
+	bots, _, err := group.route("@all hi")
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(bots) != 2 {
+		t.Errorf("Expected: %d bots, Actual: %d", 2, len(bots))
+	}
+}
+
+func TestRouteErrors(t *testing.T) {
+	group := getTestGroup("clan", "op")
+
+	for _, line := range []string{"hi", "@nobody hi", "@clan", "@clan  "} {
+		if _, _, err := group.route(line); err == nil {
+			t.Errorf("Expected an error routing '%s', but got nil.", line)
+		}
+	}
+}
+
+func TestGroupRequestReload(t *testing.T) {
+	group := getTestGroup("clan", "op")
+	for _, bot := range group.Bots() {
+		bot.chrld = make(chan struct{}, 1)
+	}
+
+	group.RequestReload()
+
+	for _, bot := range group.Bots() {
+		if len(bot.Chrld()) != 1 {
+			t.Errorf("Bot '%s' should have a pending reload, but does not.", bot.Name())
+		}
+	}
+}
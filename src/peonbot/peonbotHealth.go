@@ -0,0 +1,149 @@
+package peonbot
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	Health of the connection to battle.net. A bot is connecting until the
+	handshake is done, authenticating until it has joined its channel, and
+	connected after. It is degraded while connected, but either the server
+	has not answered a ping in time, or there have been no events for a
+	while. It is disconnected once reading from the websocket fails, which
+	it does when nothing at all is read, not even a pong, for `_PONG_WAIT`.
+*/
+const STATE_CONNECTING = "connecting"
+const STATE_AUTHENTICATING = "authenticating"
+const STATE_CONNECTED = "connected"
+const STATE_DEGRADED = "degraded"
+const STATE_DISCONNECTED = "disconnected"
+
+/* How often to ping the server */
+const _PING_INTERVAL = 30 * time.Second
+
+/* How long to wait for a pong before the connection is degraded */
+const _PONG_LATE = 10 * time.Second
+
+/* How long to wait for anything to be read before giving up */
+const _PONG_WAIT = 2 * _PING_INTERVAL
+
+/*
+	How long without events before the connection is degraded. A quiet
+	channel sends nothing, so this is only a hint that something is off.
+*/
+const _IDLE_TIMEOUT = 15 * time.Minute
+
+const _WRITE_WAIT = 10 * time.Second
+
+/* Pings and pongs of one connection */
+type _keepalive struct {
+	conn *websocket.Conn
+
+	interval time.Duration
+	late     time.Duration
+	wait     time.Duration
+
+	pinged time.Time /* last ping sent, only touched by the event loop */
+	ponged int64     /* unix nanoseconds of the last pong, set by the reader */
+}
+
+/*
+	Ping on `conn`, and expect to read something, a pong if nothing else,
+	within `wait` of the last read. Call before reading from `conn`.
+*/
+func newKeepalive(conn *websocket.Conn, interval time.Duration, late time.Duration,
+	wait time.Duration) *_keepalive {
+
+	k := &_keepalive{conn: conn, interval: interval, late: late, wait: wait}
+
+	_ = conn.SetReadDeadline(time.Now().Add(wait))
+	/* Called from ReadMessage, on the goroutine reading from `conn` */
+	conn.SetPongHandler(func(string) error {
+		atomic.StoreInt64(&k.ponged, time.Now().UnixNano())
+		return conn.SetReadDeadline(time.Now().Add(wait))
+	})
+
+	return k
+}
+
+/* Extend the read deadline after reading a message. Only call from the reader. */
+func (k *_keepalive) read() {
+	_ = k.conn.SetReadDeadline(time.Now().Add(k.wait))
+}
+
+/* Ping if it is time to. WriteControl is safe alongside other writes. */
+func (k *_keepalive) ping(now time.Time) error {
+	if now.Sub(k.pinged) < k.interval {
+		return nil
+	}
+
+	k.pinged = now
+	return k.conn.WriteControl(websocket.PingMessage, nil, now.Add(_WRITE_WAIT))
+}
+
+/* How long the last ping has gone unanswered, or 0 */
+func (k *_keepalive) overdue(now time.Time) time.Duration {
+	if k.pinged.IsZero() {
+		return 0
+	}
+
+	ponged := time.Unix(0, atomic.LoadInt64(&k.ponged))
+	if !ponged.Before(k.pinged) {
+		return 0
+	}
+
+	return now.Sub(k.pinged)
+}
+
+func (bot *_bot) state() string {
+	if len(bot.health) == 0 {
+		return STATE_CONNECTING
+	}
+
+	return bot.health
+}
+
+/* Move to `state`, logging why if it is a change */
+func (bot *_bot) setState(state string, reason string) {
+	if state == bot.state() && len(bot.health) > 0 {
+		return
+	}
+
+	bot.health = state
+	if len(reason) > 0 {
+		bot.Printf("Connection state: %s (%s)\n", state, reason)
+	} else {
+		bot.Printf("Connection state: %s\n", state)
+	}
+}
+
+/*
+	Ping, and move between connected and degraded as pongs and events
+	arrive or not. Only call from the event loop.
+*/
+func (bot *_bot) checkHealth(now time.Time) {
+	if bot.keepalive == nil {
+		return
+	}
+
+	if err := bot.keepalive.ping(now); err != nil {
+		bot.Vprintf("Could not ping: %v\n", err)
+	}
+
+	state := bot.state()
+	if state != STATE_CONNECTED && state != STATE_DEGRADED {
+		return
+	}
+
+	if overdue := bot.keepalive.overdue(now); overdue > bot.keepalive.late {
+		bot.setState(STATE_DEGRADED, fmt.Sprintf("no pong for %s", overdue.Round(time.Second)))
+	} else if idle := now.Sub(bot.lastEvent); idle > _IDLE_TIMEOUT {
+		bot.setState(STATE_DEGRADED, fmt.Sprintf("no events for %s", idle.Round(time.Second)))
+	} else if state == STATE_DEGRADED {
+		bot.setState(STATE_CONNECTED, "")
+	}
+}
@@ -0,0 +1,158 @@
+package peonbot
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	A websocket server that answers pings while `answer` is true, and
+	otherwise reads nothing, like a half open connection
+*/
+func startPingServer(t *testing.T, answer bool) (*websocket.Conn, func()) {
+	done := make(chan struct{})
+	upgrader := &websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			if !answer {
+				<-done
+				return
+			}
+			/* Pongs are sent while reading */
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+
+	conn, _, err := getDialer().Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		close(done)
+		server.Close()
+	}
+}
+
+func getConnectedTestbot(conn *websocket.Conn, wait time.Duration) *_bot {
+	bot := getTestbot()
+	bot.chbnt = make(chan []byte)
+	bot.cherr = make(chan error, 1)
+	bot.Conn = conn
+	bot.keepalive = newKeepalive(conn, time.Hour, 50*time.Millisecond, wait)
+	bot.handleConnect(_event{Payload: _payload{Channel: "Clan Peon"}})
+	bot.lastEvent = time.Now()
+
+	return bot
+}
+
+func TestKeepalive(t *testing.T) {
+	conn, stop := startPingServer(t, true)
+	defer stop()
+
+	bot := getConnectedTestbot(conn, time.Minute)
+	go bot.listen(bot.keepalive, nil)
+
+	now := time.Now()
+	bot.checkHealth(now)
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&bot.keepalive.ponged) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	bot.checkHealth(now.Add(time.Second))
+	if bot.state() != STATE_CONNECTED {
+		t.Errorf("Expected: %s, Actual: %s", STATE_CONNECTED, bot.state())
+	}
+
+	/* Not pinged again until the interval is up */
+	pinged := bot.keepalive.pinged
+	bot.checkHealth(now.Add(time.Minute))
+	if bot.keepalive.pinged != pinged {
+		t.Errorf("Expected: no ping before the interval, Actual: pinged at %v", bot.keepalive.pinged)
+	}
+}
+
+func TestKeepaliveNoPong(t *testing.T) {
+	conn, stop := startPingServer(t, false)
+	defer stop()
+
+	bot := getConnectedTestbot(conn, 200*time.Millisecond)
+	lines := captureLog(bot)
+	go bot.listen(bot.keepalive, nil)
+
+	now := time.Now()
+	bot.checkHealth(now)
+	bot.checkHealth(now.Add(time.Second))
+	if bot.state() != STATE_DEGRADED {
+		t.Errorf("Expected: %s, Actual: %s", STATE_DEGRADED, bot.state())
+	}
+	if output := strings.Join(*lines, ""); !strings.Contains(output, "degraded (no pong for 1s)") {
+		t.Errorf("Expected: transition logged, Actual: %s", output)
+	}
+
+	/* Nothing is read in time, so reading fails */
+	select {
+	case err := <-bot.cherr:
+		if netErr, ok := err.(*_connError).err.(net.Error); !ok || !netErr.Timeout() {
+			t.Errorf("Expected: timeout, Actual: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected: read to time out, Actual: still reading")
+	}
+}
+
+func TestHealthIdle(t *testing.T) {
+	conn, stop := startPingServer(t, true)
+	defer stop()
+
+	bot := getConnectedTestbot(conn, time.Minute)
+	lines := captureLog(bot)
+
+	now := time.Now()
+	bot.checkHealth(now.Add(_IDLE_TIMEOUT + time.Minute))
+	if bot.state() != STATE_DEGRADED {
+		t.Errorf("Expected: %s, Actual: %s", STATE_DEGRADED, bot.state())
+	}
+
+	/* Back to connected once events arrive, and logged once each way */
+	bot.lastEvent = now.Add(_IDLE_TIMEOUT + time.Minute)
+	bot.checkHealth(now.Add(_IDLE_TIMEOUT + time.Minute))
+	bot.checkHealth(now.Add(_IDLE_TIMEOUT + time.Minute))
+	if bot.state() != STATE_CONNECTED {
+		t.Errorf("Expected: %s, Actual: %s", STATE_CONNECTED, bot.state())
+	}
+
+	expected := []string{
+		"Connection state: degraded (no events for 16m0s)\n",
+		"Connection state: connected\n",
+	}
+	if strings.Join(*lines, "") != strings.Join(expected, "") {
+		t.Errorf("Expected: %s, Actual: %s", expected, *lines)
+	}
+
+	/* Neither before joining the channel */
+	bot.setState(STATE_AUTHENTICATING, "")
+	bot.checkHealth(now.Add(time.Hour))
+	if bot.state() != STATE_AUTHENTICATING {
+		t.Errorf("Expected: %s, Actual: %s", STATE_AUTHENTICATING, bot.state())
+	}
+}
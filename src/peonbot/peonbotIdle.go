@@ -0,0 +1,124 @@
+package peonbot
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+	When each user in the channel was last active, i.e. sent a message or
+	joined. Users already in the channel when the bot joins count as having
+	just joined. `.idle name#Gateway` says how long someone has been idle.
+
+	Optionally, when the channel is close to full, users idle for longer
+	than `kickAfter` are kicked, longest idle first, until there is room
+	again. Priveleged users and moderators are never kicked for it.
+*/
+
+const _ACTION_IDLE = ".IDLE"
+
+const _IDLE_KICK_MESSAGE = "Kicked for being idle while the channel is full."
+
+type _idle struct {
+	kickAfter time.Duration /* 0 never kicks */
+	capacity  int           /* most users the channel holds */
+	headroom  int           /* kick when fewer places than this are left */
+
+	seen   map[int]time.Time   /* last activity, by uid */
+	kicked map[int]interface{} /* kicked, but not yet gone */
+}
+
+/* Kick users idle for `after` when fewer than `headroom` of `capacity` places are left */
+func (bot *_bot) SetIdleKick(after time.Duration, capacity int, headroom int) {
+	bot.idle.kickAfter = after
+	bot.idle.capacity = capacity
+	bot.idle.headroom = headroom
+}
+
+/* Note that the user was active */
+func (bot *_bot) active(uid int, now time.Time) {
+	if _, ok := bot.userTable[uid]; !ok {
+		return
+	}
+
+	if bot.idle.seen == nil {
+		bot.idle.seen = make(map[int]time.Time)
+	}
+
+	bot.idle.seen[uid] = now
+}
+
+func (bot *_bot) forgetActivity(uid int) {
+	delete(bot.idle.seen, uid)
+	delete(bot.idle.kicked, uid)
+}
+
+/* `.idle name#Gateway` */
+func handleActionIdle(client WebsocketClient, bot *_bot, target string, event _event, now time.Time) {
+	uid := bot.lookupUid(target)
+	if uid == -1 {
+		reply(client, bot, event, fmt.Sprintf("%s is not in the channel.", target))
+		return
+	}
+
+	seen, ok := bot.idle.seen[uid]
+	if !ok {
+		reply(client, bot, event, fmt.Sprintf("%s has not been seen yet.", bot.userTable[uid]))
+		return
+	}
+
+	reply(client, bot, event, fmt.Sprintf("%s has been idle for %v.",
+		bot.userTable[uid], now.Sub(seen).Round(time.Second)))
+}
+
+/* Kick idle users if the channel is close to full. Only call from the event loop. */
+func (bot *_bot) runIdle(client WebsocketClient, now time.Time) {
+	idle := &bot.idle
+	if idle.kickAfter == 0 {
+		return
+	}
+
+	members := len(bot.userTable) - len(idle.kicked)
+	if _, ok := bot.userTable[_PEONBOT_USERID]; ok {
+		members--
+	}
+
+	var candidates []int
+	for uid := range bot.userTable {
+		seen, ok := idle.seen[uid]
+		if _, kicked := idle.kicked[uid]; kicked || !ok || now.Sub(seen) < idle.kickAfter {
+			continue
+		}
+		if uid == _PEONBOT_USERID || bot.isPriveleged(uid) || bot.isModerator(uid) {
+			continue
+		}
+		candidates = append(candidates, uid)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return idle.seen[candidates[i]].Before(idle.seen[candidates[j]])
+	})
+
+	for _, uid := range candidates {
+		if idle.capacity-members >= idle.headroom {
+			return
+		}
+
+		bot.Printf("[Bot log message] Channel is nearly full. Kicking %s, idle for %v\n",
+			bot.userTable[uid], now.Sub(idle.seen[uid]).Round(time.Second))
+
+		if err := _handleActionWhisper(client, bot, uid, _IDLE_KICK_MESSAGE); err != nil {
+			bot.Printf("Could not whisper %s: %v\n", bot.userTable[uid], err)
+		}
+		if err := _handleActionKick(client, bot, uid); err != nil {
+			bot.Printf("Could not kick %s: %v\n", bot.userTable[uid], err)
+			continue
+		}
+
+		if idle.kicked == nil {
+			idle.kicked = make(map[int]interface{})
+		}
+		idle.kicked[uid] = nil
+		members--
+	}
+}
@@ -0,0 +1,97 @@
+package peonbot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHandleActionIdle(t *testing.T) {
+	bot := getTestbot()
+	now := time.Now()
+
+	bot.active(_TEST_USERID_61, now.Add(-90*time.Second))
+	bot.active(70, now) /* not in the channel */
+
+	for target, expected := range map[string]string{
+		_TEST_USERNAME_TESTUSER61_GATEWAY: _TEST_USERNAME_TESTUSER61_GATEWAY + " has been idle for 1m30s.",
+		_TEST_USERNAME_PRIVUSER155:        _TEST_USERNAME_PRIVUSER155 + " has not been seen yet.",
+		"Nobody#Azeroth":                  "Nobody#Azeroth is not in the channel.",
+	} {
+		client := getEchoClient()
+		handleActionIdle(client, bot, target, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".idle "+target), now)
+		if actual := client.request.Payload.(_payloadMessage).Message; actual != expected {
+			t.Errorf("Expected: %s, Actual: %s", expected, actual)
+		}
+	}
+
+	if _, ok := bot.idle.seen[70]; ok {
+		t.Errorf("Expected: activity of users not in the channel ignored, Actual: %+v", bot.idle.seen)
+	}
+}
+
+func TestIdleActivity(t *testing.T) {
+	bot := getTestbot()
+
+	join(bot, 70, "Peon#Azeroth")
+	joined := bot.idle.seen[70]
+	if joined.IsZero() {
+		t.Fatalf("Expected: join is activity, Actual: %+v", bot.idle.seen)
+	}
+
+	time.Sleep(time.Millisecond)
+	bot.handleUserMessage(getUserMessage(70, _MSG_CHAN, "zug zug"))
+	if !bot.idle.seen[70].After(joined) {
+		t.Errorf("Expected: message is activity, Actual: %v", bot.idle.seen[70])
+	}
+
+	bot.handleUserExit(getAction(_EVENT_USEREXIT, _payload{UserId: 70}))
+	if _, ok := bot.idle.seen[70]; ok {
+		t.Errorf("Expected: forgotten on exit, Actual: %+v", bot.idle.seen)
+	}
+}
+
+func TestRunIdle(t *testing.T) {
+	bot := getTestbot()
+	bot.SetIdleKick(time.Hour, 10, 2)
+	now := time.Now()
+
+	/* 3 users and the bot, plus 6 more is 9 of 10 places taken */
+	for uid := 70; uid < 76; uid++ {
+		bot.userTable[uid] = fmt.Sprintf("Peon%d#Azeroth", uid)
+		bot.active(uid, now.Add(-time.Duration(uid)*time.Minute))
+	}
+	bot.userFlags = map[int][]string{75: {"Moderator"}}
+	bot.active(_TEST_USERID_155, now.Add(-5*time.Hour))
+	bot.active(_TEST_USERID_61, now)
+
+	/* Only the longest idle user is kicked, not the priveleged user or moderator */
+	client := getEchoClient()
+	bot.runIdle(client, now)
+
+	var kicked []int
+	for _, request := range client.requests {
+		if request.Command == _REQUEST_KICK {
+			kicked = append(kicked, request.Payload.(_payloadAction).UserId)
+		}
+	}
+	if len(kicked) != 1 || kicked[0] != 74 {
+		t.Errorf("Expected: 74 kicked, Actual: %v", kicked)
+	}
+
+	/* The kick has not landed yet, but counts */
+	client = getEchoClient()
+	bot.runIdle(client, now)
+	if len(client.requests) != 0 {
+		t.Errorf("Expected: nothing sent, Actual: %+v", client.requests)
+	}
+
+	/* Not kicked at all when there is room */
+	bot.handleUserExit(getAction(_EVENT_USEREXIT, _payload{UserId: 74}))
+	bot.handleUserExit(getAction(_EVENT_USEREXIT, _payload{UserId: 73}))
+	client = getEchoClient()
+	bot.runIdle(client, now)
+	if len(client.requests) != 0 {
+		t.Errorf("Expected: nothing sent, Actual: %+v", client.requests)
+	}
+}
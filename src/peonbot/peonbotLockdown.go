@@ -0,0 +1,136 @@
+package peonbot
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	Lockdown closes the channel, e.g. for clan wars or private practice.
+	While it is on, anyone who joins and is not allowed is kicked, after
+	being whispered why if there is a message for it. Allowed are
+	priveleged users, moderators, users on the allow list in config (e.g.
+	the clan roster), and users added with `.allow`. Whether lockdown is on,
+	and users added with `.allow`, are saved to the data dir.
+*/
+
+const _ACTION_LOCKDOWN = ".LOCKDOWN"
+const _ACTION_ALLOW = ".ALLOW"
+const _ACTION_DISALLOW = ".DISALLOW"
+const _LOCKDOWN_ON = "ON"
+const _LOCKDOWN_OFF = "OFF"
+
+const _FILE_LOCKDOWN = "lockdown.yaml"
+
+type _lockdown struct {
+	On      bool     `yaml:"on"`
+	Allowed []string `yaml:"allowed"` /* added with `.allow` */
+
+	config  map[string]interface{} /* allowed in config */
+	message string                 /* whispered before kicking, if set */
+}
+
+/* Users always allowed in during lockdown, and what to whisper everyone else */
+func (bot *_bot) SetLockdown(allow []string, message string) {
+	bot.lockdown.config = make(map[string]interface{})
+	for _, user := range allow {
+		bot.lockdown.config[strings.ToUpper(user)] = nil
+	}
+	bot.lockdown.message = message
+}
+
+func (bot *_bot) loadLockdown() error {
+	return bot.loadData(_FILE_LOCKDOWN, &bot.lockdown)
+}
+
+func (bot *_bot) saveLockdown() {
+	if err := bot.saveData(_FILE_LOCKDOWN, &bot.lockdown); err != nil {
+		bot.Printf("Could not save lockdown: %v\n", err)
+	}
+}
+
+/* Index of a user added with `.allow`, or -1 */
+func (l *_lockdown) added(user string) int {
+	for i, allowed := range l.Allowed {
+		if strings.EqualFold(allowed, user) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (bot *_bot) allowed(uid int) bool {
+	user := bot.userTable[uid]
+	if _, ok := bot.lockdown.config[strings.ToUpper(user)]; ok {
+		return true
+	}
+
+	return bot.isPriveleged(uid) || bot.isModerator(uid) || bot.lockdown.added(user) != -1
+}
+
+/* Kick the user if the channel is locked down, and they are not allowed in */
+func (bot *_bot) enforceLockdown(client WebsocketClient, uid int) {
+	if !bot.lockdown.On || bot.allowed(uid) {
+		return
+	}
+
+	bot.Printf("[Bot log message] Locked down. Kicking %s\n", bot.userTable[uid])
+
+	if len(bot.lockdown.message) > 0 {
+		if err := _handleActionWhisper(client, bot, uid, bot.lockdown.message); err != nil {
+			bot.Printf("Could not whisper %s: %v\n", bot.userTable[uid], err)
+		}
+	}
+
+	if err := _handleActionKick(client, bot, uid); err != nil {
+		bot.Printf("Could not kick %s: %v\n", bot.userTable[uid], err)
+	}
+}
+
+/* `.lockdown on` also kicks everyone in the channel who is not allowed */
+func handleActionLockdown(client WebsocketClient, bot *_bot, state string, event _event) error {
+	switch strings.ToUpper(state) {
+	case _LOCKDOWN_ON:
+		bot.lockdown.On = true
+		bot.saveLockdown()
+		reply(client, bot, event, "Lockdown is on.")
+
+		for uid := range bot.userTable {
+			if uid != _PEONBOT_USERID {
+				bot.enforceLockdown(client, uid)
+			}
+		}
+	case _LOCKDOWN_OFF:
+		bot.lockdown.On = false
+		bot.saveLockdown()
+		reply(client, bot, event, "Lockdown is off.")
+	default:
+		return errActionIgnoreIncomplete(fmt.Sprintf("Expected on or off: %s", state))
+	}
+
+	return nil
+}
+
+func handleActionAllow(client WebsocketClient, bot *_bot, target string, event _event) {
+	if bot.lockdown.added(target) == -1 {
+		bot.lockdown.Allowed = append(bot.lockdown.Allowed, target)
+		bot.saveLockdown()
+	}
+
+	reply(client, bot, event, fmt.Sprintf("%s is allowed in during lockdown.", target))
+}
+
+func handleActionDisallow(client WebsocketClient, bot *_bot, target string, event _event) {
+	if i := bot.lockdown.added(target); i != -1 {
+		bot.lockdown.Allowed = append(bot.lockdown.Allowed[:i], bot.lockdown.Allowed[i+1:]...)
+		bot.saveLockdown()
+	}
+
+	if _, ok := bot.lockdown.config[strings.ToUpper(target)]; ok {
+		reply(client, bot, event, fmt.Sprintf("%s is on the allow list in config.", target))
+		return
+	}
+
+	reply(client, bot, event, fmt.Sprintf("%s is no longer allowed in during lockdown.", target))
+}
@@ -0,0 +1,110 @@
+package peonbot
+
+import (
+	"strings"
+	"testing"
+)
+
+func getLockdownTestbot(t *testing.T) *_bot {
+	bot := getTestbot()
+	bot.SetLockdown([]string{_TEST_USERNAME_TESTUSER61_GATEWAY}, "Clan war practice")
+	if err := bot.SetDataDir(t.TempDir()); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	return bot
+}
+
+/* Returns the requests the bot sends when the user joins */
+func join(bot *_bot, uid int, name string, flags ...string) string {
+	bot.replay = &_replayClient{}
+	defer func() { bot.replay = nil }()
+
+	bot.handleUserUpdate(getAction(_EVENT_USERUPDATE, _payload{UserId: uid, ToonName: name, Flag: flags}))
+
+	var sent []string
+	for _, request := range bot.replay.sent {
+		sent = append(sent, string(request))
+	}
+
+	return strings.Join(sent, "\n")
+}
+
+func TestLockdown(t *testing.T) {
+	bot := getLockdownTestbot(t)
+
+	if sent := join(bot, 70, "Stranger#Azeroth"); len(sent) != 0 {
+		t.Errorf("Expected: nothing sent before lockdown, Actual: %s", sent)
+	}
+
+	/* Everyone in the channel who is not allowed is kicked */
+	client := getEchoClient()
+	if err := handleAction(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".lockdown on")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	kicked := make(map[int]bool)
+	for _, request := range client.requests {
+		if request.Command == _REQUEST_KICK {
+			kicked[request.Payload.(_payloadAction).UserId] = true
+		}
+	}
+	if len(kicked) != 2 || !kicked[70] || !kicked[_TEST_USERID_59] {
+		t.Errorf("Expected: 70 and %d kicked, Actual: %+v", _TEST_USERID_59, client.requests)
+	}
+
+	for _, test := range []struct {
+		uid    int
+		name   string
+		flags  []string
+		kicked bool
+	}{
+		{71, "Stranger#Azeroth", nil, true},
+		{72, strings.ToUpper(_TEST_USERNAME_TESTUSER61_GATEWAY), nil, false},
+		{73, _TEST_USERNAME_PRIVUSER155, nil, false},
+		{74, "Mod#Azeroth", []string{"Moderator"}, false},
+	} {
+		sent := join(bot, test.uid, test.name, test.flags...)
+		if kicked := strings.Contains(sent, _REQUEST_KICK); kicked != test.kicked {
+			t.Errorf("%s: Expected: kicked %v, Actual: %s", test.name, test.kicked, sent)
+		}
+		if test.kicked && !strings.Contains(sent, "Clan war practice") {
+			t.Errorf("%s: Expected: whispered why, Actual: %s", test.name, sent)
+		}
+	}
+
+	/* Allowed at runtime, and saved */
+	_ = handleAction(getEchoClient(), bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".allow Stranger#Azeroth"))
+	if sent := join(bot, 75, "stranger#azeroth"); len(sent) != 0 {
+		t.Errorf("Expected: allowed in, Actual: %s", sent)
+	}
+
+	loaded := getLockdownTestbot(t)
+	loaded.dataDir = bot.dataDir
+	if err := loaded.loadLockdown(); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if !loaded.lockdown.On || loaded.lockdown.added("Stranger#Azeroth") == -1 {
+		t.Errorf("Expected: lockdown on, and Stranger#Azeroth allowed, Actual: %+v", loaded.lockdown)
+	}
+
+	_ = handleAction(getEchoClient(), bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".disallow Stranger#Azeroth"))
+	if sent := join(bot, 76, "Stranger#Azeroth"); !strings.Contains(sent, _REQUEST_KICK) {
+		t.Errorf("Expected: kicked, Actual: %s", sent)
+	}
+
+	/* Config entries stay */
+	client = getEchoClient()
+	_ = handleAction(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".disallow "+_TEST_USERNAME_TESTUSER61_GATEWAY))
+	if !strings.Contains(client.request.Payload.(_payloadMessage).Message, "in config") {
+		t.Errorf("Expected: on the allow list in config, Actual: %+v", client.request)
+	}
+
+	_ = handleAction(getEchoClient(), bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".lockdown off"))
+	if sent := join(bot, 77, "Stranger#Azeroth"); len(sent) != 0 {
+		t.Errorf("Expected: nothing sent after lockdown, Actual: %s", sent)
+	}
+
+	if err := handleAction(getEchoClient(), bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".lockdown maybe")); err == nil {
+		t.Errorf("Expected: an error, Actual: nil")
+	}
+}
@@ -0,0 +1,103 @@
+package peonbot
+
+import (
+	"fmt"
+	"peonbot/plugin"
+	"strings"
+)
+
+/*
+	Out-of-process plugins. Channel messages, joins and leaves are streamed
+	to every plugin, and the actions plugins request are carried out from
+	the event loop. A plugin may only request the actions in its scopes,
+	and never against a priveleged user.
+*/
+
+type _plugin struct {
+	host   *plugin.Plugin
+	scopes map[string]interface{}
+}
+
+/* Start a plugin, and restart it whenever it exits */
+func (bot *_bot) AddPlugin(name string, command string, args []string, scopes []string) {
+	host := plugin.New(name, command, args...)
+	host.Printf = bot.Printf
+
+	p := &_plugin{host: host, scopes: make(map[string]interface{})}
+	for _, scope := range scopes {
+		p.scopes[strings.ToLower(scope)] = nil
+	}
+
+	bot.plugins = append(bot.plugins, p)
+	bot.Printf("Starting plugin '%s': %s %s\n", name, command, strings.Join(args, " "))
+
+	go host.Run(bot.chplg)
+}
+
+/* Stop every plugin. They are not restarted. */
+func (bot *_bot) StopPlugins() {
+	for _, p := range bot.plugins {
+		p.host.Stop()
+	}
+}
+
+func (bot *_bot) publishPluginEvent(event plugin.Event) {
+	for _, p := range bot.plugins {
+		if err := p.host.Send(event); err != nil {
+			bot.Vprintf("%v\n", err)
+		}
+	}
+}
+
+func (bot *_bot) lookupPlugin(name string) *_plugin {
+	for _, p := range bot.plugins {
+		if strings.Compare(p.host.Name(), name) == 0 {
+			return p
+		}
+	}
+
+	return nil
+}
+
+/* Carry out an action a plugin requested. Only call from the event loop. */
+func (bot *_bot) handlePluginRequest(client WebsocketClient, request plugin.Request) error {
+	p := bot.lookupPlugin(request.Plugin)
+	if p == nil {
+		return fmt.Errorf("Request from unknown plugin '%s'.", request.Plugin)
+	}
+
+	action := strings.ToLower(request.Action)
+	if _, ok := p.scopes[action]; !ok {
+		return fmt.Errorf("Plugin '%s' is not allowed to %s.", request.Plugin, action)
+	}
+
+	switch action {
+	case plugin.ACTION_SAY:
+		if len(request.Message) == 0 {
+			return fmt.Errorf("Plugin '%s' sent an empty message.", request.Plugin)
+		}
+
+		return handleActionSay(client, bot, request.Message)
+	case plugin.ACTION_WHISPER:
+		if len(request.Message) == 0 {
+			return fmt.Errorf("Plugin '%s' sent an empty message.", request.Plugin)
+		}
+
+		return handleActionWhisper(client, bot, request.User, request.Message)
+	case plugin.ACTION_KICK, plugin.ACTION_BAN:
+		if _, ok := bot.pusers[strings.ToUpper(request.User)]; ok {
+			return fmt.Errorf("Plugin '%s' may not %s priveleged user %s.",
+				request.Plugin, action, request.User)
+		}
+
+		bot.Printf("[Bot log message] Plugin '%s' requested %s of %s\n",
+			request.Plugin, action, request.User)
+		if action == plugin.ACTION_KICK {
+			return handleActionKick(client, bot, request.User)
+		}
+		return handleActionBan(client, bot, request.User)
+	}
+
+	return fmt.Errorf("Plugin '%s' requested unknown action '%s'.",
+		request.Plugin, request.Action)
+}
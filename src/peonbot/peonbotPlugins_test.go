@@ -0,0 +1,63 @@
+package peonbot
+
+import (
+	"peonbot/plugin"
+	"testing"
+)
+
+func addTestPlugin(bot *_bot, name string, scopes ...string) {
+	p := &_plugin{host: plugin.New(name, "true"), scopes: make(map[string]interface{})}
+	for _, scope := range scopes {
+		p.scopes[scope] = nil
+	}
+
+	bot.plugins = append(bot.plugins, p)
+}
+
+func TestPluginRequestInScope(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	addTestPlugin(testbot, "trivia", plugin.ACTION_SAY, plugin.ACTION_KICK)
+
+	for _, request := range []plugin.Request{
+		{Plugin: "trivia", Action: "SAY", Message: "Question 1"},
+		{Plugin: "trivia", Action: plugin.ACTION_KICK, User: _TEST_USERNAME_TESTUSER59},
+	} {
+		if err := testbot.handlePluginRequest(client, request); err != nil {
+			t.Fatalf("Expected nil, but got an error: %v", err)
+		}
+	}
+
+	if len(client.requests) != 2 ||
+		client.requests[0].Command != _REQUEST_MSG ||
+		client.requests[1].Command != _REQUEST_KICK {
+
+		t.Errorf("Unexpected requests: %+v", client.requests)
+	}
+}
+
+func TestPluginRequestRejected(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	addTestPlugin(testbot, "trivia", plugin.ACTION_SAY, plugin.ACTION_BAN)
+
+	for _, request := range []plugin.Request{
+		/* Out of scope */
+		{Plugin: "trivia", Action: plugin.ACTION_WHISPER, User: _TEST_USERNAME_TESTUSER59,
+			Message: "hi"},
+		/* Priveleged user */
+		{Plugin: "trivia", Action: plugin.ACTION_BAN, User: _TEST_USERNAME_PRIVUSER155},
+		/* Unknown plugin */
+		{Plugin: "other", Action: plugin.ACTION_SAY, Message: "hi"},
+		/* Empty message */
+		{Plugin: "trivia", Action: plugin.ACTION_SAY},
+	} {
+		if err := testbot.handlePluginRequest(client, request); err == nil {
+			t.Errorf("Expected an error for %+v, but got nil.", request)
+		}
+	}
+
+	if len(client.requests) != 0 {
+		t.Errorf("Rejected requests should not be sent: %+v", client.requests)
+	}
+}
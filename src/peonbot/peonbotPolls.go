@@ -0,0 +1,293 @@
+package peonbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Polls, e.g. `.poll "Next clan war map?" "Turtle Rock" "Echo Isles"`.
+	Everyone in the channel can answer with `.vote 2` until the poll
+	closes, once each, though voting again changes their vote. Results are
+	said every so often while it is open, and once more when it closes.
+
+	Only one poll is open at a time. Every poll, with its votes and results,
+	is saved to the data dir, so officers can look back at past polls. Votes
+	are saved once a minute, and when the poll closes.
+*/
+
+const _ACTION_POLL = ".POLL"
+const _ACTION_VOTE = ".VOTE"
+const _POLL_CLOSE = "CLOSE"
+const _POLL_RESULTS = "RESULTS"
+const _OPTION_FOR = "FOR"
+
+const _FILE_POLLS = "polls.yaml"
+
+const _POLL_DURATION = 5 * time.Minute
+const _POLL_INTERIM = time.Minute /* how often results are said while open */
+const _POLL_MAX_OPTIONS = 9
+
+type _poll struct {
+	Question string         `yaml:"question"`
+	Options  []string       `yaml:"options"`
+	By       string         `yaml:"by"`
+	Opened   time.Time      `yaml:"opened"`
+	Closes   time.Time      `yaml:"closes"`
+	Closed   bool           `yaml:"closed"`
+	Votes    map[string]int `yaml:"votes"`             /* option, from 1, by voter */
+	Results  []int          `yaml:"results,omitempty"` /* votes per option, once closed */
+
+	interim time.Time /* when results are next said, and votes saved */
+	unsaved bool      /* votes changed since the last save */
+}
+
+type _pollsFile struct {
+	Polls []*_poll `yaml:"polls"`
+}
+
+func (bot *_bot) loadPolls() error {
+	var saved _pollsFile
+
+	if err := bot.loadData(_FILE_POLLS, &saved); err != nil {
+		return err
+	}
+
+	for _, poll := range saved.Polls {
+		if poll.Votes == nil {
+			poll.Votes = make(map[string]int)
+		}
+	}
+
+	bot.polls = saved.Polls
+	return nil
+}
+
+func (bot *_bot) savePolls() {
+	if err := bot.saveData(_FILE_POLLS, &_pollsFile{Polls: bot.polls}); err != nil {
+		bot.Printf("Could not save polls: %v\n", err)
+	}
+}
+
+/* The open poll, or nil */
+func (bot *_bot) openPoll() *_poll {
+	if len(bot.polls) == 0 || bot.polls[len(bot.polls)-1].Closed {
+		return nil
+	}
+
+	return bot.polls[len(bot.polls)-1]
+}
+
+/* Votes per option */
+func (p *_poll) count() []int {
+	counts := make([]int, len(p.Options))
+	for _, option := range p.Votes {
+		if option >= 1 && option <= len(counts) {
+			counts[option-1]++
+		}
+	}
+
+	return counts
+}
+
+/* e.g. `Next clan war map? 1. Turtle Rock: 3, 2. Echo Isles: 1 (4 votes)` */
+func (p *_poll) results(counts []int) string {
+	var options []string
+	total := 0
+	for i, option := range p.Options {
+		options = append(options, fmt.Sprintf("%d. %s: %d", i+1, option, counts[i]))
+		total += counts[i]
+	}
+
+	return fmt.Sprintf("%s %s (%d votes)", p.Question, strings.Join(options, ", "), total)
+}
+
+/* The options with the most votes, or none if nobody voted */
+func (p *_poll) winners(counts []int) []string {
+	var winners []string
+	most := 1
+	for i, count := range counts {
+		switch {
+		case count > most:
+			most = count
+			winners = []string{p.Options[i]}
+		case count == most:
+			winners = append(winners, p.Options[i])
+		}
+	}
+
+	return winners
+}
+
+/*
+	`.poll "question" "option" "option"... [for=5m]` opens a poll.
+	`.poll results` says the results so far, and `.poll close` closes it
+	early.
+*/
+func handleActionPoll(client WebsocketClient, bot *_bot, event _event, now time.Time) error {
+	args, err := splitArgs(event.Payload.Message)
+	if err != nil {
+		reply(client, bot, event, err.Error())
+		return err
+	}
+
+	/* Drop the action itself */
+	args = args[1:]
+
+	if len(args) == 1 {
+		switch strings.ToUpper(args[0]) {
+		case _POLL_CLOSE:
+			if bot.openPoll() == nil {
+				reply(client, bot, event, "No poll is open.")
+				return nil
+			}
+			bot.closePoll(client, now)
+			return nil
+		case _POLL_RESULTS:
+			poll := bot.openPoll()
+			if poll == nil {
+				reply(client, bot, event, "No poll is open.")
+				return nil
+			}
+			reply(client, bot, event, poll.results(poll.count()))
+			return nil
+		}
+	}
+
+	/* Only `for=` is an option, so answers may have `=` in them */
+	duration := _POLL_DURATION
+	if len(args) > 0 && strings.HasPrefix(strings.ToUpper(args[len(args)-1]), _OPTION_FOR+"=") {
+		value := args[len(args)-1][len(_OPTION_FOR)+1:]
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration < time.Minute {
+			err := fmt.Errorf("Expected a duration of at least 1m: %s", value)
+			reply(client, bot, event, err.Error())
+			return err
+		}
+		args = args[:len(args)-1]
+	}
+
+	if len(args) < 3 || len(args) > _POLL_MAX_OPTIONS+1 {
+		err := fmt.Errorf("Expected a question, and 2 to %d options.", _POLL_MAX_OPTIONS)
+		reply(client, bot, event, err.Error())
+		return err
+	}
+
+	if poll := bot.openPoll(); poll != nil {
+		err := fmt.Errorf("A poll is already open: %s", poll.Question)
+		reply(client, bot, event, err.Error())
+		return err
+	}
+
+	poll := &_poll{
+		Question: args[0],
+		Options:  args[1:],
+		By:       bot.userTable[event.Payload.UserId],
+		Opened:   now,
+		Closes:   now.Add(duration),
+		Votes:    make(map[string]int),
+		interim:  now.Add(_POLL_INTERIM),
+	}
+	bot.polls = append(bot.polls, poll)
+	bot.savePolls()
+
+	var options []string
+	for i, option := range poll.Options {
+		options = append(options, fmt.Sprintf("%d. %s", i+1, option))
+	}
+
+	bot.Printf("[Bot log message] %s opened a poll: %s\n", poll.By, poll.Question)
+	if err := handleActionSay(client, bot, fmt.Sprintf("Poll: %s %s. Type .vote <number> in the next %v.",
+		poll.Question, strings.Join(options, ", "), duration)); err != nil {
+		bot.Printf("Could not announce poll: %v\n", err)
+	}
+
+	return nil
+}
+
+/* `.vote 2` in the open poll. Messages from the console are ignored. */
+func (bot *_bot) handlePollVote(client WebsocketClient, event _event) {
+	voter := bot.userTable[event.Payload.UserId]
+	if event.Payload.UserId == _PEONBOT_USERID || len(voter) == 0 {
+		return
+	}
+
+	parts := strings.Fields(event.Payload.Message)
+	if len(parts) == 0 || strings.ToUpper(parts[0]) != _ACTION_VOTE {
+		return
+	}
+
+	poll := bot.openPoll()
+	if poll == nil {
+		reply(client, bot, event, "No poll is open.")
+		return
+	}
+
+	option := 0
+	if len(parts) == 2 {
+		option, _ = strconv.Atoi(parts[1])
+	}
+	if option < 1 || option > len(poll.Options) {
+		reply(client, bot, event, fmt.Sprintf("Usage: .vote <1 to %d>", len(poll.Options)))
+		return
+	}
+
+	/* Saved along with the next interim results, rather than on every vote */
+	poll.Votes[strings.ToUpper(voter)] = option
+	poll.unsaved = true
+}
+
+func (bot *_bot) closePoll(client WebsocketClient, now time.Time) {
+	poll := bot.openPoll()
+	counts := poll.count()
+
+	poll.Closed = true
+	poll.Closes = now
+	poll.Results = counts
+	poll.unsaved = false
+	bot.savePolls()
+
+	result := "Poll closed: " + poll.results(counts)
+	switch winners := poll.winners(counts); len(winners) {
+	case 0:
+		result += ". Nobody voted."
+	case 1:
+		result += fmt.Sprintf(". %s wins.", winners[0])
+	default:
+		result += fmt.Sprintf(". Tied: %s.", strings.Join(winners, ", "))
+	}
+
+	bot.Printf("[Bot log message] %s\n", result)
+	if err := handleActionSay(client, bot, result); err != nil {
+		bot.Printf("Could not announce poll: %v\n", err)
+	}
+}
+
+/* Say interim results, and close the open poll once it is due. Only call from the event loop. */
+func (bot *_bot) runPolls(client WebsocketClient, now time.Time) {
+	poll := bot.openPoll()
+	if poll == nil {
+		return
+	}
+
+	if !now.Before(poll.Closes) {
+		bot.closePoll(client, now)
+		return
+	}
+
+	if now.Before(poll.interim) {
+		return
+	}
+	poll.interim = now.Add(_POLL_INTERIM)
+
+	if poll.unsaved {
+		poll.unsaved = false
+		bot.savePolls()
+	}
+
+	if err := handleActionSay(client, bot, "Poll so far: "+poll.results(poll.count())); err != nil {
+		bot.Printf("Could not announce poll: %v\n", err)
+	}
+}
@@ -0,0 +1,143 @@
+package peonbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const _TEST_POLL = `.poll "Next clan war map?" "Turtle Rock" "Twisted Meadows" "Echo Isles" for=10m`
+
+func getPollTestbot(t *testing.T) *_bot {
+	bot := getTestbot()
+	if err := bot.SetDataDir(t.TempDir()); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	return bot
+}
+
+/* The polls saved in the bot's data dir */
+func savedPolls(t *testing.T, bot *_bot) []*_poll {
+	saved := getTestbot()
+	if err := saved.SetDataDir(bot.dataDir); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	return saved.polls
+}
+
+func lastSaid(client *echoClient) string {
+	if len(client.requests) == 0 {
+		return ""
+	}
+
+	return client.request.Payload.(_payloadMessage).Message
+}
+
+func TestPoll(t *testing.T) {
+	bot := getPollTestbot(t)
+	now := time.Now()
+
+	client := getEchoClient()
+	if err := handleActionPoll(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, _TEST_POLL), now); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	expected := "Poll: Next clan war map? 1. Turtle Rock, 2. Twisted Meadows, 3. Echo Isles. " +
+		"Type .vote <number> in the next 10m0s."
+	if lastSaid(client) != expected {
+		t.Errorf("Expected: %s, Actual: %s", expected, lastSaid(client))
+	}
+
+	/* One vote per user, the last one counts */
+	for _, vote := range []struct {
+		uid     int
+		message string
+	}{
+		{_TEST_USERID_59, ".vote 1"},
+		{_TEST_USERID_61, ".vote 2"},
+		{_TEST_USERID_155, ".vote 3"},
+		{_TEST_USERID_155, ".vote 1"},
+		{_PEONBOT_USERID, ".vote 2"},
+	} {
+		bot.handlePollVote(getEchoClient(), getUserMessage(vote.uid, _MSG_CHAN, vote.message))
+	}
+
+	client = getEchoClient()
+	bot.handlePollVote(client, getUserMessage(_TEST_USERID_61, _MSG_CHAN, ".vote 4"))
+	if lastSaid(client) != "Usage: .vote <1 to 3>" {
+		t.Errorf("Expected: usage, Actual: %s", lastSaid(client))
+	}
+
+	/* Interim results once a minute */
+	client = getEchoClient()
+	bot.runPolls(client, now.Add(30*time.Second))
+	if len(client.requests) != 0 {
+		t.Errorf("Expected: nothing said yet, Actual: %+v", client.requests)
+	}
+	if saved := savedPolls(t, bot); len(saved) != 1 || len(saved[0].Votes) != 0 {
+		t.Errorf("Expected: votes not saved yet, Actual: %+v", saved)
+	}
+	bot.runPolls(client, now.Add(time.Minute))
+	expected = "Poll so far: Next clan war map? 1. Turtle Rock: 2, 2. Twisted Meadows: 1, 3. Echo Isles: 0 (3 votes)"
+	if lastSaid(client) != expected {
+		t.Errorf("Expected: %s, Actual: %s", expected, lastSaid(client))
+	}
+
+	/* Votes are saved with them */
+	if saved := savedPolls(t, bot); len(saved) != 1 || len(saved[0].Votes) != 3 {
+		t.Errorf("Expected: 3 votes saved, Actual: %+v", saved)
+	}
+
+	client = getEchoClient()
+	if err := handleActionPoll(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, _TEST_POLL), now); err == nil {
+		t.Errorf("Expected: only one poll open at a time, Actual: %s", lastSaid(client))
+	}
+
+	client = getEchoClient()
+	bot.runPolls(client, now.Add(10*time.Minute))
+	if !strings.HasPrefix(lastSaid(client), "Poll closed: ") || !strings.HasSuffix(lastSaid(client), ". Turtle Rock wins.") {
+		t.Errorf("Expected: Turtle Rock wins, Actual: %s", lastSaid(client))
+	}
+
+	client = getEchoClient()
+	bot.handlePollVote(client, getUserMessage(_TEST_USERID_61, _MSG_CHAN, ".vote 2"))
+	if lastSaid(client) != "No poll is open." {
+		t.Errorf("Expected: no poll open, Actual: %s", lastSaid(client))
+	}
+
+	/* Results are saved */
+	saved := savedPolls(t, bot)
+	if len(saved) != 1 || !saved[0].Closed || saved[0].Votes["TESTUSER59"] != 1 ||
+		len(saved[0].Results) != 3 || saved[0].Results[0] != 2 {
+
+		t.Errorf("Unexpected saved polls: %+v", saved)
+	}
+}
+
+func TestPollClose(t *testing.T) {
+	bot := getPollTestbot(t)
+	now := time.Now()
+
+	for _, test := range []struct {
+		message  string
+		expected string
+	}{
+		{`.poll "Best race?" Orc`, "Expected a question, and 2 to 9 options."},
+		{`.poll close`, "No poll is open."},
+		{`.poll "Best race?" Orc Elf`, ""},
+		{`.poll results`, "Best race? 1. Orc: 0, 2. Elf: 0 (0 votes)"},
+		{`.poll close`, "Poll closed: Best race? 1. Orc: 0, 2. Elf: 0 (0 votes). Nobody voted."},
+		{`.poll Mode? 1v1 2v2=fun`, "Poll: Mode? 1. 1v1, 2. 2v2=fun. Type .vote <number> in the next 5m0s."},
+		{`.poll close`, ""},
+		{`.poll Mode? 1v1 2v2 FOR=2m`, "Poll: Mode? 1. 1v1, 2. 2v2. Type .vote <number> in the next 2m0s."},
+		{`.poll close`, ""},
+		{`.poll Mode? 1v1 2v2 for=30s`, "Expected a duration of at least 1m: 30s"},
+	} {
+		client := getEchoClient()
+		_ = handleActionPoll(client, bot, getUserMessage(_TEST_USERID_155, _MSG_CHAN, test.message), now)
+		if len(test.expected) > 0 && lastSaid(client) != test.expected {
+			t.Errorf("%s: Expected: %s, Actual: %s", test.message, test.expected, lastSaid(client))
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package peonbot
+
+import (
+	"encoding/json"
+	"peonbot/endpoint"
+)
+
+/*
+	Requests and events can differ in shape between versions of the chat
+	bot API. A codec turns the bot's requests into what a version expects,
+	and a version's events into the bot's. Only v1 is known to work, and it
+	speaks the messages from the v3 documentation, which `_request` and
+	`_event` follow. The v2 and v3 endpoints have never completed a
+	handshake, so nothing is known of how their messages differ, and they
+	are spoken to with v1's until they get codecs of their own.
+*/
+type _codec interface {
+	encode(request _request) interface{}
+	decode(raw []byte) (_event, error)
+}
+
+var _CODECS = map[string]_codec{
+	endpoint.V1: _codecV1{},
+}
+
+type _codecV1 struct{}
+
+func (_codecV1) encode(request _request) interface{} {
+	return request
+}
+
+func (_codecV1) decode(raw []byte) (_event, error) {
+	var event _event
+	err := json.Unmarshal(raw, &event)
+
+	return event, err
+}
+
+/* The codec of the version connected to, v1's before connecting or if it has none */
+func (bot *_bot) codec() _codec {
+	if codec, ok := _CODECS[bot.version]; ok {
+		return codec
+	}
+
+	return _CODECS[endpoint.V1]
+}
+
+/* Encodes requests for the version connected to */
+type _codecClient struct {
+	client WebsocketClient
+	codec  _codec
+}
+
+func (c *_codecClient) WriteJSON(v interface{}) error {
+	if request, ok := v.(_request); ok {
+		v = c.codec.encode(request)
+	}
+
+	return c.client.WriteJSON(v)
+}
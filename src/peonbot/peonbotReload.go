@@ -0,0 +1,127 @@
+package peonbot
+
+import (
+	"peonbot/ban"
+	"strings"
+)
+
+/*
+	Ask the event loop to reload the config. Requests are coalesced, so
+	this never blocks, and may be called from any goroutine (e.g. a file
+	watcher, or a signal handler).
+*/
+func (bot *_bot) RequestReload() {
+	select {
+	case bot.chrld <- struct{}{}:
+	default:
+	}
+}
+
+/* Returns entries in `next` missing from `current`, and vice versa */
+func diffList(current map[string]interface{}, next map[string]interface{}) ([]string, []string) {
+	var added, removed []string
+
+	for user := range next {
+		if _, ok := current[user]; !ok {
+			added = append(added, user)
+		}
+	}
+
+	for user := range current {
+		if _, ok := next[user]; !ok {
+			removed = append(removed, user)
+		}
+	}
+
+	return added, removed
+}
+
+func toUserSet(users ...string) map[string]interface{} {
+	set := make(map[string]interface{})
+	for _, user := range users {
+		set[strings.ToUpper(user)] = nil
+	}
+
+	return set
+}
+
+func toBanSet(entries ...string) map[string]interface{} {
+	set := make(map[string]interface{})
+	for _, entry := range entries {
+		set[ban.Key(entry)] = nil
+	}
+
+	return set
+}
+
+/*
+	Apply changes to the ban list and priveleged user list that were
+	re-read from config. Only entries added to or removed from config since
+	it was last read change, so entries added at runtime with `.addban` or
+	`.addpriv`, and bans from federated feeds, are kept. Both lists change
+	at once, so this must only be called from the event loop.
+
+	Users already in the channel who are now banned are banned, and users
+	removed from the ban list in config are unbanned. Removing a pattern
+	unbans no one.
+*/
+func (bot *_bot) Reload(client WebsocketClient, blist []string, pusers []string) {
+	nextBlist := toBanSet(blist...)
+	nextPusers := toUserSet(pusers...)
+
+	banned, unbanned := diffList(bot.configBlist, nextBlist)
+	privAdded, privRemoved := diffList(bot.configPusers, nextPusers)
+
+	bot.configBlist = nextBlist
+	bot.configPusers = nextPusers
+
+	for _, user := range banned {
+		/* Banned by a feed already. Keep who banned them; feeds cannot lift it while it is in config. */
+		if _, federated := bot.blist[user].(_banSource); federated {
+			continue
+		}
+
+		bot.blist[user] = nil
+		bot.Printf("[Bot log message] Added to banlist: %s\n", user)
+	}
+	for _, user := range unbanned {
+		/* Banned by a feed as well, so leave it to the feed */
+		if _, federated := bot.blist[user].(_banSource); federated {
+			continue
+		}
+
+		delete(bot.blist, user)
+		bot.Printf("[Bot log message] Removed from banlist: %s\n", user)
+		if !ban.IsPattern(user) {
+			_ = handleActionUnban(client, bot, user)
+		}
+	}
+	bot.banlistChanged()
+	for _, user := range privAdded {
+		bot.pusers[user] = nil
+		bot.Printf("[Bot log message] Privelege added: %s\n", user)
+	}
+	for _, user := range privRemoved {
+		if strings.Compare(user, strings.ToUpper(_PEONBOT_USERNAME)) == 0 {
+			continue
+		}
+
+		delete(bot.pusers, user)
+		bot.Printf("[Bot log message] Privelege removed: %s\n", user)
+	}
+
+	bot.enforceBanlist(client)
+}
+
+/* Ban every user currently in the channel who is on the ban list */
+func (bot *_bot) enforceBanlist(client WebsocketClient) {
+	for uid, user := range bot.userTable {
+		if uid == _PEONBOT_USERID {
+			continue
+		}
+
+		if bot.banned(user) {
+			_ = _handleActionBan(client, bot, uid)
+		}
+	}
+}
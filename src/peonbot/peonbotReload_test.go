@@ -0,0 +1,110 @@
+package peonbot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRequestReloadCoalesces(t *testing.T) {
+	testbot := getTestbot()
+	testbot.chrld = make(chan struct{}, 1)
+
+	testbot.RequestReload()
+	testbot.RequestReload()
+
+	if len(testbot.Chrld()) != 1 {
+		t.Errorf("Expected: %d pending reload, Actual: %d", 1, len(testbot.Chrld()))
+	}
+}
+
+func TestActionReload(t *testing.T) {
+	testbot := getTestbot()
+	testbot.chrld = make(chan struct{}, 1)
+
+	action := getAction(_EVENT_MSG, _payload{
+		UserId:  _TEST_USERID_155,
+		Message: ".reload",
+	})
+
+	if err := handleAction(nil, testbot, action); err != nil {
+		t.Errorf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(testbot.Chrld()) != 1 {
+		t.Errorf("A reload should have been requested, but was not.")
+	}
+}
+
+func TestReloadBansUsersInChannel(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	testbot.Reload(client, []string{_TEST_USERNAME_TESTUSER61_GATEWAY}, nil)
+
+	if _, ok := testbot.blist[strings.ToUpper(_TEST_USERNAME_TESTUSER61_GATEWAY)]; !ok {
+		t.Errorf("User should have been added to banlist, but was not.")
+	}
+
+	var banned, unbanned bool
+	for _, request := range client.requests {
+		switch request.Command {
+		case _REQUEST_BAN:
+			banned = request.Payload.(_payloadAction).UserId == _TEST_USERID_61
+		case _REQUEST_UNBAN:
+			unbanned = strings.Compare(
+				request.Payload.(_payloadAction).ToonName,
+				strings.ToUpper(_TEST_USERNAME_BANNED_BANNEDUSER159)) == 0
+		}
+	}
+
+	if !banned {
+		t.Errorf("User in channel should have been banned, but was not: %+v", client.requests)
+	}
+
+	if !unbanned {
+		t.Errorf("User removed from banlist should have been unbanned, but was not: %+v", client.requests)
+	}
+}
+
+func TestReloadReplacesPrivelegedUsers(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	testbot.Reload(client, nil, []string{_TEST_USERNAME_TESTUSER61_GATEWAY})
+
+	if _, ok := testbot.pusers[strings.ToUpper(_TEST_USERNAME_TESTUSER61_GATEWAY)]; !ok {
+		t.Errorf("Bot priveleges should have been granted, but were not.")
+	}
+
+	if _, ok := testbot.pusers[strings.ToUpper(_TEST_USERNAME_PRIVUSER155)]; ok {
+		t.Errorf("Bot priveleges should have been removed, but were not.")
+	}
+
+	if _, ok := testbot.pusers[strings.ToUpper(_PEONBOT_USERNAME)]; !ok {
+		t.Errorf("Bot should always keep priveleges over itself.")
+	}
+
+	if len(client.requests) != 1 || client.requests[0].Command != _REQUEST_UNBAN {
+		t.Errorf("Only the removed ban should have sent a request: %+v", client.requests)
+	}
+}
+
+func TestReloadKeepsRuntimeEntries(t *testing.T) {
+	testbot := getTestbot()
+	handleActionAddBan(getEchoClient(), testbot, "Troll#Azeroth")
+	handleActionAddpriv(testbot, _TEST_USERNAME_TESTUSER61_GATEWAY)
+
+	/* Config did not change, e.g. only the greetings were edited */
+	client := getEchoClient()
+	testbot.Reload(client, []string{_TEST_USERNAME_BANNED_BANNEDUSER159}, []string{_TEST_USERNAME_PRIVUSER155})
+
+	if _, ok := testbot.blist["TROLL#AZEROTH"]; !ok {
+		t.Errorf("Ban added at runtime should have been kept: %v", testbot.blist)
+	}
+	if _, ok := testbot.pusers[strings.ToUpper(_TEST_USERNAME_TESTUSER61_GATEWAY)]; !ok {
+		t.Errorf("Privelege added at runtime should have been kept: %v", testbot.pusers)
+	}
+	if len(client.requests) != 0 {
+		t.Errorf("Nothing should have been sent: %+v", client.requests)
+	}
+}
@@ -22,6 +22,13 @@ const _REQUEST_UNBAN = "Botapichat.UnbanUserRequest"
 const _REQUEST_KICK = "Botapichat.KickUserRequest"
 const _REQUEST_DESIGN = "Botapichat.SendSetModeratorRequest"
 
+const _RESPONSE_MSG = "Botapichat.SendMessageResponse"
+const _RESPONSE_WHISPER = "Botapichat.SendWhisperResponse"
+const _RESPONSE_BAN = "Botapichat.BanUserResponse"
+const _RESPONSE_UNBAN = "Botapichat.UnbanUserResponse"
+const _RESPONSE_KICK = "Botapichat.KickUserResponse"
+const _RESPONSE_DESIGN = "Botapichat.SendSetModeratorResponse"
+
 /* XXX: Emote is not currently implemented */
 // const _REQUEST_EMOTE = "Botapichat.SendEmoteRequest"
 
@@ -0,0 +1,321 @@
+package peonbot
+
+import (
+	"fmt"
+	"peonbot/cron"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Announcements sent to the channel on an interval, on a cron schedule, or
+	once at a given time (`.remind`). Announcements from config are not
+	persisted. Ones added from chat are saved to the data dir. Nothing is
+	sent while the bot is alone in the channel.
+*/
+
+const _ACTION_REMIND = ".REMIND"
+const _ACTION_SCHEDULE = ".SCHEDULE"
+const _SCHEDULE_ADD = "ADD"
+const _SCHEDULE_RM = "RM"
+const _SCHEDULE_LIST = "LIST"
+const _SCHEDULE_CRON = "CRON"
+
+const _FILE_SCHEDULES = "schedules.yaml"
+
+/* Recurring announcements more frequent than this would flood the channel */
+const _SCHEDULE_MIN_INTERVAL = time.Minute
+
+type _schedule struct {
+	Id      int        `yaml:"id"`
+	Message string     `yaml:"message"`
+	Every   string     `yaml:"every,omitempty"`
+	Cron    string     `yaml:"cron,omitempty"`
+	At      *time.Time `yaml:"at,omitempty"` /* one-off reminders */
+
+	config bool /* defined in config, so never persisted */
+	every  time.Duration
+	cron   *cron.Schedule
+	next   time.Time
+}
+
+type _schedules struct {
+	Schedules []*_schedule `yaml:"schedules"`
+}
+
+func (s *_schedule) String() string {
+	switch {
+	case s.At != nil:
+		return fmt.Sprintf("#%d at %s: %s", s.Id, s.next.Format(time.Stamp), s.Message)
+	case s.cron != nil:
+		return fmt.Sprintf("#%d cron '%s' (next %s): %s", s.Id, s.Cron,
+			s.next.Format(time.Stamp), s.Message)
+	default:
+		return fmt.Sprintf("#%d every %s (next %s): %s", s.Id, s.Every,
+			s.next.Format(time.Stamp), s.Message)
+	}
+}
+
+/* Parse the schedule's timing, and work out when it is next due */
+func (s *_schedule) init(now time.Time) error {
+	switch {
+	case s.At != nil:
+		s.next = *s.At
+	case len(s.Cron) > 0:
+		schedule, err := cron.Parse(s.Cron)
+		if err != nil {
+			return err
+		}
+		s.cron = schedule
+		s.next = schedule.Next(now)
+		if s.next.IsZero() {
+			return fmt.Errorf("Cron expression '%s' never matches.", s.Cron)
+		}
+	default:
+		every, err := time.ParseDuration(s.Every)
+		if err != nil {
+			return err
+		}
+		if every < _SCHEDULE_MIN_INTERVAL {
+			return fmt.Errorf("Interval must be at least %v.", _SCHEDULE_MIN_INTERVAL)
+		}
+		s.every = every
+		s.next = now.Add(every)
+	}
+
+	return nil
+}
+
+/* Returns false once a schedule will never be due again */
+func (s *_schedule) advance(now time.Time) bool {
+	switch {
+	case s.cron != nil:
+		s.next = s.cron.Next(now)
+		return !s.next.IsZero()
+	case s.every > 0:
+		for !s.next.After(now) {
+			s.next = s.next.Add(s.every)
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (bot *_bot) addSchedule(schedule *_schedule, now time.Time) error {
+	if err := schedule.init(now); err != nil {
+		return err
+	}
+
+	bot.scheduleId++
+	schedule.Id = bot.scheduleId
+	bot.schedules = append(bot.schedules, schedule)
+
+	if !schedule.config {
+		return bot.saveSchedules()
+	}
+
+	return nil
+}
+
+/* Add a recurring announcement from config. Only one of every or cron is set. */
+func (bot *_bot) AddAnnouncement(every string, cronExpr string, message string) error {
+	return bot.addSchedule(&_schedule{
+		Message: message,
+		Every:   every,
+		Cron:    cronExpr,
+		config:  true,
+	}, time.Now())
+}
+
+func (bot *_bot) rmSchedule(id int) (*_schedule, error) {
+	for i, schedule := range bot.schedules {
+		if schedule.Id != id {
+			continue
+		}
+
+		if schedule.config {
+			return nil, fmt.Errorf("Schedule #%d is defined in config.", id)
+		}
+
+		bot.schedules = append(bot.schedules[:i], bot.schedules[i+1:]...)
+		return schedule, bot.saveSchedules()
+	}
+
+	return nil, fmt.Errorf("No schedule #%d.", id)
+}
+
+func (bot *_bot) loadSchedules() error {
+	var saved _schedules
+
+	if err := bot.loadData(_FILE_SCHEDULES, &saved); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, schedule := range saved.Schedules {
+		if err := schedule.init(now); err != nil {
+			bot.Printf("Dropping saved schedule %+v: %v\n", schedule, err)
+			continue
+		}
+
+		if schedule.Id > bot.scheduleId {
+			bot.scheduleId = schedule.Id
+		}
+		bot.schedules = append(bot.schedules, schedule)
+	}
+
+	return nil
+}
+
+func (bot *_bot) saveSchedules() error {
+	var saved _schedules
+
+	for _, schedule := range bot.schedules {
+		if !schedule.config {
+			saved.Schedules = append(saved.Schedules, schedule)
+		}
+	}
+
+	return bot.saveData(_FILE_SCHEDULES, &saved)
+}
+
+func (bot *_bot) channelEmpty() bool {
+	for uid := range bot.userTable {
+		if uid != _PEONBOT_USERID {
+			return false
+		}
+	}
+
+	return true
+}
+
+/* Send whatever is due. Only call from the event loop. */
+func (bot *_bot) runSchedules(client WebsocketClient, now time.Time) {
+	var remaining []*_schedule
+	changed := false
+
+	for _, schedule := range bot.schedules {
+		if schedule.next.After(now) {
+			remaining = append(remaining, schedule)
+			continue
+		}
+
+		if bot.channelEmpty() {
+			bot.Vprintf("Channel is empty. Skipping schedule %s\n", schedule)
+		} else if err := handleActionSay(client, bot, schedule.Message); err != nil {
+			bot.Printf("Could not send schedule %s: %v\n", schedule, err)
+		}
+
+		if schedule.advance(now) {
+			remaining = append(remaining, schedule)
+		} else {
+			changed = changed || !schedule.config
+		}
+	}
+
+	bot.schedules = remaining
+
+	if changed {
+		if err := bot.saveSchedules(); err != nil {
+			bot.Printf("Could not save schedules: %v\n", err)
+		}
+	}
+}
+
+/* `.remind 30m message` */
+func handleActionRemind(client WebsocketClient, bot *_bot, parts []string, event _event) error {
+	if len(parts) < 3 {
+		return errActionIgnoreIncomplete(
+			fmt.Sprintf("A reminder needs a delay and a message: %v", parts))
+	}
+
+	delay, err := time.ParseDuration(parts[1])
+	if err != nil || delay <= 0 {
+		reply(client, bot, event, fmt.Sprintf("Invalid delay '%s'. E.g. 30m, or 1h30m.", parts[1]))
+		return errActionIgnoreIncomplete(fmt.Sprintf("Invalid delay: %s", parts[1]))
+	}
+
+	now := time.Now()
+	at := now.Add(delay)
+	schedule := &_schedule{Message: strings.Join(parts[2:], " "), At: &at}
+	if err := bot.addSchedule(schedule, now); err != nil {
+		return err
+	}
+
+	reply(client, bot, event, fmt.Sprintf("Reminder %s", schedule))
+	return nil
+}
+
+/*
+	`.schedule add 30m message`
+	`.schedule add cron 0 20 * * 5 message`
+	`.schedule rm 3`
+	`.schedule list`
+*/
+func handleActionSchedule(client WebsocketClient, bot *_bot, parts []string, event _event) error {
+	now := time.Now()
+
+	switch strings.ToUpper(parts[1]) {
+	case _SCHEDULE_ADD:
+		var schedule *_schedule
+
+		if len(parts) > 3 && strings.Compare(strings.ToUpper(parts[2]), _SCHEDULE_CRON) == 0 {
+			if len(parts) < 9 {
+				return errActionIgnoreIncomplete(
+					fmt.Sprintf("A cron schedule needs 5 fields and a message: %v", parts))
+			}
+			schedule = &_schedule{
+				Cron:    strings.Join(parts[3:8], " "),
+				Message: strings.Join(parts[8:], " "),
+			}
+		} else {
+			if len(parts) < 4 {
+				return errActionIgnoreIncomplete(
+					fmt.Sprintf("A schedule needs an interval and a message: %v", parts))
+			}
+			schedule = &_schedule{
+				Every:   parts[2],
+				Message: strings.Join(parts[3:], " "),
+			}
+		}
+
+		if err := bot.addSchedule(schedule, now); err != nil {
+			reply(client, bot, event, fmt.Sprintf("Invalid schedule: %v", err))
+			return err
+		}
+
+		reply(client, bot, event, fmt.Sprintf("Scheduled %s", schedule))
+	case _SCHEDULE_RM:
+		if len(parts) < 3 {
+			return errActionIgnoreIncomplete(
+				fmt.Sprintf("Which schedule to remove: %v", parts))
+		}
+
+		id, err := strconv.Atoi(strings.TrimPrefix(parts[2], "#"))
+		if err != nil {
+			return errActionIgnoreIncomplete(fmt.Sprintf("Invalid schedule: %s", parts[2]))
+		}
+
+		schedule, err := bot.rmSchedule(id)
+		if err != nil {
+			reply(client, bot, event, err.Error())
+			return err
+		}
+
+		reply(client, bot, event, fmt.Sprintf("Removed %s", schedule))
+	case _SCHEDULE_LIST:
+		if len(bot.schedules) == 0 {
+			reply(client, bot, event, "Nothing is scheduled.")
+		}
+
+		for _, schedule := range bot.schedules {
+			reply(client, bot, event, schedule.String())
+		}
+	default:
+		return fmt.Errorf("Unrecognized schedule action: %s", parts[1])
+	}
+
+	return nil
+}
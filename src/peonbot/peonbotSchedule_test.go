@@ -0,0 +1,181 @@
+package peonbot
+
+import (
+	"testing"
+	"time"
+)
+
+func getScheduleAction(message string) _event {
+	return getAction(_EVENT_MSG, _payload{
+		UserId:  _TEST_USERID_155,
+		Message: message,
+		Type:    _MSG_WHISPER,
+	})
+}
+
+func TestRunSchedulesRecurring(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	now := time.Now()
+
+	if err := testbot.AddAnnouncement("10m", "", "Read the rules"); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	testbot.runSchedules(client, now)
+	if len(client.requests) != 0 {
+		t.Errorf("Nothing should be sent before it is due: %+v", client.requests)
+	}
+
+	due := now.Add(time.Duration(11) * time.Minute)
+	testbot.runSchedules(client, due)
+
+	if len(client.requests) != 1 || client.request.Command != _REQUEST_MSG {
+		t.Fatalf("Announcement should have been sent once: %+v", client.requests)
+	}
+
+	if message := client.request.Payload.(_payloadMessage).Message; message != "Read the rules" {
+		t.Errorf("Expected: %s, Actual: %s", "Read the rules", message)
+	}
+
+	if len(testbot.schedules) != 1 || !testbot.schedules[0].next.After(due) {
+		t.Errorf("Recurring announcement should have been rescheduled: %+v", testbot.schedules)
+	}
+}
+
+func TestRunSchedulesEmptyChannel(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	testbot.userTable = map[int]string{_PEONBOT_USERID: _PEONBOT_USERNAME}
+
+	if err := testbot.AddAnnouncement("", "* * * * *", "Anyone here?"); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	testbot.runSchedules(client, time.Now().Add(time.Duration(2)*time.Minute))
+
+	if len(client.requests) != 0 {
+		t.Errorf("Nothing should be sent to an empty channel: %+v", client.requests)
+	}
+}
+
+func TestActionRemind(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+	testbot.dataDir = t.TempDir()
+
+	if err := handleAction(client, testbot, getScheduleAction(".remind 5m Clan war soon")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	/* The reminder is persisted, and outlives the bot */
+	restarted := getTestbot()
+	if err := restarted.SetDataDir(testbot.dataDir); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(restarted.schedules) != 1 {
+		t.Fatalf("Expected: %d saved schedule, Actual: %d", 1, len(restarted.schedules))
+	}
+
+	client = getEchoClient()
+	restarted.runSchedules(client, time.Now().Add(time.Duration(6)*time.Minute))
+
+	if len(client.requests) != 1 || client.request.Payload.(_payloadMessage).Message != "Clan war soon" {
+		t.Errorf("Reminder should have been sent: %+v", client.requests)
+	}
+
+	if len(restarted.schedules) != 0 {
+		t.Errorf("Reminder should only be sent once: %+v", restarted.schedules)
+	}
+}
+
+func TestActionRemindInvalidDelay(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	if err := handleAction(client, testbot, getScheduleAction(".remind soon hi")); err == nil {
+		t.Errorf("Expected an error, but got nil.")
+	}
+
+	if client.request.Command != _REQUEST_WHISPER {
+		t.Errorf("User should have been told the delay was invalid: %+v", client.request)
+	}
+}
+
+func TestActionScheduleAddListRm(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	if err := handleAction(client, testbot,
+		getScheduleAction(".schedule add cron 0 20 * * 5 Tournament tonight")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if err := handleAction(client, testbot,
+		getScheduleAction(".schedule add 1h Visit our site")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(testbot.schedules) != 2 || testbot.schedules[0].Cron != "0 20 * * 5" ||
+		testbot.schedules[0].Message != "Tournament tonight" {
+		t.Fatalf("Unexpected schedules: %+v", testbot.schedules)
+	}
+
+	client = getEchoClient()
+	if err := handleAction(client, testbot, getScheduleAction(".schedule list")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(client.requests) != 2 {
+		t.Errorf("Each schedule should have been listed: %+v", client.requests)
+	}
+
+	if err := handleAction(client, testbot, getScheduleAction(".schedule rm #1")); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if len(testbot.schedules) != 1 || testbot.schedules[0].Id != 2 {
+		t.Errorf("Schedule #1 should have been removed: %+v", testbot.schedules)
+	}
+}
+
+func TestActionScheduleRejects(t *testing.T) {
+	testbot := getTestbot()
+
+	if err := testbot.AddAnnouncement("1h", "", "From config"); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	for _, message := range []string{
+		".schedule add 10s Too often",
+		".schedule add cron 0 20 * * Missing fields",
+		".schedule add cron 0 0 31 2 * February 31st never comes",
+		".schedule rm 1",
+		".schedule rm 59",
+		".schedule pause 1",
+	} {
+		if err := handleAction(getEchoClient(), testbot, getScheduleAction(message)); err == nil {
+			t.Errorf("Expected an error for '%s', but got nil.", message)
+		}
+	}
+
+	/* Or it would be due at once, on every start */
+	if err := testbot.AddAnnouncement("", "0 0 31 2 *", "Never"); err == nil {
+		t.Errorf("Expected an error for a cron expression that never matches, but got nil.")
+	}
+	if len(testbot.schedules) != 1 {
+		t.Errorf("Expected: only the schedule from config, Actual: %+v", testbot.schedules)
+	}
+}
+
+func TestReplyStdin(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	reply(client, testbot, testbot.getStdinAction(".schedule list"), "Nothing is scheduled.")
+
+	if len(client.requests) != 0 {
+		t.Errorf("Replies to stdin should not be sent to battle.net: %+v", client.requests)
+	}
+}
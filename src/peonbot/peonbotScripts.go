@@ -0,0 +1,63 @@
+package peonbot
+
+import (
+	"peonbot/script"
+	"strings"
+)
+
+/*
+	The view of the bot that scripts get: they may talk in the channel and
+	look at who is in it, and nothing else.
+*/
+type _scriptBot struct {
+	bot    *_bot
+	client WebsocketClient
+}
+
+func (s *_scriptBot) Say(message string) error {
+	return handleActionSay(s.client, s.bot, message)
+}
+
+func (s *_scriptBot) Whisper(user string, message string) error {
+	return handleActionWhisper(s.client, s.bot, user, message)
+}
+
+func (s *_scriptBot) Users() []string {
+	var users []string
+	for uid, user := range s.bot.userTable {
+		if uid != _PEONBOT_USERID {
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
+
+func (s *_scriptBot) IsPriveleged(user string) bool {
+	_, ok := s.bot.pusers[strings.ToUpper(user)]
+	return ok
+}
+
+/* Run `*.star` scripts from `dir`. They are loaded on the next tick. */
+func (bot *_bot) SetScriptsDir(dir string) {
+	bot.scripts = script.New(dir)
+	bot.scripts.Printf = bot.Printf
+}
+
+/* Load new and changed scripts. Only call from the event loop. */
+func (bot *_bot) loadScripts(client WebsocketClient) {
+	if bot.scripts == nil {
+		return
+	}
+
+	bot.scripts.Load(&_scriptBot{bot: bot, client: client})
+}
+
+/* Only call from the event loop */
+func (bot *_bot) runScripts(client WebsocketClient, hook string, args ...string) {
+	if bot.scripts == nil {
+		return
+	}
+
+	bot.scripts.Call(&_scriptBot{bot: bot, client: client}, hook, args...)
+}
@@ -0,0 +1,37 @@
+package peonbot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptsOnJoin(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "greet.star"), []byte(`
+def on_join(user):
+    if not is_priveleged(user):
+        whisper(user, "Welcome, %d others are here" % (len(users()) - 1))
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := getEchoClient()
+	testbot := getTestbot()
+	testbot.SetScriptsDir(dir)
+	testbot.loadScripts(client)
+
+	testbot.userTable[_TEST_USERID_60] = _TEST_USERNAME_TESTUSER60
+	testbot.runScripts(client, "on_join", _TEST_USERNAME_TESTUSER60)
+	testbot.runScripts(client, "on_join", _TEST_USERNAME_PRIVUSER155)
+
+	if len(client.requests) != 1 || client.request.Command != _REQUEST_WHISPER {
+		t.Fatalf("Unexpected requests: %+v", client.requests)
+	}
+
+	expected := "Welcome, 3 others are here"
+	if actual := client.request.Payload.(_payloadMessage).Message; actual != expected {
+		t.Errorf("Expected: %s, Actual: %s", expected, actual)
+	}
+}
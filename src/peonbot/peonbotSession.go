@@ -0,0 +1,134 @@
+package peonbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"peonbot/session"
+)
+
+/*
+	Record every frame read from the server, and every request written to
+	it, to a session file. The api key is redacted. Set before `Start`.
+*/
+func (bot *_bot) Record(path string) error {
+	recorder, err := session.NewRecorder(path, bot.token)
+	if err != nil {
+		return err
+	}
+
+	bot.recorder = recorder
+	return nil
+}
+
+func (bot *_bot) StopRecording() {
+	if bot.recorder != nil {
+		_ = bot.recorder.Close()
+	}
+}
+
+/*
+	Where requests are written: the websocket, unless replaying, encoded
+	for the API version connected to.
+*/
+func (bot *_bot) client() WebsocketClient {
+	var client WebsocketClient = bot.Conn
+	if bot.replay != nil {
+		client = bot.replay
+	}
+	client = &_codecClient{client: client, codec: bot.codec()}
+
+	if bot.recorder != nil {
+		return &_recordingClient{client: client, bot: bot}
+	}
+
+	return client
+}
+
+type _recordingClient struct {
+	client WebsocketClient
+	bot    *_bot
+}
+
+func (c *_recordingClient) WriteJSON(v interface{}) error {
+	if err := c.client.WriteJSON(v); err != nil {
+		return err
+	}
+
+	if err := c.bot.recorder.Outbound(v); err != nil {
+		c.bot.Vprintf("Could not record request: %v\n", err)
+	}
+
+	return nil
+}
+
+func (bot *_bot) recordInbound(data []byte) {
+	if bot.recorder == nil {
+		return
+	}
+
+	if err := bot.recorder.Inbound(data); err != nil {
+		bot.Vprintf("Could not record frame: %v\n", err)
+	}
+}
+
+/* Collects requests made while replaying, instead of sending them */
+type _replayClient struct {
+	sent []json.RawMessage
+}
+
+func (c *_replayClient) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.sent = append(c.sent, data)
+	return nil
+}
+
+/*
+	Feed a recorded session through `HandleEvent`, as if the frames came
+	from the server, in order and without waiting between them. Requests the
+	bot makes are compared with the ones recorded, instead of being sent.
+	Returns a description of every frame whose requests differ.
+
+	Nothing else feeds the bot while replaying (no stdin, timers, plugins,
+	etc.), so requests made by those while recording show up as differences.
+	Only call before the event loop is started, or from it.
+*/
+func (bot *_bot) Replay(frames []session.Frame) []string {
+	var mismatches []string
+
+	bot.replay = &_replayClient{}
+	defer func() { bot.replay = nil }()
+
+	for i, step := range session.Steps(frames) {
+		bot.replay.sent = nil
+
+		if err := bot.HandleEvent(step.In.Data); err != nil {
+			bot.Vprintf("Got error from handling event: %v\n", err)
+		}
+
+		if mismatch := compareRequests(step.Out, bot.replay.sent); len(mismatch) > 0 {
+			mismatches = append(mismatches,
+				fmt.Sprintf("Frame %d %s: %s", i+1, step.In.Data, mismatch))
+		}
+	}
+
+	return mismatches
+}
+
+func compareRequests(recorded []session.Frame, replayed []json.RawMessage) string {
+	for i := 0; i < len(recorded) || i < len(replayed); i++ {
+		switch {
+		case i >= len(replayed):
+			return fmt.Sprintf("recorded %s, but it was not sent", recorded[i].Data)
+		case i >= len(recorded):
+			return fmt.Sprintf("sent %s, but it was not recorded", replayed[i])
+		case !session.SameData(recorded[i].Data, replayed[i]):
+			return fmt.Sprintf("recorded %s, but sent %s", recorded[i].Data, replayed[i])
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,88 @@
+package peonbot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"peonbot/session"
+	"strings"
+	"testing"
+)
+
+/*
+	A join burst as the server sends it when the bot connects, including a
+	second update for a banned user who is already in the channel.
+*/
+func TestReplayJoinBurst(t *testing.T) {
+	frames, err := session.Read(filepath.Join("testdata", "join_burst.jsonl"))
+	if err != nil {
+		t.Fatalf("Could not read session: %v", err)
+	}
+
+	bot := getTestbot()
+	if mismatches := bot.Replay(frames); len(mismatches) != 0 {
+		t.Errorf("Expected: no differences, Actual: %s", strings.Join(mismatches, "\n"))
+	}
+
+	if bot.channel != "Clan Peon" {
+		t.Errorf("Expected: Clan Peon, Actual: %s", bot.channel)
+	}
+	if bot.replay != nil {
+		t.Errorf("Expected: requests sent to the websocket after replaying, Actual: still replaying")
+	}
+
+	/* A bot without the ban does not send the recorded bans */
+	bot = getTestbot()
+	bot.rmFromBanlist(_TEST_USERNAME_BANNED_BANNEDUSER159)
+	mismatches := bot.Replay(frames)
+	if len(mismatches) != 2 || !strings.Contains(mismatches[0], "Frame 3") ||
+		!strings.Contains(mismatches[0], "but it was not sent") {
+		t.Errorf("Expected: 2 missing bans, Actual: %s", strings.Join(mismatches, "\n"))
+	}
+}
+
+/* A recorded session replays the same, without the api key in it */
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	const token = "b5a5ff4e-8f0a-4a9b-8f7b-2c6ab4f7e1aa"
+
+	bot := getTestbot()
+	bot.token = token
+	if err := bot.Record(path); err != nil {
+		t.Fatalf("Could not record: %v", err)
+	}
+	/* Stands in for the server */
+	bot.replay = &_replayClient{}
+
+	_ = bot.authenticate(bot.client(), bot.Token())
+	for _, raw := range []string{
+		`{"command": "Botapichat.ConnectEventRequest", "request_id": 1, "payload": {"channel": "Clan Peon"}}`,
+		`{"command": "Botapichat.UserUpdateEventRequest", "request_id": 2, "payload": {"user_id": 159, "toon_name": "BannedUser159"}}`,
+		`{"command": "Botapichat.MessageEventRequest", "request_id": 4, "payload": {"user_id": 155, "type": "Whisper", "message": ".whisper TestUser61#Gateway hi"}}`,
+		`not json`,
+	} {
+		bot.recordInbound([]byte(raw))
+		_ = bot.HandleEvent([]byte(raw))
+	}
+	bot.StopRecording()
+
+	contents, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(contents), token) {
+		t.Errorf("Expected: api key redacted, Actual: %s", contents)
+	}
+	if len(bot.replay.sent) != 3 {
+		t.Errorf("Expected: 3 requests, Actual: %d", len(bot.replay.sent))
+	}
+
+	frames, err := session.Read(path)
+	if err != nil {
+		t.Fatalf("Could not read session: %v", err)
+	}
+	if len(frames) != 7 {
+		t.Errorf("Expected: 7 frames, Actual: %d", len(frames))
+	}
+
+	if mismatches := getTestbot().Replay(frames); len(mismatches) != 0 {
+		t.Errorf("Expected: no differences, Actual: %s", strings.Join(mismatches, "\n"))
+	}
+}
@@ -0,0 +1,120 @@
+package peonbot
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	A point in time view of a bot, for displaying outside of the event loop,
+	e.g. in the terminal UI.
+*/
+type Snapshot struct {
+	Name    string
+	State   string
+	Channel string
+	Members []Member
+	Queue   int /* outgoing messages and events not yet delivered */
+}
+
+type Member struct {
+	Name       string
+	Flags      []string /* e.g. Moderator, Speaker, as sent by the server */
+	Priveleged bool
+}
+
+/* How long to wait on a busy event loop before giving up on a snapshot */
+const _SNAPSHOT_TIMEOUT = time.Second
+
+/*
+	Returns a snapshot of the bot, taken by the event loop. A bot whose event
+	loop has stopped, or is not answering, is reported as disconnected.
+*/
+func (bot *_bot) Snapshot() Snapshot {
+	ch := make(chan Snapshot, 1)
+
+	select {
+	case bot.chsnp <- ch:
+	case <-bot.ctx.Done():
+		return Snapshot{Name: bot.Name(), State: STATE_DISCONNECTED}
+	case <-time.After(_SNAPSHOT_TIMEOUT):
+		return Snapshot{Name: bot.Name(), State: STATE_DISCONNECTED}
+	}
+
+	return <-ch
+}
+
+/* Only call from the event loop. */
+func (bot *_bot) snapshot() Snapshot {
+	snapshot := Snapshot{
+		Name:    bot.Name(),
+		State:   bot.state(),
+		Channel: bot.channel,
+		Queue:   bot.queued(),
+	}
+
+	for uid, user := range bot.userTable {
+		if uid == _PEONBOT_USERID {
+			continue
+		}
+
+		_, priveleged := bot.pusers[strings.ToUpper(user)]
+		snapshot.Members = append(snapshot.Members, Member{
+			Name:       user,
+			Flags:      bot.userFlags[uid],
+			Priveleged: priveleged,
+		})
+	}
+
+	sort.Slice(snapshot.Members, func(i, j int) bool {
+		return strings.ToUpper(snapshot.Members[i].Name) <
+			strings.ToUpper(snapshot.Members[j].Name)
+	})
+
+	return snapshot
+}
+
+/* Messages and events waiting to go out to IRC, plugins, and webhooks */
+func (bot *_bot) queued() int {
+	queued := 0
+
+	if bot.bridge != nil {
+		queued += bot.bridge.client.Queued()
+	}
+
+	for _, plugin := range bot.plugins {
+		queued += plugin.host.Queued()
+	}
+
+	if bot.sender != nil {
+		queued += bot.sender.Pending()
+	}
+
+	return queued
+}
+
+func (bot *_bot) setUserFlags(uid int, flags []string) {
+	if bot.userFlags == nil {
+		bot.userFlags = make(map[int][]string)
+	}
+
+	if len(flags) == 0 {
+		delete(bot.userFlags, uid)
+		return
+	}
+
+	bot.userFlags[uid] = flags
+}
+
+/* Whether the server flags the user as a moderator, or admin, of the channel */
+func (bot *_bot) isModerator(uid int) bool {
+	for _, flag := range bot.userFlags[uid] {
+		switch strings.ToUpper(flag) {
+		case "MODERATOR", "ADMIN":
+			return true
+		}
+	}
+
+	return false
+}
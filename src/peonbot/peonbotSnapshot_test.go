@@ -0,0 +1,69 @@
+package peonbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	bot := getTestbot()
+	bot.handleConnect(_event{Payload: _payload{Channel: "Clan Peon"}})
+
+	raw := []byte(`{"command": "Botapichat.UserUpdateEventRequest", "request_id": 1,
+		"payload": {"toon_name": "Mod#Azeroth", "user_id": 70, "flag": ["Moderator"]}}`)
+	if err := bot.HandleEvent(raw); err != nil {
+		t.Fatalf("Could not handle event: %v", err)
+	}
+
+	snapshot := bot.snapshot()
+	if snapshot.State != STATE_CONNECTED || snapshot.Channel != "Clan Peon" {
+		t.Errorf("Expected: connected to Clan Peon, Actual: %+v", snapshot)
+	}
+
+	expected := []Member{
+		{Name: "Mod#Azeroth", Flags: []string{"Moderator"}},
+		{Name: _TEST_USERNAME_PRIVUSER155, Priveleged: true},
+		{Name: _TEST_USERNAME_TESTUSER59},
+		{Name: _TEST_USERNAME_TESTUSER61_GATEWAY},
+	}
+	if !reflect.DeepEqual(snapshot.Members, expected) {
+		t.Errorf("Expected: %+v, Actual: %+v", expected, snapshot.Members)
+	}
+
+	/* Flags change, and are forgotten when the user leaves */
+	raw = []byte(`{"command": "Botapichat.UserUpdateEventRequest", "request_id": 2,
+		"payload": {"toon_name": "Mod#Azeroth", "user_id": 70}}`)
+	_ = bot.HandleEvent(raw)
+	if flags := bot.snapshot().Members[0].Flags; len(flags) != 0 {
+		t.Errorf("Expected: no flags, Actual: %v", flags)
+	}
+
+	bot.setUserFlags(70, []string{"Speaker"})
+	raw = []byte(`{"command": "Botapichat.UserLeaveEventRequest", "request_id": 3,
+		"payload": {"user_id": 70}}`)
+	_ = bot.HandleEvent(raw)
+	if _, ok := bot.userFlags[70]; ok {
+		t.Errorf("Expected: flags removed, Actual: %v", bot.userFlags[70])
+	}
+}
+
+func TestSnapshotStopped(t *testing.T) {
+	bot := getTestbot()
+	bot.name = "clan"
+	bot.chsnp = make(chan chan Snapshot)
+
+	/* Answered by the event loop */
+	go func() {
+		ch := <-bot.chsnp
+		ch <- bot.snapshot()
+	}()
+	if snapshot := bot.Snapshot(); snapshot.State != STATE_CONNECTING {
+		t.Errorf("Expected: %s, Actual: %s", STATE_CONNECTING, snapshot.State)
+	}
+
+	bot.Stop()
+	snapshot := bot.Snapshot()
+	if snapshot.State != STATE_DISCONNECTED || snapshot.Name != "clan" {
+		t.Errorf("Expected: clan disconnected, Actual: %+v", snapshot)
+	}
+}
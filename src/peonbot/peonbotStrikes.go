@@ -0,0 +1,233 @@
+package peonbot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	Warnings, for something softer than a kick. `.warn name#Gateway reason`
+	whispers the user, and gives them a strike. Enough strikes and they are
+	kicked, more and they are banned for a while. Strikes expire some time
+	after they were given, and are saved to the data dir, along with timed
+	bans, so both survive a restart.
+*/
+
+const _ACTION_WARN = ".WARN"
+const _ACTION_STRIKES = ".STRIKES"
+const _ACTION_CLEARSTRIKES = ".CLEARSTRIKES"
+
+const _FILE_STRIKES = "strikes.yaml"
+
+/* When strikes are acted on. A threshold of 0 never is, a decay of 0 never expires. */
+type _strikePolicy struct {
+	kick   int
+	ban    int
+	banFor time.Duration
+	decay  time.Duration
+}
+
+type _strike struct {
+	By     string    `yaml:"by"`
+	Reason string    `yaml:"reason,omitempty"`
+	At     time.Time `yaml:"at"`
+}
+
+type _struck struct {
+	User    string     `yaml:"user"`
+	Strikes []_strike  `yaml:"strikes"`
+	Banned  *time.Time `yaml:"banned_until,omitempty"` /* timed ban, lifted when due */
+}
+
+type _strikesFile struct {
+	Users []*_struck `yaml:"users"`
+}
+
+/* Kick at `kick` strikes, and ban for `banFor` at `ban`. Strikes expire after `decay`. */
+func (bot *_bot) SetStrikes(kick int, ban int, banFor time.Duration, decay time.Duration) {
+	bot.escalate = _strikePolicy{kick: kick, ban: ban, banFor: banFor, decay: decay}
+}
+
+/* Returns nil if the user has no strikes, and is not banned */
+func (bot *_bot) struck(user string) *_struck {
+	for _, struck := range bot.strikes {
+		if strings.EqualFold(struck.User, user) {
+			return struck
+		}
+	}
+
+	return nil
+}
+
+/* Whether the user is serving a timed ban */
+func (bot *_bot) timedBan(user string, now time.Time) bool {
+	struck := bot.struck(user)
+	return struck != nil && struck.Banned != nil && now.Before(*struck.Banned)
+}
+
+func (bot *_bot) loadStrikes() error {
+	var saved _strikesFile
+
+	if err := bot.loadData(_FILE_STRIKES, &saved); err != nil {
+		return err
+	}
+
+	bot.strikes = saved.Users
+	return nil
+}
+
+func (bot *_bot) saveStrikes() {
+	if err := bot.saveData(_FILE_STRIKES, &_strikesFile{Users: bot.strikes}); err != nil {
+		bot.Printf("Could not save strikes: %v\n", err)
+	}
+}
+
+/* `.warn name#Gateway reason` */
+func handleActionWarn(client WebsocketClient, bot *_bot, target string, parts []string, event _event, now time.Time) {
+	struck := bot.struck(target)
+	if struck == nil {
+		struck = &_struck{User: target}
+		bot.strikes = append(bot.strikes, struck)
+	}
+
+	reason := strings.Join(parts, " ")
+	struck.Strikes = append(struck.Strikes, _strike{
+		By:     bot.userTable[event.Payload.UserId],
+		Reason: reason,
+		At:     now,
+	})
+	count := len(struck.Strikes)
+
+	bot.Printf("[Bot log message] %s warned %s (%d strikes): %s\n",
+		bot.userTable[event.Payload.UserId], target, count, reason)
+
+	uid := bot.lookupUid(target)
+	if uid != -1 {
+		warning := fmt.Sprintf("Warning: %s. You have %d strikes.", reason, count)
+		if len(reason) == 0 {
+			warning = fmt.Sprintf("Warning. You have %d strikes.", count)
+		}
+		if err := _handleActionWhisper(client, bot, uid, warning); err != nil {
+			bot.Printf("Could not warn %s: %v\n", target, err)
+		}
+	}
+
+	policy := bot.escalate
+	switch {
+	case policy.ban > 0 && count >= policy.ban:
+		until := now.Add(policy.banFor)
+		struck.Banned = &until
+		reply(client, bot, event, fmt.Sprintf("%s has %d strikes, and is banned until %s.",
+			target, count, until.Format(time.Stamp)))
+		if uid != -1 {
+			_ = _handleActionBan(client, bot, uid)
+		}
+	case policy.kick > 0 && count >= policy.kick:
+		reply(client, bot, event, fmt.Sprintf("%s has %d strikes, and is kicked.", target, count))
+		if uid != -1 {
+			_ = _handleActionKick(client, bot, uid)
+		}
+	default:
+		reply(client, bot, event, fmt.Sprintf("%s has %d strikes.", target, count))
+	}
+
+	bot.saveStrikes()
+}
+
+/* `.strikes name#Gateway` */
+func handleActionStrikes(client WebsocketClient, bot *_bot, target string, event _event) {
+	struck := bot.struck(target)
+	if struck == nil {
+		reply(client, bot, event, fmt.Sprintf("%s has no strikes.", target))
+		return
+	}
+
+	reply(client, bot, event, fmt.Sprintf("%s has %d strikes.", struck.User, len(struck.Strikes)))
+	for _, strike := range struck.Strikes {
+		reply(client, bot, event, fmt.Sprintf("%s by %s: %s",
+			strike.At.Format(time.Stamp), strike.By, strike.Reason))
+	}
+	if struck.Banned != nil {
+		reply(client, bot, event, fmt.Sprintf("Banned until %s.", struck.Banned.Format(time.Stamp)))
+	}
+}
+
+/* `.clearstrikes name#Gateway` also lifts a timed ban */
+func handleActionClearStrikes(client WebsocketClient, bot *_bot, target string, event _event) {
+	if bot.clearStrikes(client, target) {
+		reply(client, bot, event, fmt.Sprintf("Cleared strikes of %s.", target))
+	} else {
+		reply(client, bot, event, fmt.Sprintf("%s has no strikes.", target))
+	}
+}
+
+/* Forget a user's strikes, and lift their timed ban. False if they had neither. */
+func (bot *_bot) clearStrikes(client WebsocketClient, user string) bool {
+	for i, struck := range bot.strikes {
+		if !strings.EqualFold(struck.User, user) {
+			continue
+		}
+
+		/* A ban list entry outlasts the timed ban */
+		if struck.Banned != nil && !bot.banned(struck.User) {
+			_ = handleActionUnban(client, bot, struck.User)
+		}
+		bot.strikes = append(bot.strikes[:i], bot.strikes[i+1:]...)
+		bot.saveStrikes()
+
+		return true
+	}
+
+	return false
+}
+
+/* Lift a user's timed ban early, keeping their strikes */
+func (bot *_bot) liftTimedBan(user string) {
+	if struck := bot.struck(user); struck != nil && struck.Banned != nil {
+		struck.Banned = nil
+		bot.saveStrikes()
+	}
+}
+
+/* Expire strikes, and lift timed bans that are due. Only call from the event loop. */
+func (bot *_bot) runStrikes(client WebsocketClient, now time.Time) {
+	var remaining []*_struck
+	changed := false
+
+	for _, struck := range bot.strikes {
+		if bot.escalate.decay > 0 {
+			var current []_strike
+			for _, strike := range struck.Strikes {
+				if now.Before(strike.At.Add(bot.escalate.decay)) {
+					current = append(current, strike)
+				}
+			}
+			changed = changed || len(current) != len(struck.Strikes)
+			struck.Strikes = current
+		}
+
+		if struck.Banned != nil && !now.Before(*struck.Banned) {
+			if bot.banned(struck.User) {
+				bot.Printf("[Bot log message] Timed ban of %s is over, but they are on the ban list.\n", struck.User)
+			} else {
+				bot.Printf("[Bot log message] Timed ban of %s is over.\n", struck.User)
+				if err := handleActionUnban(client, bot, struck.User); err != nil {
+					bot.Printf("Could not unban %s: %v\n", struck.User, err)
+				}
+			}
+			struck.Banned = nil
+			changed = true
+		}
+
+		if len(struck.Strikes) > 0 || struck.Banned != nil {
+			remaining = append(remaining, struck)
+		}
+	}
+
+	bot.strikes = remaining
+
+	if changed {
+		bot.saveStrikes()
+	}
+}
@@ -0,0 +1,174 @@
+package peonbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func getStrikesTestbot(t *testing.T) *_bot {
+	bot := getTestbot()
+	bot.SetStrikes(3, 5, time.Hour, 24*time.Hour)
+	if err := bot.SetDataDir(t.TempDir()); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	return bot
+}
+
+func warn(client WebsocketClient, bot *_bot, message string) error {
+	return handleAction(client, bot, getUserMessage(_TEST_USERID_155, _MSG_WHISPER, message))
+}
+
+func TestWarn(t *testing.T) {
+	bot := getStrikesTestbot(t)
+
+	client := getEchoClient()
+	if err := warn(client, bot, ".warn "+_TEST_USERNAME_TESTUSER61_GATEWAY+" no spamming"); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if len(client.requests) != 2 || client.requests[0].Command != _REQUEST_WHISPER ||
+		client.requests[0].Payload.(_payloadMessage).UserId != "61" ||
+		client.requests[0].Payload.(_payloadMessage).Message != "Warning: no spamming. You have 1 strikes." {
+		t.Fatalf("Expected: warning whispered, Actual: %+v", client.requests)
+	}
+
+	struck := bot.struck(_TEST_USERNAME_TESTUSER61_GATEWAY)
+	if struck == nil || struck.Strikes[0].By != _TEST_USERNAME_PRIVUSER155 ||
+		struck.Strikes[0].Reason != "no spamming" {
+		t.Fatalf("Expected: a strike by %s, Actual: %+v", _TEST_USERNAME_PRIVUSER155, struck)
+	}
+
+	/* Third strike is a kick */
+	_ = warn(client, bot, ".warn "+_TEST_USERNAME_TESTUSER61_GATEWAY)
+	client = getEchoClient()
+	_ = warn(client, bot, ".warn "+_TEST_USERNAME_TESTUSER61_GATEWAY+" last chance")
+	if client.request.Command != _REQUEST_KICK {
+		t.Errorf("Expected: %s, Actual: %+v", _REQUEST_KICK, client.requests)
+	}
+
+	/* Fifth is a timed ban, kept until it is over */
+	_ = warn(client, bot, ".warn "+_TEST_USERNAME_TESTUSER61_GATEWAY)
+	client = getEchoClient()
+	_ = warn(client, bot, ".warn "+_TEST_USERNAME_TESTUSER61_GATEWAY)
+	if client.request.Command != _REQUEST_BAN {
+		t.Errorf("Expected: %s, Actual: %+v", _REQUEST_BAN, client.requests)
+	}
+	if !bot.timedBan(_TEST_USERNAME_TESTUSER61_GATEWAY, time.Now()) {
+		t.Errorf("Expected: %s banned, Actual: not banned", _TEST_USERNAME_TESTUSER61_GATEWAY)
+	}
+
+	/* Rejoining while banned */
+	delete(bot.userTable, _TEST_USERID_61)
+	bot.replay = &_replayClient{}
+	bot.handleUserUpdate(getAction(_EVENT_USERUPDATE, _payload{
+		UserId: 62, ToonName: strings.ToUpper(_TEST_USERNAME_TESTUSER61_GATEWAY)}))
+	if len(bot.replay.sent) != 1 || !strings.Contains(string(bot.replay.sent[0]), _REQUEST_BAN) {
+		t.Errorf("Expected: ban on join, Actual: %s", bot.replay.sent)
+	}
+	bot.replay = nil
+
+	/* Saved */
+	loaded := getStrikesTestbot(t)
+	loaded.dataDir = bot.dataDir
+	if err := loaded.loadStrikes(); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if struck := loaded.struck(_TEST_USERNAME_TESTUSER61_GATEWAY); struck == nil ||
+		len(struck.Strikes) != 5 || struck.Banned == nil {
+		t.Errorf("Expected: 5 strikes and a ban loaded, Actual: %+v", struck)
+	}
+}
+
+func TestStrikesDecay(t *testing.T) {
+	bot := getStrikesTestbot(t)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		handleActionWarn(getEchoClient(), bot, _TEST_USERNAME_TESTUSER61_GATEWAY, nil,
+			getUserMessage(_TEST_USERID_155, _MSG_WHISPER, ".warn"), now.Add(time.Duration(i)*time.Hour))
+	}
+
+	/* The ban, from the fifth strike, is over 5 hours in */
+	client := getEchoClient()
+	bot.runStrikes(client, now.Add(5*time.Hour))
+	if client.request.Command != _REQUEST_UNBAN ||
+		client.request.Payload.(_payloadAction).ToonName != _TEST_USERNAME_TESTUSER61_GATEWAY {
+		t.Errorf("Expected: %s, Actual: %+v", _REQUEST_UNBAN, client.requests)
+	}
+
+	/* The first two strikes expire */
+	bot.runStrikes(getEchoClient(), now.Add(25*time.Hour+time.Minute))
+	if struck := bot.struck(_TEST_USERNAME_TESTUSER61_GATEWAY); struck == nil || len(struck.Strikes) != 3 {
+		t.Errorf("Expected: 3 strikes, Actual: %+v", struck)
+	}
+
+	bot.runStrikes(getEchoClient(), now.Add(48*time.Hour))
+	if struck := bot.struck(_TEST_USERNAME_TESTUSER61_GATEWAY); struck != nil {
+		t.Errorf("Expected: no strikes, Actual: %+v", struck)
+	}
+}
+
+func TestStrikesBanlisted(t *testing.T) {
+	bot := getStrikesTestbot(t)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		handleActionWarn(getEchoClient(), bot, _TEST_USERNAME_TESTUSER61_GATEWAY, nil,
+			getUserMessage(_TEST_USERID_155, _MSG_WHISPER, ".warn"), now)
+	}
+	bot.addToBanlist(_TEST_USERNAME_TESTUSER61_GATEWAY)
+
+	/* The timed ban is over, but the ban list still bans them */
+	client := getEchoClient()
+	bot.runStrikes(client, now.Add(2*time.Hour))
+	if len(client.requests) != 0 {
+		t.Errorf("Expected: no unban, Actual: %+v", client.requests)
+	}
+
+	for i := 0; i < 2; i++ {
+		handleActionWarn(getEchoClient(), bot, _TEST_USERNAME_TESTUSER61_GATEWAY, nil,
+			getUserMessage(_TEST_USERID_155, _MSG_WHISPER, ".warn"), now)
+	}
+	if !bot.timedBan(_TEST_USERNAME_TESTUSER61_GATEWAY, now) {
+		t.Fatalf("Expected: %s banned, Actual: not banned", _TEST_USERNAME_TESTUSER61_GATEWAY)
+	}
+
+	client = getEchoClient()
+	_ = warn(client, bot, ".clearstrikes "+_TEST_USERNAME_TESTUSER61_GATEWAY)
+	for _, request := range client.requests {
+		if request.Command == _REQUEST_UNBAN {
+			t.Errorf("Expected: no unban, Actual: %+v", client.requests)
+		}
+	}
+}
+
+func TestStrikesAndClearStrikes(t *testing.T) {
+	bot := getStrikesTestbot(t)
+
+	client := getEchoClient()
+	_ = warn(client, bot, ".strikes "+_TEST_USERNAME_TESTUSER61_GATEWAY)
+	if message := client.request.Payload.(_payloadMessage).Message; message != _TEST_USERNAME_TESTUSER61_GATEWAY+" has no strikes." {
+		t.Errorf("Expected: no strikes, Actual: %s", message)
+	}
+
+	_ = warn(client, bot, ".warn "+_TEST_USERNAME_TESTUSER61_GATEWAY+" rude")
+	client = getEchoClient()
+	_ = warn(client, bot, ".strikes testuser61#gateway")
+	if len(client.requests) != 2 ||
+		!strings.HasSuffix(client.requests[1].Payload.(_payloadMessage).Message, "by "+_TEST_USERNAME_PRIVUSER155+": rude") {
+		t.Errorf("Expected: the strike listed, Actual: %+v", client.requests)
+	}
+
+	client = getEchoClient()
+	_ = warn(client, bot, ".clearstrikes "+_TEST_USERNAME_TESTUSER61_GATEWAY)
+	if bot.struck(_TEST_USERNAME_TESTUSER61_GATEWAY) != nil {
+		t.Errorf("Expected: strikes cleared, Actual: %+v", bot.strikes)
+	}
+
+	/* Not for everyone */
+	if err := handleAction(client, bot, getUserMessage(_TEST_USERID_59, _MSG_CHAN,
+		".warn "+_TEST_USERNAME_TESTUSER61_GATEWAY)); err == nil {
+		t.Errorf("Expected: an error, Actual: nil")
+	}
+}
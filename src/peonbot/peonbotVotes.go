@@ -0,0 +1,203 @@
+package peonbot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	Votes to kick or ban someone, for when no moderator is around. Anyone
+	in the channel can start one with `.votekick name#Gateway` or
+	`.voteban name#Gateway`, and everyone in the channel can answer `.yes`
+	or `.no` until it closes. Whoever started it votes yes.
+
+	When it closes, only votes from users still in the channel count, one
+	per user (the last one they cast). It passes with at least `quorum`
+	votes, of which at least `ratio` are yes. Priveleged users can not be
+	voted on, only one vote is open at a time, and each user can only
+	start a vote once per cooldown.
+*/
+
+const _ACTION_VOTEKICK = ".VOTEKICK"
+const _ACTION_VOTEBAN = ".VOTEBAN"
+const _ACTION_YES = ".YES"
+const _ACTION_NO = ".NO"
+
+type _voting struct {
+	duration time.Duration
+	cooldown time.Duration
+	quorum   int
+	ratio    float64
+
+	vote    *_vote               /* the open vote, if any */
+	started map[string]time.Time /* when each user last started a vote */
+}
+
+type _vote struct {
+	action  string /* _ACTION_VOTEKICK or _ACTION_VOTEBAN */
+	target  string
+	closes  time.Time
+	ballots map[string]bool /* yes or no, by voter */
+}
+
+/* Enable `.votekick` and `.voteban`. Off unless set. */
+func (bot *_bot) SetVoting(duration time.Duration, cooldown time.Duration, quorum int, ratio float64) {
+	bot.voting = &_voting{
+		duration: duration,
+		cooldown: cooldown,
+		quorum:   quorum,
+		ratio:    ratio,
+		started:  make(map[string]time.Time),
+	}
+}
+
+func (v *_vote) verb() string {
+	if v.action == _ACTION_VOTEBAN {
+		return "ban"
+	}
+
+	return "kick"
+}
+
+/* Start a vote, or vote in the open one. Messages from the console are ignored. */
+func (bot *_bot) handleVote(client WebsocketClient, event _event, now time.Time) {
+	voter := bot.userTable[event.Payload.UserId]
+	if bot.voting == nil || event.Payload.UserId == _PEONBOT_USERID || len(voter) == 0 {
+		return
+	}
+
+	parts := strings.Fields(event.Payload.Message)
+	if len(parts) == 0 {
+		return
+	}
+
+	switch action := strings.ToUpper(parts[0]); action {
+	case _ACTION_VOTEKICK, _ACTION_VOTEBAN:
+		if len(parts) < 2 {
+			reply(client, bot, event, fmt.Sprintf("Usage: %s name#Gateway",
+				strings.ToLower(action)))
+			return
+		}
+
+		target, acceptable := getTarget(client, bot, parts[1], event)
+		if !acceptable {
+			return
+		}
+
+		if err := bot.startVote(client, action, voter, target, now); err != nil {
+			reply(client, bot, event, err.Error())
+		}
+	case _ACTION_YES, _ACTION_NO:
+		if bot.voting.vote != nil {
+			bot.voting.vote.ballots[strings.ToUpper(voter)] = action == _ACTION_YES
+		}
+	}
+}
+
+func (bot *_bot) startVote(client WebsocketClient, action string, voter string, target string, now time.Time) error {
+	voting := bot.voting
+	if voting.vote != nil {
+		return fmt.Errorf("A vote to %s %s is already open.",
+			voting.vote.verb(), voting.vote.target)
+	}
+
+	uid := bot.lookupUid(target)
+	if uid == -1 {
+		return fmt.Errorf("%s is not in the channel.", target)
+	}
+	if bot.isPriveleged(uid) || bot.isModerator(uid) {
+		return fmt.Errorf("%s can not be voted on.", bot.userTable[uid])
+	}
+
+	if last, ok := voting.started[strings.ToUpper(voter)]; ok && now.Sub(last) < voting.cooldown {
+		return fmt.Errorf("You can start another vote in %v.",
+			(voting.cooldown - now.Sub(last)).Round(time.Second))
+	}
+	voting.started[strings.ToUpper(voter)] = now
+
+	voting.vote = &_vote{
+		action:  action,
+		target:  bot.userTable[uid],
+		closes:  now.Add(voting.duration),
+		ballots: map[string]bool{strings.ToUpper(voter): true},
+	}
+
+	bot.Printf("[Bot log message] %s started a vote to %s %s.\n",
+		voter, voting.vote.verb(), voting.vote.target)
+	if err := handleActionSay(client, bot, fmt.Sprintf(
+		"%s wants to %s %s. Type .yes or .no in the next %v.",
+		voter, voting.vote.verb(), voting.vote.target, voting.duration)); err != nil {
+		bot.Printf("Could not announce vote: %v\n", err)
+	}
+
+	return nil
+}
+
+/* Close the open vote once it is due. Only call from the event loop. */
+func (bot *_bot) runVotes(client WebsocketClient, now time.Time) {
+	if bot.voting == nil || bot.voting.vote == nil || now.Before(bot.voting.vote.closes) {
+		return
+	}
+
+	vote := bot.voting.vote
+	bot.voting.vote = nil
+
+	members := make(map[string]interface{})
+	for _, user := range bot.userTable {
+		members[strings.ToUpper(user)] = nil
+	}
+
+	yes, no := 0, 0
+	for voter, ballot := range vote.ballots {
+		if _, ok := members[voter]; !ok {
+			continue
+		}
+		if ballot {
+			yes++
+		} else {
+			no++
+		}
+	}
+
+	var result string
+	uid := bot.lookupUid(vote.target)
+	passed := false
+
+	switch {
+	case uid == -1:
+		result = fmt.Sprintf("%s left before the vote to %s them closed.",
+			vote.target, vote.verb())
+	case bot.isPriveleged(uid) || bot.isModerator(uid):
+		result = fmt.Sprintf("%s can not be voted on.", vote.target)
+	case yes+no < bot.voting.quorum:
+		result = fmt.Sprintf("Vote to %s %s failed with %d votes, %d are needed.",
+			vote.verb(), vote.target, yes+no, bot.voting.quorum)
+	case float64(yes) < bot.voting.ratio*float64(yes+no):
+		result = fmt.Sprintf("Vote to %s %s failed (%d yes, %d no).",
+			vote.verb(), vote.target, yes, no)
+	default:
+		result = fmt.Sprintf("Vote to %s %s passed (%d yes, %d no).",
+			vote.verb(), vote.target, yes, no)
+		passed = true
+	}
+
+	bot.Printf("[Bot log message] %s\n", result)
+	if err := handleActionSay(client, bot, result); err != nil {
+		bot.Printf("Could not announce vote: %v\n", err)
+	}
+
+	if !passed {
+		return
+	}
+
+	var err error
+	if vote.action == _ACTION_VOTEBAN {
+		err = _handleActionBan(client, bot, uid)
+	} else {
+		err = _handleActionKick(client, bot, uid)
+	}
+	if err != nil {
+		bot.Printf("Could not %s %s: %v\n", vote.verb(), vote.target, err)
+	}
+}
@@ -0,0 +1,173 @@
+package peonbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func getVotingTestbot() *_bot {
+	bot := getTestbot()
+	bot.userTable[_TEST_USERID_60] = _TEST_USERNAME_TESTUSER60
+	bot.SetVoting(time.Minute, 10*time.Minute, 3, 0.6)
+
+	return bot
+}
+
+func TestVoteKick(t *testing.T) {
+	bot := getVotingTestbot()
+	now := time.Now()
+
+	client := getEchoClient()
+	bot.handleVote(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN,
+		".votekick "+_TEST_USERNAME_TESTUSER61_GATEWAY), now)
+	if bot.voting.vote == nil || client.request.Command != _REQUEST_MSG {
+		t.Fatalf("Expected: vote announced, Actual: %+v", client.requests)
+	}
+
+	/* Changed their mind */
+	bot.handleVote(client, getUserMessage(_TEST_USERID_60, _MSG_CHAN, ".no"), now)
+	bot.handleVote(client, getUserMessage(_TEST_USERID_60, _MSG_WHISPER, ".YES"), now)
+	bot.handleVote(client, getUserMessage(_TEST_USERID_155, _MSG_CHAN, ".yes"), now)
+	bot.handleVote(client, getUserMessage(_TEST_USERID_61, _MSG_CHAN, ".no"), now)
+	/* Not in the channel */
+	bot.handleVote(client, getUserMessage(404, _MSG_CHAN, ".no"), now)
+
+	client = getEchoClient()
+	bot.runVotes(client, now.Add(30*time.Second))
+	if len(client.requests) != 0 || bot.voting.vote == nil {
+		t.Fatalf("Expected: vote still open, Actual: %+v", client.requests)
+	}
+
+	bot.runVotes(client, now.Add(time.Minute))
+	if len(client.requests) != 2 || client.requests[1].Command != _REQUEST_KICK ||
+		client.requests[1].Payload.(_payloadAction).UserId != _TEST_USERID_61 {
+		t.Fatalf("Expected: result and kick, Actual: %+v", client.requests)
+	}
+	if message := client.requests[0].Payload.(_payloadMessage).Message; !strings.Contains(message, "passed (3 yes, 1 no)") {
+		t.Errorf("Expected: passed (3 yes, 1 no), Actual: %s", message)
+	}
+	if bot.voting.vote != nil {
+		t.Errorf("Expected: vote closed, Actual: %+v", bot.voting.vote)
+	}
+}
+
+func TestVoteBanFailed(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		votes    map[int]string
+		leave    int
+		expected string
+	}{
+		{"quorum", map[int]string{_TEST_USERID_60: ".yes"}, 0, "failed with 2 votes, 3 are needed"},
+		{"ratio", map[int]string{_TEST_USERID_60: ".no", _TEST_USERID_61: ".no"}, 0, "failed (1 yes, 2 no)"},
+		/* Only votes from users still in the channel count */
+		{"left", map[int]string{_TEST_USERID_60: ".yes", _TEST_USERID_155: ".yes"}, _TEST_USERID_60, "failed with 2 votes"},
+		{"target left", map[int]string{_TEST_USERID_60: ".yes", _TEST_USERID_155: ".yes"}, _TEST_USERID_61, "left before the vote"},
+	} {
+		bot := getVotingTestbot()
+		now := time.Now()
+
+		client := getEchoClient()
+		bot.handleVote(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN,
+			".voteban "+_TEST_USERNAME_TESTUSER61_GATEWAY), now)
+		for uid, vote := range test.votes {
+			bot.handleVote(client, getUserMessage(uid, _MSG_CHAN, vote), now)
+		}
+		delete(bot.userTable, test.leave)
+
+		client = getEchoClient()
+		bot.runVotes(client, now.Add(time.Minute))
+		if len(client.requests) != 1 ||
+			!strings.Contains(client.request.Payload.(_payloadMessage).Message, test.expected) {
+			t.Errorf("%s: Expected: %s, Actual: %+v", test.name, test.expected, client.requests)
+		}
+	}
+
+	/* A ban that passes */
+	bot := getVotingTestbot()
+	now := time.Now()
+	client := getEchoClient()
+	for _, uid := range []int{_TEST_USERID_59, _TEST_USERID_60, _TEST_USERID_155} {
+		bot.handleVote(client, getUserMessage(uid, _MSG_CHAN, ".voteban "+_TEST_USERNAME_TESTUSER61_GATEWAY), now)
+		bot.handleVote(client, getUserMessage(uid, _MSG_CHAN, ".yes"), now)
+	}
+	bot.runVotes(client, now.Add(time.Minute))
+	if client.request.Command != _REQUEST_BAN {
+		t.Errorf("Expected: %s, Actual: %+v", _REQUEST_BAN, client.request)
+	}
+}
+
+func TestVoteRejected(t *testing.T) {
+	bot := getVotingTestbot()
+	bot.userTable[62] = "Mod#Azeroth"
+	bot.setUserFlags(62, []string{"Moderator"})
+	now := time.Now()
+
+	for _, test := range []struct {
+		message  string
+		expected string
+	}{
+		{".votekick " + _TEST_USERNAME_PRIVUSER155, "can not be voted on"},
+		{".voteban Mod#Azeroth", "can not be voted on"},
+		{".votekick Nobody#Azeroth", "is not in the channel"},
+		{".votekick TestUser61", _NOTIFICATION_NO_GATEWAY},
+		{".votekick", "Usage"},
+	} {
+		client := getEchoClient()
+		bot.handleVote(client, getUserMessage(_TEST_USERID_59, _MSG_WHISPER, test.message), now)
+		if client.request.Command != _REQUEST_WHISPER ||
+			!strings.Contains(client.request.Payload.(_payloadMessage).Message, test.expected) {
+			t.Errorf("Expected: %s, Actual: %+v", test.expected, client.requests)
+		}
+	}
+	if bot.voting.vote != nil {
+		t.Fatalf("Expected: no vote, Actual: %+v", bot.voting.vote)
+	}
+
+	/* One vote at a time */
+	bot.handleVote(getEchoClient(), getUserMessage(_TEST_USERID_59, _MSG_CHAN,
+		".votekick "+_TEST_USERNAME_TESTUSER61_GATEWAY), now)
+	client := getEchoClient()
+	bot.handleVote(client, getUserMessage(_TEST_USERID_60, _MSG_CHAN,
+		".votekick Nobody#Azeroth"), now)
+	if !strings.Contains(client.request.Payload.(_payloadMessage).Message, "already open") {
+		t.Errorf("Expected: already open, Actual: %+v", client.requests)
+	}
+
+	/* Made a moderator while the vote is open */
+	bot.setUserFlags(_TEST_USERID_61, []string{"Moderator"})
+	for _, uid := range []int{_TEST_USERID_59, _TEST_USERID_60, _TEST_USERID_155} {
+		bot.handleVote(getEchoClient(), getUserMessage(uid, _MSG_CHAN, ".yes"), now)
+	}
+	client = getEchoClient()
+	bot.runVotes(client, now.Add(time.Minute))
+	for _, request := range client.requests {
+		if request.Command == _REQUEST_KICK {
+			t.Errorf("Expected: no kick, Actual: %+v", client.requests)
+		}
+	}
+	bot.setUserFlags(_TEST_USERID_61, nil)
+
+	/* Cooldown */
+	client = getEchoClient()
+	bot.handleVote(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN,
+		".votekick "+_TEST_USERNAME_TESTUSER61_GATEWAY), now.Add(2*time.Minute))
+	if message := client.request.Payload.(_payloadMessage).Message; message != "You can start another vote in 8m0s." {
+		t.Errorf("Expected: another vote in 8m0s, Actual: %s", message)
+	}
+	bot.handleVote(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN,
+		".votekick "+_TEST_USERNAME_TESTUSER61_GATEWAY), now.Add(10*time.Minute))
+	if bot.voting.vote == nil {
+		t.Errorf("Expected: vote after the cooldown, Actual: none")
+	}
+
+	/* Off unless configured */
+	bot = getTestbot()
+	client = getEchoClient()
+	bot.handleVote(client, getUserMessage(_TEST_USERID_59, _MSG_CHAN,
+		".votekick "+_TEST_USERNAME_TESTUSER61_GATEWAY), now)
+	if len(client.requests) != 0 {
+		t.Errorf("Expected: no requests, Actual: %+v", client.requests)
+	}
+}
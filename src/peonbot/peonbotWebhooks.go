@@ -0,0 +1,152 @@
+package peonbot
+
+import (
+	"fmt"
+	"path/filepath"
+	"peonbot/webhook"
+	"strings"
+	"time"
+)
+
+/*
+	Webhooks. Moderation actions, keywords said in the channel, and joins
+	of watched users are sent to the webhooks interested in them. Messages
+	POSTed to the inbound webhook are said in the channel.
+*/
+
+/* Deliveries that failed for good, in the data dir */
+const _FILE_WEBHOOK_DEAD_LETTER = "webhooks_failed.jsonl"
+
+/* Inbound messages waiting for the event loop */
+const _WEBHOOK_INBOUND_QUEUE = 16
+
+type _webhook struct {
+	hook     *webhook.Hook
+	events   map[string]interface{}
+	keywords []string               /* lower case */
+	watch    map[string]interface{} /* upper case */
+}
+
+/*
+	Send `events` to `url`, rendered with the `payload` template. Keyword
+	events need `keywords`, and join events only fire for users on `watch`,
+	or for everyone if it is empty.
+*/
+func (bot *_bot) AddWebhook(url string, payload string, events []string, keywords []string, watch []string) error {
+	hook, err := webhook.NewHook(url, payload)
+	if err != nil {
+		return err
+	}
+
+	if bot.sender == nil {
+		bot.sender = webhook.NewSender(filepath.Join(bot.dataDir, _FILE_WEBHOOK_DEAD_LETTER))
+		bot.sender.Printf = bot.Printf
+	}
+
+	w := &_webhook{
+		hook:   hook,
+		events: make(map[string]interface{}),
+		watch:  toUserSet(watch...),
+	}
+	for _, event := range events {
+		w.events[strings.ToLower(event)] = nil
+	}
+	for _, keyword := range keywords {
+		w.keywords = append(w.keywords, strings.ToLower(keyword))
+	}
+
+	bot.webhooks = append(bot.webhooks, w)
+	return nil
+}
+
+/*
+	Accept signed messages on `addr` at `path`, and say them in the
+	channel, until the bot stops
+*/
+func (bot *_bot) ServeWebhook(addr string, path string, secret string) {
+	go func() {
+		bot.Printf("Accepting webhooks on %s%s\n", addr, path)
+		if err := webhook.Serve(bot.ctx, addr, path, secret, bot.chweb); err != nil {
+			bot.Printf("Stopped accepting webhooks: %v\n", err)
+		}
+	}()
+}
+
+/* Stop sending webhooks. Deliveries not yet made are dead lettered. */
+func (bot *_bot) StopWebhooks() {
+	if bot.sender != nil {
+		bot.sender.Close()
+	}
+}
+
+func (bot *_bot) sendWebhook(w *_webhook, event webhook.Event) {
+	event.Bot = bot.name
+	event.Channel = bot.channel
+	event.Time = time.Now()
+
+	if err := bot.sender.Send(w.hook, event); err != nil {
+		bot.Printf("Could not send %s webhook to %s: %v\n", event.Event, w.hook, err)
+	}
+}
+
+/* A kick, ban, or unban was sent to the server */
+func (bot *_bot) webhookModeration(action string, user string) {
+	for _, w := range bot.webhooks {
+		if _, ok := w.events[action]; ok {
+			bot.sendWebhook(w, webhook.Event{Event: action, User: user})
+		}
+	}
+}
+
+func (bot *_bot) webhookMessage(event _event) {
+	if strings.ToUpper(event.Payload.Type) != _MSG_CHAN {
+		return
+	}
+
+	message := strings.ToLower(event.Payload.Message)
+	for _, w := range bot.webhooks {
+		if _, ok := w.events[webhook.EVENT_KEYWORD]; !ok {
+			continue
+		}
+
+		for _, keyword := range w.keywords {
+			if strings.Contains(message, keyword) {
+				bot.sendWebhook(w, webhook.Event{
+					Event:   webhook.EVENT_KEYWORD,
+					User:    bot.userTable[event.Payload.UserId],
+					Message: event.Payload.Message,
+					Keyword: keyword,
+				})
+				break
+			}
+		}
+	}
+}
+
+func (bot *_bot) webhookJoin(user string) {
+	if bot.joinGrace(time.Now()) {
+		return
+	}
+
+	for _, w := range bot.webhooks {
+		if _, ok := w.events[webhook.EVENT_JOIN]; !ok {
+			continue
+		}
+
+		if _, watched := w.watch[strings.ToUpper(user)]; watched || len(w.watch) == 0 {
+			bot.sendWebhook(w, webhook.Event{Event: webhook.EVENT_JOIN, User: user})
+		}
+	}
+}
+
+/* Say an inbound message in the channel. Only call from the event loop. */
+func (bot *_bot) handleInboundWebhook(client WebsocketClient, message webhook.Message) {
+	text := message.Message
+	if len(message.From) > 0 {
+		text = fmt.Sprintf("<%s> %s", message.From, message.Message)
+	}
+
+	if err := handleActionSay(client, bot, text); err != nil {
+		bot.Printf("Could not say inbound webhook message: %v\n", err)
+	}
+}
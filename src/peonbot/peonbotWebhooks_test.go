@@ -0,0 +1,127 @@
+package peonbot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"peonbot/webhook"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	mu     sync.Mutex
+	events []webhook.Event
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var event webhook.Event
+
+	raw, _ := ioutil.ReadAll(req.Body)
+	if err := json.Unmarshal(raw, &event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+/* Events received, as "event user", sorted since delivery is concurrent */
+func (r *webhookReceiver) received() []string {
+	var received []string
+	for _, event := range r.events {
+		received = append(received, event.Event+" "+event.User)
+	}
+	sort.Strings(received)
+
+	return received
+}
+
+func TestWebhooks(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	client := getEchoClient()
+	testbot := getTestbot()
+	testbot.dataDir = t.TempDir()
+
+	if err := testbot.AddWebhook(server.URL, "",
+		[]string{"ban", "KEYWORD", "join"}, []string{"Hack"},
+		[]string{_TEST_USERNAME_TESTUSER60}); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	_ = _handleActionBan(client, testbot, _TEST_USERID_59)
+	_ = _handleActionKick(client, testbot, _TEST_USERID_59)
+
+	testbot.webhookMessage(getUserMessage(_TEST_USERID_61, _MSG_CHAN, "got a map HACK?"))
+	testbot.webhookMessage(getUserMessage(_TEST_USERID_61, _MSG_WHISPER, "hack"))
+	testbot.webhookMessage(getUserMessage(_TEST_USERID_61, _MSG_CHAN, "hello"))
+
+	testbot.webhookJoin(_TEST_USERNAME_TESTUSER60)
+	testbot.webhookJoin(_TEST_USERNAME_TESTUSER59)
+
+	testbot.sender.Wait()
+
+	expected := []string{
+		"ban " + _TEST_USERNAME_TESTUSER59,
+		"join " + _TEST_USERNAME_TESTUSER60,
+		"keyword " + _TEST_USERNAME_TESTUSER61_GATEWAY,
+	}
+	if strings.Join(receiver.received(), "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected: %v, Actual: %v", expected, receiver.received())
+	}
+}
+
+func TestWebhookJoinGrace(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	testbot := getTestbot()
+	testbot.dataDir = t.TempDir()
+	if err := testbot.AddWebhook(server.URL, "", []string{"join"}, nil, nil); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	/* Everyone in the channel is announced again on every connect, and reconnect */
+	for i := 0; i < 2; i++ {
+		testbot.userTable = make(map[int]string)
+		testbot.handleConnect(getAction(_EVENT_CONNECT, _payload{Channel: "Clan Peon"}))
+		_ = join(testbot, _TEST_USERID_59, _TEST_USERNAME_TESTUSER59)
+		_ = join(testbot, _TEST_USERID_60, _TEST_USERNAME_TESTUSER60)
+	}
+
+	/* Joins after the burst are sent */
+	testbot.connected = time.Now().Add(-_JOIN_GRACE)
+	_ = join(testbot, _TEST_USERID_61, _TEST_USERNAME_TESTUSER61_GATEWAY)
+
+	testbot.sender.Wait()
+
+	expected := []string{"join " + _TEST_USERNAME_TESTUSER61_GATEWAY}
+	if strings.Join(receiver.received(), "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected: %v, Actual: %v", expected, receiver.received())
+	}
+}
+
+func TestInboundWebhook(t *testing.T) {
+	client := getEchoClient()
+	testbot := getTestbot()
+
+	testbot.handleInboundWebhook(client, webhook.Message{From: "discord", Message: "hi"})
+	testbot.handleInboundWebhook(client, webhook.Message{Message: "plain"})
+
+	if len(client.requests) != 2 ||
+		client.requests[0].Payload.(_payloadMessage).Message != "<discord> hi" ||
+		client.requests[1].Payload.(_payloadMessage).Message != "plain" {
+
+		t.Errorf("Unexpected requests: %+v", client.requests)
+	}
+}
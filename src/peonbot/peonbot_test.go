@@ -1,9 +1,20 @@
 package peonbot
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"peonbot/federation"
+	"peonbot/irc"
+	"peonbot/plugin"
 	"peonbot/verbose"
+	"peonbot/webhook"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -15,7 +26,8 @@ import (
 	client should be used for testing any call to `WriteJSON`(interface{})`.
 */
 type echoClient struct {
-	request _request
+	request  _request
+	requests []_request /* every request, in the order it was written */
 }
 
 func getEchoClient() *echoClient {
@@ -24,6 +36,7 @@ func getEchoClient() *echoClient {
 
 func (e *echoClient) WriteJSON(v interface{}) error {
 	e.request = v.(_request)
+	e.requests = append(e.requests, e.request)
 	return nil
 }
 
@@ -55,12 +68,29 @@ func getTestbot() *_bot {
 		strings.ToUpper(_TEST_USERNAME_PRIVUSER155): nil,
 	}
 
+	/* As if both lists were read from config */
+	configBlist := make(map[string]interface{})
+	for user := range blist {
+		configBlist[user] = nil
+	}
+	configPusers := map[string]interface{}{
+		strings.ToUpper(_TEST_USERNAME_PRIVUSER155): nil,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &_bot{
+		Printf:    log.Printf,
 		Vprintf:   verbose.Vprintf,
 		rid:       0,
 		userTable: userTable,
 		blist:     blist,
 		pusers:    pusers,
+		ctx:       ctx,
+		cancel:    cancel,
+
+		configBlist:  configBlist,
+		configPusers: configPusers,
 	}
 }
 
@@ -194,3 +224,184 @@ func TestRmBanlist(t *testing.T) {
 		t.Errorf("User should have been removed from banlist, but was not.")
 	}
 }
+
+/*
+	A bot with its event loop running, writing requests to a stand-in for
+	the server. Returns a channel closed when the loop returns.
+*/
+func startEventLoop(reload ReloadFunc) (*_bot, chan struct{}) {
+	bot := New("token", nil, "", []string{_TEST_USERNAME_PRIVUSER155})
+	bot.Printf = func(string, ...interface{}) {}
+	bot.Vprintf = func(string, ...interface{}) {}
+	bot.replay = &_replayClient{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bot.EventLoop(reload)
+	}()
+
+	return bot, done
+}
+
+/* Why the event loop ended decides the exit code */
+func TestEventLoopEnded(t *testing.T) {
+	run := func(bot *_bot) chan error {
+		bot.Printf = func(string, ...interface{}) {}
+		ended := make(chan error, 1)
+		go func() {
+			ended <- bot.EventLoop(nil)
+		}()
+		return ended
+	}
+
+	stopped := New("token", nil, "", nil)
+	ended := run(stopped)
+	stopped.Stop()
+	if err := <-ended; err != nil {
+		t.Errorf("Expected: nil once stopped, Actual: %v", err)
+	}
+
+	lost := New("token", nil, "", nil)
+	ended = run(lost)
+	lost.cherr <- errors.New("connection reset by peer")
+	if err := <-ended; err == nil || IsAuthError(err) {
+		t.Errorf("Expected: the connection error, Actual: %v", err)
+	}
+
+	revoked := New("token", nil, "", nil)
+	revoked.reconnecting = true
+	ended = run(revoked)
+	revoked.chrcn <- _reconnect{err: &_authError{status: &_status{Area: 6, Code: 2}}}
+	if err := <-ended; !IsAuthError(err) {
+		t.Errorf("Expected: the api key rejected, Actual: %v", err)
+	}
+}
+
+/* Everything that hands the event loop work, all at once */
+func TestEventLoopConcurrent(t *testing.T) {
+	const workers = 8
+	const each = 50
+
+	bot, done := startEventLoop(func(name string) ([]string, []string, error) {
+		return []string{_TEST_USERNAME_BANNED_BANNEDUSER159}, nil, nil
+	})
+	group := NewGroup()
+	group.Add(bot)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(5)
+
+		/* Joins, as read from the websocket */
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				uid := 1000 + w*each + i
+				bot.deliver([]byte(fmt.Sprintf(
+					`{"command": "Botapichat.UserUpdateEventRequest", "request_id": %d, "payload": {"user_id": %d, "toon_name": "Peon%d"}}`,
+					uid, uid, uid)))
+			}
+		}(w)
+
+		/* The console */
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				_ = group.Input("hello")
+			}
+		}()
+
+		/* The terminal UI */
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				_ = bot.Snapshot()
+			}
+		}()
+
+		/* Config changes */
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				bot.RequestReload()
+			}
+		}()
+
+		/* Webhooks, IRC, and plugins */
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				bot.chweb <- webhook.Message{From: "discord", Message: "hi"}
+				bot.chirc <- irc.Message{Nick: "grunt", Text: "hi"}
+				bot.chplg <- plugin.Request{Plugin: "none", Action: "say"}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if snapshot := bot.Snapshot(); len(snapshot.Members) != workers*each {
+		t.Errorf("Expected: %d members, Actual: %d", workers*each, len(snapshot.Members))
+	}
+
+	group.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected: event loop to return once stopped, Actual: still running")
+	}
+
+	if snapshot := bot.Snapshot(); snapshot.State != STATE_DISCONNECTED {
+		t.Errorf("Expected: %s, Actual: %s", STATE_DISCONNECTED, snapshot.State)
+	}
+}
+
+/* Goroutines waiting on a stopped bot return, instead of blocking forever */
+func TestStopUnblocks(t *testing.T) {
+	conn, stop := startPingServer(t, true)
+	defer stop()
+
+	bot := getConnectedTestbot(conn, time.Minute)
+	bot.cherr = make(chan error)
+	bot.chsin = make(chan string)
+	bot.chfed = make(chan _feedUpdate)
+	bot.AddFeed(federation.NewStore(filepath.Join(t.TempDir(), "bans.jsonl"), ""),
+		federation.TrustFull, false)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		/* Fails reading once the connection is closed, and returns */
+		bot.listen(bot.keepalive, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		bot.listen(bot.keepalive, [][]byte{[]byte(`{}`)})
+	}()
+	go func() {
+		defer wg.Done()
+		bot.PollFeeds(time.Millisecond)
+	}()
+	go func() {
+		defer wg.Done()
+		group := NewGroup()
+		group.Add(bot)
+		_ = group.Input("hello")
+	}()
+
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	bot.Stop()
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected: every goroutine to return once stopped, Actual: still blocked")
+	}
+}
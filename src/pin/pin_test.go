@@ -0,0 +1,179 @@
+package pin
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"peonbot/pin/pintest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const _TEST_NAME = "classic.blizzard.com"
+
+func newLeaf(t *testing.T, parent *pintest.Cert, names ...string) *pintest.Cert {
+	cert, err := pintest.NewLeaf(parent, names...)
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
+
+	return cert
+}
+
+func newCA(t *testing.T) *pintest.Cert {
+	now := time.Now()
+	cert, err := pintest.NewCert(nil, true, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
+
+	return cert
+}
+
+func fingerprint(cert *pintest.Cert) string {
+	hash := sha256.Sum256(cert.Cert.Raw)
+
+	var digits []string
+	for _, b := range hash {
+		digits = append(digits, fmt.Sprintf("%02X", b))
+	}
+
+	return _PREFIX_CERT + strings.Join(digits, ":")
+}
+
+func mustParse(t *testing.T, pins ...string) []Pin {
+	parsed, err := ParseAll(pins)
+	if err != nil {
+		t.Fatalf("Could not parse pins: %v", err)
+	}
+
+	return parsed
+}
+
+func TestParse(t *testing.T) {
+	for _, valid := range []string{
+		"sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		"cert-sha256/e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"cert-sha256/E3:B0:C4:42:98:FC:1C:14:9A:FB:F4:C8:99:6F:B9:24:27:AE:41:E4:64:9B:93:4C:A4:95:99:1B:78:52:B8:55",
+	} {
+		if _, err := Parse(valid); err != nil {
+			t.Errorf("Expected: %s to parse, Actual: %v", valid, err)
+		}
+	}
+
+	for _, invalid := range []string{
+		"",
+		"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		"sha256/not base64",
+		"sha256/AAAA",
+		"cert-sha256/e3b0c442",
+		"md5/e3b0c44298fc1c149afbf4c8996fb924",
+	} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Expected: error parsing '%s', Actual: nil", invalid)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ca := newCA(t)
+	leaf := newLeaf(t, ca, "*."+_TEST_NAME, _TEST_NAME)
+	other := newCA(t)
+	now := time.Now
+
+	chain := [][]byte{leaf.Cert.Raw, ca.Cert.Raw}
+
+	for _, test := range []struct {
+		name  string
+		certs [][]byte
+		pins  []string
+		ok    bool
+	}{
+		{"no pins", chain, nil, true},
+		{"leaf spki", chain, []string{SPKI(leaf.Cert)}, true},
+		{"leaf fingerprint", chain, []string{fingerprint(leaf)}, true},
+		{"ca spki", chain, []string{SPKI(other.Cert), SPKI(ca.Cert)}, true},
+		{"other ca", chain, []string{SPKI(other.Cert)}, false},
+		/* The pinned certificate is sent, but did not sign the leaf */
+		{"unsigned chain", [][]byte{leaf.Cert.Raw, other.Cert.Raw}, []string{SPKI(other.Cert)}, false},
+		{"no certs", nil, nil, false},
+		{"garbage", [][]byte{[]byte("garbage")}, nil, false},
+	} {
+		err := Verify(_TEST_NAME, mustParse(t, test.pins...), now)(test.certs, nil)
+		if test.ok && err != nil {
+			t.Errorf("%s: Expected: nil, Actual: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: Expected: error, Actual: nil", test.name)
+		}
+	}
+
+	/* Name */
+	wrong := newLeaf(t, nil, "example.com")
+	if err := Verify(_TEST_NAME, nil, now)([][]byte{wrong.Cert.Raw}, nil); err == nil {
+		t.Errorf("Expected: error for a certificate for example.com, Actual: nil")
+	}
+
+	/* Validity period */
+	expired, err := pintest.NewCert(nil, false, time.Now().Add(-48*time.Hour),
+		time.Now().Add(-24*time.Hour), _TEST_NAME)
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
+	if err := Verify(_TEST_NAME, nil, now)([][]byte{expired.Cert.Raw}, nil); err == nil {
+		t.Errorf("Expected: error for an expired certificate, Actual: nil")
+	}
+	later := func() time.Time { return time.Now().Add(-36 * time.Hour) }
+	if err := Verify(_TEST_NAME, nil, later)([][]byte{expired.Cert.Raw}, nil); err != nil {
+		t.Errorf("Expected: nil while the certificate was valid, Actual: %v", err)
+	}
+
+	/* A mismatch reports the pin to use */
+	err = Verify(_TEST_NAME, mustParse(t, SPKI(other.Cert)), now)(chain, nil)
+	if err == nil || !strings.Contains(err.Error(), SPKI(leaf.Cert)) {
+		t.Errorf("Expected: error with %s, Actual: %v", SPKI(leaf.Cert), err)
+	}
+}
+
+/* A handshake with a server whose certificate is not from a trusted root */
+func TestTLSConfig(t *testing.T) {
+	ca := newCA(t)
+	leaf := newLeaf(t, ca, _TEST_NAME)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{leaf.TLS(ca)},
+	})
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().String()
+
+	conn, err := tls.Dial("tcp", addr, TLSConfig(_TEST_NAME, mustParse(t, SPKI(ca.Cert))))
+	if err != nil {
+		t.Fatalf("Expected: handshake to succeed, Actual: %v", err)
+	}
+	conn.Close()
+
+	if _, err := tls.Dial("tcp", addr, TLSConfig("example.com", nil)); err == nil {
+		t.Errorf("Expected: handshake to fail for example.com, Actual: nil")
+	}
+
+	other := newCA(t)
+	if _, err := tls.Dial("tcp", addr, TLSConfig(_TEST_NAME, mustParse(t, SPKI(other.Cert)))); err == nil {
+		t.Errorf("Expected: handshake to fail with another pin, Actual: nil")
+	}
+}
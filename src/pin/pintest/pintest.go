@@ -0,0 +1,90 @@
+package pintest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+)
+
+/*
+	Locally generated certificates for testing certificate verification,
+	standing in for the ones battle.net serves.
+*/
+
+type Cert struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+/*
+	A certificate for `names`, valid from `notBefore` to `notAfter`, signed
+	by `parent`. Self signed if `parent` is nil.
+*/
+func NewCert(parent *Cert, ca bool, notBefore time.Time, notAfter time.Time, names ...string) (*Cert, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "peonbot test"},
+		DNSNames:              names,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.Cert, parent.Key
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cert{Cert: cert, Key: key}, nil
+}
+
+/* A certificate for `names` valid for a day either side of now */
+func NewLeaf(parent *Cert, names ...string) (*Cert, error) {
+	now := time.Now()
+	return NewCert(parent, false, now.Add(-24*time.Hour), now.Add(24*time.Hour), names...)
+}
+
+/* For serving `c`, followed by `chain` */
+func (c *Cert) TLS(chain ...*Cert) tls.Certificate {
+	certificate := tls.Certificate{
+		Certificate: [][]byte{c.Cert.Raw},
+		PrivateKey:  c.Key,
+		Leaf:        c.Cert,
+	}
+	for _, cert := range chain {
+		certificate.Certificate = append(certificate.Certificate, cert.Cert.Raw)
+	}
+
+	return certificate
+}
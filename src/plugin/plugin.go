@@ -0,0 +1,264 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+/*
+	Out-of-process plugins. A plugin is an executable that reads bot events
+	from stdin and writes action requests to stdout, one json object per
+	line. Whatever it writes to stderr is logged. A plugin that exits is
+	restarted, waiting longer after each crash in a row.
+
+	Events sent to plugins:
+	  {"event":"message","user":"name#Gateway","type":"channel","message":"hi"}
+	  {"event":"join","user":"name#Gateway"}
+	  {"event":"leave","user":"name#Gateway"}
+
+	Requests read from plugins:
+	  {"action":"say","message":"hi"}
+	  {"action":"whisper","user":"name#Gateway","message":"hi"}
+	  {"action":"kick","user":"name#Gateway"}
+	  {"action":"ban","user":"name#Gateway"}
+*/
+
+const EVENT_MESSAGE = "message"
+const EVENT_JOIN = "join"
+const EVENT_LEAVE = "leave"
+
+const ACTION_SAY = "say"
+const ACTION_WHISPER = "whisper"
+const ACTION_KICK = "kick"
+const ACTION_BAN = "ban"
+
+type Event struct {
+	Event   string `json:"event"`
+	User    string `json:"user"`
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type Request struct {
+	Action  string `json:"action"`
+	User    string `json:"user,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	Plugin string `json:"-"` /* name of the plugin that sent it */
+}
+
+/* Events are dropped rather than block the bot on a slow plugin */
+const _EVENT_QUEUE = 64
+
+/* Lines longer than this from a plugin are an error */
+const _MAX_LINE = 64 * 1024
+
+const _MIN_BACKOFF = time.Second
+const _MAX_BACKOFF = time.Minute
+
+type Plugin struct {
+	Printf func(string, ...interface{})
+
+	name    string
+	command string
+	args    []string
+
+	events chan Event
+	done   chan struct{}
+	stop   sync.Once
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func New(name string, command string, args ...string) *Plugin {
+	return &Plugin{
+		Printf:     log.Printf,
+		name:       name,
+		command:    command,
+		args:       args,
+		events:     make(chan Event, _EVENT_QUEUE),
+		done:       make(chan struct{}),
+		minBackoff: _MIN_BACKOFF,
+		maxBackoff: _MAX_BACKOFF,
+	}
+}
+
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+/* Queue an event for the plugin. Returns an error if the queue is full. */
+func (p *Plugin) Send(event Event) error {
+	select {
+	case p.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("Plugin '%s' is not keeping up. Dropped event: %+v",
+			p.name, event)
+	}
+}
+
+/* Events waiting to be written to the plugin */
+func (p *Plugin) Queued() int {
+	return len(p.events)
+}
+
+/* Stop the plugin, and do not restart it */
+func (p *Plugin) Stop() {
+	p.stop.Do(func() {
+		close(p.done)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.cmd != nil && p.cmd.Process != nil {
+			_ = p.cmd.Process.Kill()
+		}
+	})
+}
+
+func (p *Plugin) stopped() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+	Run the plugin, restarting it whenever it exits, until `Stop` is
+	called. Requests it makes are sent to `requests`. Blocks, so start it
+	on its own goroutine.
+*/
+func (p *Plugin) Run(requests chan<- Request) {
+	backoff := p.minBackoff
+
+	for !p.stopped() {
+		started := time.Now()
+		if err := p.runOnce(requests); err != nil {
+			p.Printf("Plugin '%s' exited: %v\n", p.name, err)
+		}
+
+		if p.stopped() {
+			return
+		}
+
+		/* A plugin that ran for a while was not crash looping */
+		if time.Since(started) > p.maxBackoff {
+			backoff = p.minBackoff
+		}
+
+		p.Printf("Restarting plugin '%s' in %v\n", p.name, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-p.done:
+			return
+		}
+
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+func (p *Plugin) runOnce(requests chan<- Request) error {
+	cmd := exec.Command(p.command, p.args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.stopped() {
+		p.mu.Unlock()
+		return nil
+	}
+	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	p.cmd = cmd
+	p.mu.Unlock()
+
+	exited := make(chan struct{})
+	go p.writeEvents(stdin, exited)
+	go p.logStderr(stderr)
+
+	p.readRequests(stdout, requests)
+
+	err = cmd.Wait()
+	close(exited)
+
+	return err
+}
+
+func (p *Plugin) writeEvents(stdin io.WriteCloser, exited chan struct{}) {
+	defer stdin.Close()
+	encoder := json.NewEncoder(stdin)
+
+	for {
+		select {
+		case event := <-p.events:
+			if err := encoder.Encode(event); err != nil {
+				p.Printf("Could not send event to plugin '%s': %v\n", p.name, err)
+				return
+			}
+		case <-exited:
+			return
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Plugin) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		p.Printf("[plugin %s] %s\n", p.name, scanner.Text())
+	}
+}
+
+func (p *Plugin) readRequests(stdout io.Reader, requests chan<- Request) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 4096), _MAX_LINE)
+
+	for scanner.Scan() {
+		var request Request
+
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			p.Printf("Ignoring invalid request from plugin '%s': %v\n", p.name, err)
+			continue
+		}
+		request.Plugin = p.name
+
+		select {
+		case requests <- request:
+		case <-p.done:
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		p.Printf("Could not read from plugin '%s': %v\n", p.name, err)
+	}
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const _ENV_FAKE_PLUGIN = "PEONBOT_FAKE_PLUGIN"
+
+/*
+	Not a real test. Run as a plugin by re-executing the test binary: says
+	"started", answers "!ping" with "pong", whispers "!whoami" back to the
+	sender, and crashes on "!crash".
+*/
+func TestFakePlugin(t *testing.T) {
+	if os.Getenv(_ENV_FAKE_PLUGIN) != "1" {
+		return
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	_ = out.Encode(Request{Action: ACTION_SAY, Message: "started"})
+	fmt.Println("not json")
+	fmt.Fprintln(os.Stderr, "fake plugin running")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			os.Exit(2)
+		}
+
+		switch event.Message {
+		case "!ping":
+			_ = out.Encode(Request{Action: ACTION_SAY, Message: "pong"})
+		case "!whoami":
+			_ = out.Encode(Request{Action: ACTION_WHISPER, User: event.User,
+				Message: event.User})
+		case "!crash":
+			os.Exit(1)
+		}
+	}
+
+	os.Exit(0)
+}
+
+func getFakePlugin(t *testing.T) *Plugin {
+	os.Setenv(_ENV_FAKE_PLUGIN, "1")
+	t.Cleanup(func() { os.Unsetenv(_ENV_FAKE_PLUGIN) })
+
+	p := New("fake", os.Args[0], "-test.run=^TestFakePlugin$")
+	p.minBackoff = 10 * time.Millisecond
+	p.maxBackoff = 100 * time.Millisecond
+
+	return p
+}
+
+func expectRequest(t *testing.T, requests chan Request, expected Request) {
+	select {
+	case request := <-requests:
+		if request != expected {
+			t.Fatalf("Expected: %+v, Actual: %+v", expected, request)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for: %+v", expected)
+	}
+}
+
+func TestPluginRequests(t *testing.T) {
+	p := getFakePlugin(t)
+	defer p.Stop()
+
+	requests := make(chan Request)
+	go p.Run(requests)
+
+	expectRequest(t, requests, Request{Action: ACTION_SAY, Message: "started", Plugin: "fake"})
+
+	if err := p.Send(Event{Event: EVENT_MESSAGE, User: "a#Azeroth", Message: "!whoami"}); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	expectRequest(t, requests, Request{Action: ACTION_WHISPER, User: "a#Azeroth",
+		Message: "a#Azeroth", Plugin: "fake"})
+}
+
+func TestPluginRestart(t *testing.T) {
+	p := getFakePlugin(t)
+	defer p.Stop()
+
+	requests := make(chan Request)
+	go p.Run(requests)
+
+	expectRequest(t, requests, Request{Action: ACTION_SAY, Message: "started", Plugin: "fake"})
+
+	_ = p.Send(Event{Event: EVENT_MESSAGE, Message: "!crash"})
+	expectRequest(t, requests, Request{Action: ACTION_SAY, Message: "started", Plugin: "fake"})
+
+	/* Events queued around the crash may be lost, so keep asking */
+	deadline := time.After(10 * time.Second)
+	for {
+		_ = p.Send(Event{Event: EVENT_MESSAGE, Message: "!ping"})
+
+		select {
+		case request := <-requests:
+			if strings.Compare("pong", request.Message) == 0 {
+				return
+			}
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("Restarted plugin never answered")
+		}
+	}
+}
+
+func TestPluginStop(t *testing.T) {
+	p := getFakePlugin(t)
+
+	requests := make(chan Request)
+	done := make(chan struct{})
+	go func() {
+		p.Run(requests)
+		close(done)
+	}()
+
+	expectRequest(t, requests, Request{Action: ACTION_SAY, Message: "started", Plugin: "fake"})
+	p.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Run should return once the plugin is stopped")
+	}
+}
+
+func TestPluginMissingCommand(t *testing.T) {
+	p := New("missing", "/nonexistent/plugin")
+	p.minBackoff = time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		p.Run(make(chan Request))
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	p.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Run should return once the plugin is stopped")
+	}
+}
+
+func TestPluginSendFull(t *testing.T) {
+	p := New("idle", "true")
+
+	for i := 0; i < _EVENT_QUEUE; i++ {
+		if err := p.Send(Event{Event: EVENT_JOIN}); err != nil {
+			t.Fatalf("Expected nil, but got an error: %v", err)
+		}
+	}
+
+	if err := p.Send(Event{Event: EVENT_JOIN}); err == nil {
+		t.Errorf("Expected an error, but got nil.")
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+/*
+	Proxies for the connection to battle.net, for networks that only allow
+	outbound connections through one. A proxy is one of:
+
+	http://[user:password@]host:port      HTTP CONNECT, with basic auth
+	socks5://[user:password@]host:port    SOCKS5, with username/password auth
+
+	A proxy without a scheme is an HTTP proxy. One from config is used in
+	place of the HTTPS_PROXY (or https_proxy) environment variable, and
+	NO_PROXY (or no_proxy) applies to either. `direct` in config ignores
+	the environment and always connects directly.
+*/
+
+const DIRECT = "direct"
+
+func Parse(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("Proxy is not a valid url: %v", err)
+	}
+
+	switch u.Scheme {
+	case "http", "socks5":
+	default:
+		return nil, fmt.Errorf(
+			"Proxy '%s' must be an http:// or socks5:// proxy.", u.Redacted())
+	}
+
+	if len(u.Hostname()) == 0 || len(u.Port()) == 0 {
+		return nil, fmt.Errorf("Proxy '%s' must have a host and port.", u.Redacted())
+	}
+
+	return u, nil
+}
+
+/*
+	Returns a `websocket.Dialer.Proxy` function for the `configured` proxy,
+	or the environment's if it is empty.
+*/
+func Func(configured string) (func(*http.Request) (*url.URL, error), error) {
+	return funcFrom(configured, httpproxy.FromEnvironment())
+}
+
+func funcFrom(configured string, env *httpproxy.Config) (func(*http.Request) (*url.URL, error), error) {
+	config := &httpproxy.Config{NoProxy: env.NoProxy}
+
+	switch configured {
+	case DIRECT:
+		return func(*http.Request) (*url.URL, error) { return nil, nil }, nil
+	case "":
+		config.HTTPSProxy = env.HTTPSProxy
+	default:
+		config.HTTPSProxy = configured
+	}
+
+	if len(config.HTTPSProxy) > 0 {
+		u, err := Parse(config.HTTPSProxy)
+		if err != nil {
+			return nil, err
+		}
+		config.HTTPSProxy = u.String()
+	}
+
+	/* The dialer asks with https:// for a wss:// address */
+	proxyFunc := config.ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return proxyFunc(r.URL)
+	}, nil
+}
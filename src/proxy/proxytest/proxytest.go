@@ -0,0 +1,267 @@
+package proxytest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+/*
+	Local stand-ins for an HTTP CONNECT and a SOCKS5 proxy, for testing
+	connecting through one. Every connection is forwarded to `upstream`,
+	whatever address the client asked for, and the address asked for is
+	remembered. Credentials are required if a user is given.
+*/
+
+type Proxy struct {
+	scheme   string
+	upstream string
+	user     string
+	password string
+	listener net.Listener
+
+	mu        sync.Mutex
+	requested []string
+	conns     []net.Conn
+}
+
+func NewHTTP(upstream string, user string, password string) (*Proxy, error) {
+	return start("http", upstream, user, password)
+}
+
+func NewSOCKS5(upstream string, user string, password string) (*Proxy, error) {
+	return start("socks5", upstream, user, password)
+}
+
+func start(scheme string, upstream string, user string, password string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		scheme:   scheme,
+		upstream: upstream,
+		user:     user,
+		password: password,
+		listener: listener,
+	}
+	go p.accept()
+
+	return p, nil
+}
+
+/* The proxy url, with the credentials it requires */
+func (p *Proxy) URL() string {
+	if len(p.user) == 0 {
+		return fmt.Sprintf("%s://%s", p.scheme, p.listener.Addr())
+	}
+
+	return fmt.Sprintf("%s://%s:%s@%s", p.scheme, p.user, p.password, p.listener.Addr())
+}
+
+/* Addresses clients asked to connect to, that were forwarded */
+func (p *Proxy) Requested() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.requested...)
+}
+
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+
+	return err
+}
+
+func (p *Proxy) track(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.conns = append(p.conns, conn)
+}
+
+func (p *Proxy) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.track(conn)
+
+		if p.scheme == "http" {
+			go p.serveHTTP(conn)
+		} else {
+			go p.serveSOCKS5(conn)
+		}
+	}
+}
+
+/* Connect to upstream, remembering `addr` as asked for */
+func (p *Proxy) forward(addr string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		return nil, err
+	}
+	p.track(conn)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requested = append(p.requested, addr)
+
+	return conn, nil
+}
+
+func pipe(client io.ReadWriteCloser, clientReader io.Reader, upstream io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, clientReader)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, upstream)
+		done <- struct{}{}
+	}()
+
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+func (p *Proxy) serveHTTP(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	if request.Method != http.MethodConnect {
+		fmt.Fprintf(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		conn.Close()
+		return
+	}
+
+	credential := base64.StdEncoding.EncodeToString([]byte(p.user + ":" + p.password))
+	if len(p.user) > 0 && request.Header.Get("Proxy-Authorization") != "Basic "+credential {
+		fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		conn.Close()
+		return
+	}
+
+	upstream, err := p.forward(request.Host)
+	if err != nil {
+		fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		conn.Close()
+		return
+	}
+
+	fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	pipe(conn, reader, upstream)
+}
+
+/* RFC 1928, with username/password authentication from RFC 1929 */
+const (
+	_SOCKS_VERSION       = 5
+	_SOCKS_AUTH_NONE     = 0
+	_SOCKS_AUTH_PASSWORD = 2
+	_SOCKS_AUTH_NO_WAY   = 0xff
+	_SOCKS_CONNECT       = 1
+	_SOCKS_ADDR_IPV4     = 1
+	_SOCKS_ADDR_DOMAIN   = 3
+	_SOCKS_ADDR_IPV6     = 4
+	_SOCKS_SUCCEEDED     = 0
+	_SOCKS_FAILED        = 1
+	_SOCKS_UNSUPPORTED   = 7
+)
+
+func (p *Proxy) serveSOCKS5(conn net.Conn) {
+	/* A short read from the client panics out of here, and hangs up */
+	defer func() {
+		if r := recover(); r != nil {
+			conn.Close()
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			panic(err)
+		}
+		return buf
+	}
+	reply := func(status byte) {
+		/* Bound address 0.0.0.0:0 */
+		_, _ = conn.Write([]byte{_SOCKS_VERSION, status, 0, _SOCKS_ADDR_IPV4, 0, 0, 0, 0, 0, 0})
+	}
+
+	header := read(2)
+	methods := read(int(header[1]))
+
+	want := byte(_SOCKS_AUTH_NONE)
+	if len(p.user) > 0 {
+		want = _SOCKS_AUTH_PASSWORD
+	}
+	offered := false
+	for _, method := range methods {
+		offered = offered || method == want
+	}
+	if header[0] != _SOCKS_VERSION || !offered {
+		_, _ = conn.Write([]byte{_SOCKS_VERSION, _SOCKS_AUTH_NO_WAY})
+		conn.Close()
+		return
+	}
+	_, _ = conn.Write([]byte{_SOCKS_VERSION, want})
+
+	if want == _SOCKS_AUTH_PASSWORD {
+		user := string(read(int(read(2)[1])))
+		password := string(read(int(read(1)[0])))
+		if user != p.user || password != p.password {
+			_, _ = conn.Write([]byte{1, _SOCKS_FAILED})
+			conn.Close()
+			return
+		}
+		_, _ = conn.Write([]byte{1, _SOCKS_SUCCEEDED})
+	}
+
+	request := read(4)
+	var host string
+	switch request[3] {
+	case _SOCKS_ADDR_IPV4:
+		host = net.IP(read(net.IPv4len)).String()
+	case _SOCKS_ADDR_IPV6:
+		host = net.IP(read(net.IPv6len)).String()
+	case _SOCKS_ADDR_DOMAIN:
+		host = string(read(int(read(1)[0])))
+	}
+	port := binary.BigEndian.Uint16(read(2))
+
+	if request[1] != _SOCKS_CONNECT || len(host) == 0 {
+		reply(_SOCKS_UNSUPPORTED)
+		conn.Close()
+		return
+	}
+
+	upstream, err := p.forward(net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		reply(_SOCKS_FAILED)
+		conn.Close()
+		return
+	}
+
+	reply(_SOCKS_SUCCEEDED)
+	pipe(conn, reader, upstream)
+}
@@ -0,0 +1,312 @@
+package script
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+/*
+	Channel automation scripts, written in Starlark (a small, sandboxed
+	dialect of Python). Every `*.star` file in the scripts dir is loaded,
+	and files that change are reloaded. A script reacts to the channel by
+	defining any of these functions:
+
+	  def on_message(user, message): ...
+	  def on_join(user): ...
+	  def on_leave(user): ...
+
+	Scripts cannot touch files or the network. They can only use the
+	builtins below, and every call is cut off after a number of execution
+	steps, or a timeout, whichever comes first. A call that sends more
+	than a few messages fails, so one join cannot flood the channel.
+
+	  say(message)           - send a message to the channel
+	  whisper(user, message) - whisper a user in the channel
+	  users()                - names of everyone in the channel
+	  is_priveleged(user)    - whether a user is on the priveleged list
+	  now()                  - the current unix time, in seconds
+	  state                  - a dict kept between calls, until the script
+	                           file changes
+*/
+
+const HOOK_MESSAGE = "on_message"
+const HOOK_JOIN = "on_join"
+const HOOK_LEAVE = "on_leave"
+
+const _EXT_SCRIPT = ".star"
+
+const _MAX_STEPS = 100000
+const _TIMEOUT = 100 * time.Millisecond
+const _MAX_MESSAGES = 5 /* said or whispered in one call */
+
+/* Thread local holding the Bot a call acts on */
+const _LOCAL_BOT = "bot"
+
+/* Thread local counting the messages a call has sent */
+const _LOCAL_SENT = "sent"
+
+/* What scripts are allowed to do to the channel */
+type Bot interface {
+	Say(message string) error
+	Whisper(user string, message string) error
+	Users() []string
+	IsPriveleged(user string) bool
+}
+
+type _script struct {
+	path    string
+	modTime time.Time
+	globals starlark.StringDict
+	state   *starlark.Dict
+}
+
+type Engine struct {
+	Printf func(string, ...interface{})
+
+	dir     string
+	scripts map[string]*_script /* by file name */
+
+	maxSteps uint64
+	timeout  time.Duration
+}
+
+func New(dir string) *Engine {
+	return &Engine{
+		Printf:   log.Printf,
+		dir:      dir,
+		scripts:  make(map[string]*_script),
+		maxSteps: _MAX_STEPS,
+		timeout:  _TIMEOUT,
+	}
+}
+
+func (e *Engine) Dir() string {
+	return e.dir
+}
+
+/* Names of the scripts that loaded, sorted */
+func (e *Engine) Scripts() []string {
+	var names []string
+	for name, script := range e.scripts {
+		if script.globals != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+/*
+	Load scripts that are new or changed since the last call, and drop
+	scripts whose file is gone. A script that fails to load keeps running
+	its previous version. A missing scripts dir is the same as an empty one.
+*/
+func (e *Engine) Load(bot Bot) {
+	files, err := ioutil.ReadDir(e.dir)
+	if err != nil && !os.IsNotExist(err) {
+		e.Printf("Could not read scripts dir '%s': %v\n", e.dir, err)
+		return
+	}
+
+	seen := make(map[string]interface{})
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.EqualFold(filepath.Ext(name), _EXT_SCRIPT) {
+			continue
+		}
+		seen[name] = nil
+
+		current, ok := e.scripts[name]
+		if ok && current.modTime.Equal(file.ModTime()) {
+			continue
+		}
+
+		script, err := e.load(bot, filepath.Join(e.dir, name), file.ModTime())
+		if err != nil {
+			e.Printf("Could not load script '%s': %v\n", name, err)
+
+			/* Do not retry until the file changes again */
+			if ok {
+				current.modTime = file.ModTime()
+			} else {
+				e.scripts[name] = &_script{modTime: file.ModTime()}
+			}
+			continue
+		}
+
+		if ok {
+			e.Printf("Reloaded script '%s'\n", name)
+		} else {
+			e.Printf("Loaded script '%s'\n", name)
+		}
+		e.scripts[name] = script
+	}
+
+	for name := range e.scripts {
+		if _, ok := seen[name]; !ok {
+			e.Printf("Unloaded script '%s'\n", name)
+			delete(e.scripts, name)
+		}
+	}
+}
+
+func (e *Engine) load(bot Bot, path string, modTime time.Time) (*_script, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	script := &_script{path: path, modTime: modTime, state: starlark.NewDict(0)}
+
+	predeclared := builtins()
+	predeclared["state"] = script.state
+
+	err = e.exec(bot, filepath.Base(path), func(thread *starlark.Thread) error {
+		globals, err := starlark.ExecFile(thread, path, src, predeclared)
+		script.globals = globals
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return script, nil
+}
+
+/* Run `fn` on a fresh thread, with the step limit and timeout applied */
+func (e *Engine) exec(bot Bot, name string, fn func(*starlark.Thread) error) error {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			e.Printf("[script %s] %s\n", name, msg)
+		},
+	}
+	thread.SetLocal(_LOCAL_BOT, bot)
+	thread.SetLocal(_LOCAL_SENT, new(int))
+	thread.SetMaxExecutionSteps(e.maxSteps)
+
+	timer := time.AfterFunc(e.timeout, func() {
+		thread.Cancel(fmt.Sprintf("timed out after %v", e.timeout))
+	})
+	defer timer.Stop()
+
+	return fn(thread)
+}
+
+/*
+	Call `hook` with `args` in every script that defines it. Errors are
+	logged, and do not stop other scripts from running.
+*/
+func (e *Engine) Call(bot Bot, hook string, args ...string) {
+	for _, name := range e.Scripts() {
+		script := e.scripts[name]
+
+		fn, ok := script.globals[hook]
+		if !ok {
+			continue
+		}
+
+		var tuple starlark.Tuple
+		for _, arg := range args {
+			tuple = append(tuple, starlark.String(arg))
+		}
+
+		err := e.exec(bot, name, func(thread *starlark.Thread) error {
+			_, err := starlark.Call(thread, fn, tuple, nil)
+			return err
+		})
+		if err != nil {
+			e.Printf("Script '%s' failed in %s: %v\n", name, hook, err)
+		}
+	}
+}
+
+func threadBot(thread *starlark.Thread) Bot {
+	return thread.Local(_LOCAL_BOT).(Bot)
+}
+
+/* Count a message sent by the call, failing it once it has sent too many */
+func send(thread *starlark.Thread) error {
+	sent := thread.Local(_LOCAL_SENT).(*int)
+	if *sent >= _MAX_MESSAGES {
+		return fmt.Errorf("more than %d messages sent in one call", _MAX_MESSAGES)
+	}
+	*sent++
+
+	return nil
+}
+
+func builtins() starlark.StringDict {
+	return starlark.StringDict{
+		"say":           starlark.NewBuiltin("say", say),
+		"whisper":       starlark.NewBuiltin("whisper", whisper),
+		"users":         starlark.NewBuiltin("users", users),
+		"is_priveleged": starlark.NewBuiltin("is_priveleged", isPriveleged),
+		"now":           starlark.NewBuiltin("now", now),
+	}
+}
+
+func say(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var message string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "message", &message); err != nil {
+		return nil, err
+	}
+
+	if err := send(thread); err != nil {
+		return nil, err
+	}
+
+	return starlark.None, threadBot(thread).Say(message)
+}
+
+func whisper(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var user, message string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "user", &user, "message", &message); err != nil {
+		return nil, err
+	}
+
+	if err := send(thread); err != nil {
+		return nil, err
+	}
+
+	return starlark.None, threadBot(thread).Whisper(user, message)
+}
+
+func users(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+
+	var list []starlark.Value
+	for _, user := range threadBot(thread).Users() {
+		list = append(list, starlark.String(user))
+	}
+
+	return starlark.NewList(list), nil
+}
+
+func isPriveleged(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var user string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "user", &user); err != nil {
+		return nil, err
+	}
+
+	return starlark.Bool(threadBot(thread).IsPriveleged(user)), nil
+}
+
+func now(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+
+	return starlark.MakeInt64(time.Now().Unix()), nil
+}
@@ -0,0 +1,229 @@
+package script
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testBot struct {
+	said      []string
+	whispered []string
+}
+
+func (b *testBot) Say(message string) error {
+	b.said = append(b.said, message)
+	return nil
+}
+
+func (b *testBot) Whisper(user string, message string) error {
+	b.whispered = append(b.whispered, user+": "+message)
+	return nil
+}
+
+func (b *testBot) Users() []string {
+	return []string{"a#Azeroth", "b#USEast"}
+}
+
+func (b *testBot) IsPriveleged(user string) bool {
+	return strings.Compare(user, "a#Azeroth") == 0
+}
+
+func writeScript(t *testing.T, dir string, name string, src string) {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Make sure the change is seen, however coarse the file system clock */
+	stamp := time.Now().Add(time.Duration(len(src)) * time.Second)
+	if err := os.Chtimes(path, stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const _TEST_SCRIPT_REPEAT = `
+def on_message(user, message):
+    if "lfg" not in message.lower():
+        return
+    count = state.get(user, 0) + 1
+    state[user] = count
+    if count == 3:
+        whisper(user, "See the lfg channel")
+`
+
+func TestEngineMessages(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "lfg.star", _TEST_SCRIPT_REPEAT)
+
+	bot := &testBot{}
+	engine := New(dir)
+	engine.Load(bot)
+
+	for i := 0; i < 4; i++ {
+		engine.Call(bot, HOOK_MESSAGE, "b#USEast", "LFG anyone?")
+	}
+	engine.Call(bot, HOOK_MESSAGE, "b#USEast", "hello")
+
+	if len(bot.whispered) != 1 || bot.whispered[0] != "b#USEast: See the lfg channel" {
+		t.Errorf("Unexpected whispers: %v", bot.whispered)
+	}
+}
+
+func TestEngineBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "join.star", `
+def on_join(user):
+    if not is_priveleged(user) and now() > 0:
+        say("Welcome %s, %d here" % (user, len(users())))
+`)
+
+	bot := &testBot{}
+	engine := New(dir)
+	engine.Load(bot)
+
+	engine.Call(bot, HOOK_JOIN, "a#Azeroth")
+	engine.Call(bot, HOOK_JOIN, "b#USEast")
+	engine.Call(bot, HOOK_LEAVE, "b#USEast")
+
+	if len(bot.said) != 1 || bot.said[0] != "Welcome b#USEast, 2 here" {
+		t.Errorf("Unexpected messages: %v", bot.said)
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "a.star", `def on_join(user): say("one")`)
+	writeScript(t, dir, "notes.txt", `not a script`)
+
+	bot := &testBot{}
+	engine := New(dir)
+	engine.Load(bot)
+
+	if scripts := engine.Scripts(); len(scripts) != 1 || scripts[0] != "a.star" {
+		t.Fatalf("Unexpected scripts: %v", scripts)
+	}
+
+	/* A broken edit keeps the old version running */
+	writeScript(t, dir, "a.star", `def on_join(user) say("two")`)
+	engine.Load(bot)
+	engine.Call(bot, HOOK_JOIN, "b#USEast")
+
+	writeScript(t, dir, "a.star", `def on_join(user): say("three")`)
+	engine.Load(bot)
+	engine.Call(bot, HOOK_JOIN, "b#USEast")
+
+	if strings.Join(bot.said, ",") != "one,three" {
+		t.Errorf("Unexpected messages: %v", bot.said)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.star")); err != nil {
+		t.Fatal(err)
+	}
+	engine.Load(bot)
+
+	if len(engine.Scripts()) != 0 {
+		t.Errorf("Removed script should be unloaded: %v", engine.Scripts())
+	}
+}
+
+func TestEngineLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "busy.star", `
+def on_message(user, message):
+    for i in range(1000000000):
+        pass
+    say("done")
+`)
+	writeScript(t, dir, "ok.star", `def on_message(user, message): say("ok")`)
+
+	bot := &testBot{}
+	engine := New(dir)
+	engine.Load(bot)
+
+	start := time.Now()
+	engine.Call(bot, HOOK_MESSAGE, "b#USEast", "hi")
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Script should have been cut off, but ran for %v", elapsed)
+	}
+
+	/* One runaway script does not stop the others */
+	if strings.Join(bot.said, ",") != "ok" {
+		t.Errorf("Unexpected messages: %v", bot.said)
+	}
+}
+
+func TestEngineMessageLimit(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "flood.star", `
+def on_join(user):
+    for i in range(1000):
+        say("hi")
+        whisper(user, "hi")
+`)
+
+	bot := &testBot{}
+	engine := New(dir)
+	engine.Load(bot)
+
+	/* The limit is per call, so the next join may send again */
+	for i := 0; i < 2; i++ {
+		engine.Call(bot, HOOK_JOIN, "b#USEast")
+	}
+
+	if sent := len(bot.said) + len(bot.whispered); sent != 2*_MAX_MESSAGES {
+		t.Errorf("Expected: %d messages, Actual: %d", 2*_MAX_MESSAGES, sent)
+	}
+}
+
+func TestEngineTimeout(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "busy.star", `
+def on_message(user, message):
+    for i in range(1000000000):
+        pass
+`)
+
+	bot := &testBot{}
+	engine := New(dir)
+	engine.maxSteps = 0 /* no step limit */
+	engine.timeout = 10 * time.Millisecond
+	engine.Load(bot)
+
+	done := make(chan struct{})
+	go func() {
+		engine.Call(bot, HOOK_MESSAGE, "b#USEast", "hi")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Script should have been cut off by the timeout")
+	}
+}
+
+func TestEngineSandbox(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "load.star", `load("os.star", "system")`)
+
+	engine := New(dir)
+	engine.Load(&testBot{})
+
+	if len(engine.Scripts()) != 0 {
+		t.Errorf("Scripts should not be able to load modules: %v", engine.Scripts())
+	}
+}
+
+func TestEngineMissingDir(t *testing.T) {
+	engine := New(filepath.Join(t.TempDir(), "missing"))
+	engine.Load(&testBot{})
+
+	if len(engine.Scripts()) != 0 {
+		t.Errorf("Unexpected scripts: %v", engine.Scripts())
+	}
+}
@@ -0,0 +1,223 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+	Recordings of a bot's websocket session, for reproducing bugs. A session
+	file has one json object per line for every frame read from, or request
+	written to, the server:
+
+	{"time": "2021-03-01T20:04:05.123Z", "dir": "in", "data": {"command": ...}}
+
+	Api keys are redacted before anything is written.
+*/
+
+const DIR_IN = "in"
+const DIR_OUT = "out"
+
+const REDACTED = "REDACTED"
+
+/* Fields whose values are always redacted, wherever they are */
+var _REDACTED_KEYS = map[string]interface{}{
+	"api_key": nil,
+}
+
+const _MAX_LINE = 1024 * 1024
+
+type Frame struct {
+	Time time.Time       `json:"time"`
+	Dir  string          `json:"dir"`
+	Data json.RawMessage `json:"data"`
+}
+
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	secrets []string
+
+	now func() time.Time
+}
+
+/*
+	Record to a new file at `path`, replacing any that is there. `secrets`
+	are redacted wherever they appear, on top of known api key fields.
+*/
+func NewRecorder(path string, secrets ...string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonEmpty []string
+	for _, secret := range secrets {
+		if len(secret) > 0 {
+			nonEmpty = append(nonEmpty, secret)
+		}
+	}
+
+	return &Recorder{file: file, secrets: nonEmpty, now: time.Now}, nil
+}
+
+/* Record a frame read from the server */
+func (r *Recorder) Inbound(data []byte) error {
+	return r.write(DIR_IN, data)
+}
+
+/* Record a request written to the server */
+func (r *Recorder) Outbound(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return r.write(DIR_OUT, data)
+}
+
+func (r *Recorder) write(dir string, data []byte) error {
+	frame := Frame{Dir: dir, Data: redact(data, r.secrets...)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	frame.Time = r.now()
+	line, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+/*
+	Returns `data` with api key fields and `secrets` replaced. Frames that
+	are not json are kept as a json string, so the file stays readable.
+*/
+func redact(data []byte, secrets ...string) json.RawMessage {
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&decoded); err != nil {
+		data, _ = json.Marshal(string(data))
+	} else if redactKeys(decoded) {
+		/* Only re-encode when needed, so frames keep their field order */
+		data, _ = json.Marshal(decoded)
+	}
+
+	for _, secret := range secrets {
+		data = bytes.Replace(data, []byte(secret), []byte(REDACTED), -1)
+	}
+
+	return json.RawMessage(data)
+}
+
+/* Returns true if anything was redacted */
+func redactKeys(v interface{}) bool {
+	redacted := false
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if _, ok := _REDACTED_KEYS[key]; ok {
+				v[key] = REDACTED
+				redacted = true
+				continue
+			}
+			redacted = redactKeys(value) || redacted
+		}
+	case []interface{}:
+		for _, value := range v {
+			redacted = redactKeys(value) || redacted
+		}
+	}
+
+	return redacted
+}
+
+func Read(path string) ([]Frame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var frames []Frame
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), _MAX_LINE)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var frame Frame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, fmt.Errorf("Line %d of '%s' is not a frame: %v", line, path, err)
+		}
+		if frame.Dir != DIR_IN && frame.Dir != DIR_OUT {
+			return nil, fmt.Errorf("Line %d of '%s' has unknown direction '%s'.",
+				line, path, frame.Dir)
+		}
+
+		frames = append(frames, frame)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return frames, nil
+}
+
+/* An inbound frame, and the requests the bot sent before the next one */
+type Step struct {
+	In  Frame
+	Out []Frame
+}
+
+/*
+	Group frames by the inbound frame they followed. Requests sent before
+	the first inbound frame, i.e. logging in, are left out.
+*/
+func Steps(frames []Frame) []Step {
+	var steps []Step
+
+	for _, frame := range frames {
+		switch frame.Dir {
+		case DIR_IN:
+			steps = append(steps, Step{In: frame})
+		case DIR_OUT:
+			if len(steps) > 0 {
+				steps[len(steps)-1].Out = append(steps[len(steps)-1].Out, frame)
+			}
+		}
+	}
+
+	return steps
+}
+
+/* Whether two frames carry the same json, regardless of whitespace */
+func SameData(a json.RawMessage, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
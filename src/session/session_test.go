@@ -0,0 +1,101 @@
+package session
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedact(t *testing.T) {
+	for _, test := range []struct {
+		data     string
+		expected string
+	}{
+		{`{"command":"Botapiauth.AuthenticateRequest","payload":{"api_key":"abc"}}`,
+			`{"command":"Botapiauth.AuthenticateRequest","payload":{"api_key":"REDACTED"}}`},
+		/* Field order is kept when nothing is redacted */
+		{`{"payload":{"message":"hi"},"command":"x"}`, `{"payload":{"message":"hi"},"command":"x"}`},
+		{`{"payload":{"message":"my key is secret-token"}}`, `{"payload":{"message":"my key is REDACTED"}}`},
+		{`[{"api_key":1}]`, `[{"api_key":"REDACTED"}]`},
+		{`not json`, `"not json"`},
+	} {
+		actual := redact([]byte(test.data), "secret-token")
+		if string(actual) != test.expected {
+			t.Errorf("Expected: %s, Actual: %s", test.expected, actual)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	recorder, err := NewRecorder(path, "secret-token", "")
+	if err != nil {
+		t.Fatalf("Could not create recorder: %v", err)
+	}
+	start := time.Date(2021, 3, 1, 20, 4, 5, 0, time.UTC)
+	recorder.now = func() time.Time { return start }
+
+	_ = recorder.Outbound(map[string]interface{}{
+		"command": "Botapiauth.AuthenticateRequest",
+		"payload": map[string]string{"api_key": "secret-token"},
+	})
+	_ = recorder.Inbound([]byte(`{"command": "Botapichat.ConnectEventRequest", "request_id": 1}`))
+	_ = recorder.Outbound(struct {
+		Command string `json:"command"`
+	}{"Botapichat.ConnectRequest"})
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Could not close recorder: %v", err)
+	}
+
+	contents, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(contents), "secret-token") {
+		t.Errorf("Expected: api key redacted, Actual: %s", contents)
+	}
+
+	frames, err := Read(path)
+	if err != nil {
+		t.Fatalf("Could not read session: %v", err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("Expected: 3 frames, Actual: %d", len(frames))
+	}
+	if frames[1].Dir != DIR_IN || !frames[1].Time.Equal(start) {
+		t.Errorf("Expected: inbound frame at %v, Actual: %+v", start, frames[1])
+	}
+	if !SameData(frames[1].Data, json.RawMessage(`{"command":"Botapichat.ConnectEventRequest","request_id":1}`)) {
+		t.Errorf("Expected: connect event, Actual: %s", frames[1].Data)
+	}
+
+	/* The login request comes before anything is read, so is left out */
+	steps := Steps(frames)
+	if len(steps) != 1 || len(steps[0].Out) != 1 {
+		t.Fatalf("Expected: 1 step with 1 request, Actual: %+v", steps)
+	}
+	if !SameData(steps[0].Out[0].Data, json.RawMessage(`{"command": "Botapichat.ConnectRequest"}`)) {
+		t.Errorf("Expected: connect request, Actual: %s", steps[0].Out[0].Data)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, contents := range map[string]string{
+		"garbage.jsonl":   "{\"dir\": \"in\", \"data\": {}}\nnot a frame\n",
+		"direction.jsonl": "{\"dir\": \"sideways\", \"data\": {}}\n",
+	} {
+		path := filepath.Join(dir, name)
+		_ = ioutil.WriteFile(path, []byte(contents), 0600)
+
+		if _, err := Read(path); err == nil {
+			t.Errorf("Expected: error reading %s, Actual: nil", name)
+		}
+	}
+
+	if _, err := Read(filepath.Join(dir, "missing.jsonl")); err == nil {
+		t.Errorf("Expected: error reading a missing file, Actual: nil")
+	}
+}
@@ -0,0 +1,548 @@
+package tui
+
+import (
+	"fmt"
+	"log"
+	"peonbot/peonbot"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+/*
+	A full-screen terminal UI. Log output scrolls by in a chat pane, the
+	members of each bot's channel are listed on the right, and a status bar
+	shows how each bot is doing. Console input is read from an input line
+	with history and tab completion, and routed like lines from stdin.
+*/
+
+/* What the UI needs from the bots it is showing */
+type Group interface {
+	Snapshots() []peonbot.Snapshot
+	Input(line string) error
+}
+
+const _MAX_LINES = 1000
+const _MAX_HISTORY = 100
+
+const _MEMBERS_WIDTH = 24
+const _MIN_WIDTH_FOR_MEMBERS = 60 /* narrower screens only show chat */
+
+const _REFRESH_INTERVAL = time.Second
+const _INPUT_QUEUE = 16
+
+const _PROMPT = "> "
+
+type UI struct {
+	Printf func(string, ...interface{})
+
+	screen   tcell.Screen
+	group    Group
+	commands []string
+
+	mu        sync.Mutex /* guards what is written from other goroutines */
+	lines     []string
+	partial   string /* output not terminated by a newline yet */
+	snapshots []peonbot.Snapshot
+
+	/* Only touched by the goroutine running the UI */
+	scroll  int /* rows scrolled back from the bottom of the chat pane */
+	input   []rune
+	cursor  int
+	history []string
+	recall  int      /* history entry being shown, len(history) if none */
+	matches []string /* completions cycled through by repeated tabs */
+	match   int
+	word    int /* where the word being completed starts */
+
+	chin chan string
+	done chan struct{}
+	stop sync.Once
+
+	refresh time.Duration
+}
+
+/* `commands` are offered when completing a word starting with "/" */
+func New(screen tcell.Screen, group Group, commands []string) *UI {
+	sorted := append([]string{}, commands...)
+	sort.Strings(sorted)
+
+	return &UI{
+		Printf:   log.Printf,
+		screen:   screen,
+		group:    group,
+		commands: sorted,
+		chin:     make(chan string, _INPUT_QUEUE),
+		done:     make(chan struct{}),
+		refresh:  _REFRESH_INTERVAL,
+	}
+}
+
+/*
+	Add output to the chat pane. Lets the UI stand in for the log output,
+	e.g. `log.SetOutput(ui)`. Safe to call from any goroutine.
+*/
+func (ui *UI) Write(p []byte) (int, error) {
+	ui.mu.Lock()
+	text := ui.partial + strings.Replace(string(p), "\r", "", -1)
+	lines := strings.Split(text, "\n")
+	ui.partial = lines[len(lines)-1]
+	ui.lines = append(ui.lines, lines[:len(lines)-1]...)
+	if len(ui.lines) > _MAX_LINES {
+		ui.lines = ui.lines[len(ui.lines)-_MAX_LINES:]
+	}
+	ui.mu.Unlock()
+
+	ui.wake()
+
+	return len(p), nil
+}
+
+/* Redraw from the goroutine running the UI */
+func (ui *UI) wake() {
+	_ = ui.screen.PostEvent(tcell.NewEventInterrupt(nil))
+}
+
+/* Run the UI until it is quit with ctrl-c, or stopped */
+func (ui *UI) Run() error {
+	if err := ui.screen.Init(); err != nil {
+		return err
+	}
+	defer ui.Stop()
+
+	go ui.refreshSnapshots()
+	go ui.sendInput()
+
+	ui.draw()
+	for {
+		ev := ui.screen.PollEvent()
+		if ev == nil {
+			return nil
+		}
+
+		switch ev := ev.(type) {
+		case *tcell.EventKey:
+			if !ui.handleKey(ev) {
+				return nil
+			}
+		case *tcell.EventResize:
+			ui.screen.Sync()
+		}
+
+		ui.draw()
+	}
+}
+
+/* Restore the terminal. Safe to call more than once. */
+func (ui *UI) Stop() {
+	ui.stop.Do(func() {
+		close(ui.done)
+		ui.screen.Fini()
+	})
+}
+
+func (ui *UI) refreshSnapshots() {
+	ticker := time.NewTicker(ui.refresh)
+	defer ticker.Stop()
+
+	for {
+		snapshots := ui.group.Snapshots()
+
+		ui.mu.Lock()
+		ui.snapshots = snapshots
+		ui.mu.Unlock()
+		ui.wake()
+
+		select {
+		case <-ui.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/* Input is handed to the bots off the UI goroutine, as it can block */
+func (ui *UI) sendInput() {
+	for {
+		select {
+		case <-ui.done:
+			return
+		case line := <-ui.chin:
+			if err := ui.group.Input(line); err != nil {
+				ui.Printf("%v\n", err)
+			}
+		}
+	}
+}
+
+/* Returns false to quit */
+func (ui *UI) handleKey(ev *tcell.EventKey) bool {
+	if ev.Key() != tcell.KeyTab {
+		ui.matches = nil
+	}
+
+	switch ev.Key() {
+	case tcell.KeyCtrlC:
+		return false
+	case tcell.KeyEnter:
+		ui.submit()
+	case tcell.KeyRune:
+		ui.insert(ev.Rune())
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if ui.cursor > 0 {
+			ui.input = append(ui.input[:ui.cursor-1], ui.input[ui.cursor:]...)
+			ui.cursor--
+		}
+	case tcell.KeyDelete:
+		if ui.cursor < len(ui.input) {
+			ui.input = append(ui.input[:ui.cursor], ui.input[ui.cursor+1:]...)
+		}
+	case tcell.KeyLeft:
+		if ui.cursor > 0 {
+			ui.cursor--
+		}
+	case tcell.KeyRight:
+		if ui.cursor < len(ui.input) {
+			ui.cursor++
+		}
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		ui.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		ui.cursor = len(ui.input)
+	case tcell.KeyUp:
+		ui.recallHistory(-1)
+	case tcell.KeyDown:
+		ui.recallHistory(1)
+	case tcell.KeyPgUp:
+		ui.scroll += ui.chatHeight() / 2
+	case tcell.KeyPgDn:
+		ui.scroll -= ui.chatHeight() / 2
+	case tcell.KeyTab:
+		ui.complete()
+	case tcell.KeyCtrlL:
+		ui.screen.Sync()
+	}
+
+	return true
+}
+
+func (ui *UI) insert(r rune) {
+	ui.input = append(ui.input, 0)
+	copy(ui.input[ui.cursor+1:], ui.input[ui.cursor:])
+	ui.input[ui.cursor] = r
+	ui.cursor++
+}
+
+func (ui *UI) submit() {
+	line := strings.TrimSpace(string(ui.input))
+	ui.input = nil
+	ui.cursor = 0
+
+	if len(line) == 0 {
+		ui.recall = len(ui.history)
+		return
+	}
+
+	if len(ui.history) == 0 || ui.history[len(ui.history)-1] != line {
+		ui.history = append(ui.history, line)
+		if len(ui.history) > _MAX_HISTORY {
+			ui.history = ui.history[len(ui.history)-_MAX_HISTORY:]
+		}
+	}
+	ui.recall = len(ui.history)
+
+	/* Echo the line, and jump back to the latest output */
+	_, _ = ui.Write([]byte(_PROMPT + line + "\n"))
+	ui.scroll = 0
+
+	select {
+	case ui.chin <- line:
+	default:
+		ui.Printf("Not accepting input. Dropped: %s\n", line)
+	}
+}
+
+func (ui *UI) recallHistory(delta int) {
+	recall := ui.recall + delta
+	if recall < 0 || recall > len(ui.history) {
+		return
+	}
+	ui.recall = recall
+
+	ui.input = nil
+	if recall < len(ui.history) {
+		ui.input = []rune(ui.history[recall])
+	}
+	ui.cursor = len(ui.input)
+}
+
+/*
+	Complete the word before the cursor. Repeated tabs cycle through the
+	matches.
+*/
+func (ui *UI) complete() {
+	if ui.matches == nil {
+		start := ui.cursor
+		for start > 0 && ui.input[start-1] != ' ' {
+			start--
+		}
+
+		matches := ui.candidates(string(ui.input[:start]),
+			string(ui.input[start:ui.cursor]))
+		if len(matches) == 0 {
+			return
+		}
+
+		ui.matches = matches
+		ui.match = 0
+		ui.word = start
+	} else {
+		ui.match = (ui.match + 1) % len(ui.matches)
+	}
+
+	completed := []rune(ui.matches[ui.match] + " ")
+	input := append([]rune{}, ui.input[:ui.word]...)
+	input = append(input, completed...)
+	ui.input = append(input, ui.input[ui.cursor:]...)
+	ui.cursor = ui.word + len(completed)
+}
+
+/*
+	Commands complete where a command can go: first on the line, or after
+	the bot the line is addressed to, e.g. `@clan /kick`. Bots complete at
+	the start of the line, and members everywhere else.
+*/
+func (ui *UI) candidates(before string, word string) []string {
+	fields := strings.Fields(before)
+	first := len(fields) == 0
+	command := first || (len(fields) == 1 && strings.HasPrefix(fields[0], "@"))
+
+	ui.mu.Lock()
+	snapshots := ui.snapshots
+	ui.mu.Unlock()
+
+	var options []string
+	switch {
+	case command && strings.HasPrefix(word, "/"):
+		options = ui.commands
+	case first && strings.HasPrefix(word, "@"):
+		options = append(options, "@all")
+		for _, snapshot := range snapshots {
+			if len(snapshot.Name) > 0 {
+				options = append(options, "@"+snapshot.Name)
+			}
+		}
+	default:
+		seen := make(map[string]interface{})
+		for _, snapshot := range snapshots {
+			for _, member := range snapshot.Members {
+				if _, ok := seen[strings.ToUpper(member.Name)]; !ok {
+					seen[strings.ToUpper(member.Name)] = nil
+					options = append(options, member.Name)
+				}
+			}
+		}
+	}
+
+	var matches []string
+	for _, option := range options {
+		if strings.HasPrefix(strings.ToUpper(option), strings.ToUpper(word)) {
+			matches = append(matches, option)
+		}
+	}
+	sort.Strings(matches)
+
+	return matches
+}
+
+func (ui *UI) chatHeight() int {
+	_, height := ui.screen.Size()
+	return height - 2
+}
+
+func (ui *UI) draw() {
+	ui.mu.Lock()
+	lines := append([]string{}, ui.lines...)
+	if len(ui.partial) > 0 {
+		lines = append(lines, ui.partial)
+	}
+	snapshots := ui.snapshots
+	ui.mu.Unlock()
+
+	ui.screen.Clear()
+	width, height := ui.screen.Size()
+
+	chatWidth := width
+	if width >= _MIN_WIDTH_FOR_MEMBERS {
+		chatWidth = width - _MEMBERS_WIDTH - 1
+		for y := 0; y < height-2; y++ {
+			ui.screen.SetContent(chatWidth, y, tcell.RuneVLine, nil,
+				tcell.StyleDefault)
+		}
+		ui.drawMembers(chatWidth+1, _MEMBERS_WIDTH, height-2, snapshots)
+	}
+
+	ui.drawChat(chatWidth, height-2, lines)
+	ui.drawStatus(height-2, width, snapshots)
+	ui.drawInput(height-1, width)
+
+	ui.screen.Show()
+}
+
+func (ui *UI) drawChat(width int, height int, lines []string) {
+	var rows []string
+	for _, line := range lines {
+		rows = append(rows, wrap(line, width)...)
+	}
+
+	if ui.scroll > len(rows)-height {
+		ui.scroll = len(rows) - height
+	}
+	if ui.scroll < 0 {
+		ui.scroll = 0
+	}
+
+	end := len(rows) - ui.scroll
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+
+	/* Latest output sits at the bottom of the pane */
+	y := height - (end - start)
+	for _, row := range rows[start:end] {
+		puts(ui.screen, 0, y, width, row, tcell.StyleDefault)
+		y++
+	}
+}
+
+func (ui *UI) drawMembers(x int, width int, height int, snapshots []peonbot.Snapshot) {
+	y := 0
+	for _, snapshot := range snapshots {
+		if y >= height {
+			return
+		}
+
+		header := snapshot.Channel
+		if len(snapshots) > 1 {
+			header = strings.TrimSpace(snapshot.Name + " " + snapshot.Channel)
+		}
+		if len(header) > 0 {
+			puts(ui.screen, x, y, width, header, tcell.StyleDefault.Bold(true))
+			y++
+		}
+
+		for _, member := range snapshot.Members {
+			if y >= height {
+				return
+			}
+
+			puts(ui.screen, x, y, width, memberPrefix(member)+member.Name,
+				tcell.StyleDefault)
+			y++
+		}
+	}
+}
+
+/*
+	`@` for moderators and admins, `+` for speakers, and `*` for users
+	priveleged with the bot.
+*/
+func memberPrefix(member peonbot.Member) string {
+	prefix := " "
+	if member.Priveleged {
+		prefix = "*"
+	}
+
+	for _, flag := range member.Flags {
+		switch strings.ToUpper(flag) {
+		case "ADMIN", "MODERATOR":
+			return "@"
+		case "SPEAKER":
+			prefix = "+"
+		}
+	}
+
+	return prefix
+}
+
+func (ui *UI) drawStatus(y int, width int, snapshots []peonbot.Snapshot) {
+	style := tcell.StyleDefault.Reverse(true)
+	for x := 0; x < width; x++ {
+		ui.screen.SetContent(x, y, ' ', nil, style)
+	}
+
+	var parts []string
+	for _, snapshot := range snapshots {
+		parts = append(parts, status(snapshot))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "starting")
+	}
+
+	puts(ui.screen, 0, y, width, " "+strings.Join(parts, " | "), style)
+}
+
+func status(snapshot peonbot.Snapshot) string {
+	var fields []string
+	if len(snapshot.Name) > 0 {
+		fields = append(fields, snapshot.Name+":")
+	}
+	fields = append(fields, snapshot.State)
+	if len(snapshot.Channel) > 0 {
+		fields = append(fields, snapshot.Channel)
+	}
+	fields = append(fields,
+		fmt.Sprintf("users: %d", len(snapshot.Members)),
+		fmt.Sprintf("queue: %d", snapshot.Queue))
+
+	return strings.Join(fields, " ")
+}
+
+func (ui *UI) drawInput(y int, width int) {
+	prompt := len([]rune(_PROMPT))
+	visible := width - prompt
+	if visible < 1 {
+		return
+	}
+
+	/* Scroll long input so the cursor stays on screen */
+	start := 0
+	if ui.cursor >= visible {
+		start = ui.cursor - visible + 1
+	}
+
+	puts(ui.screen, 0, y, prompt, _PROMPT, tcell.StyleDefault)
+	puts(ui.screen, prompt, y, visible, string(ui.input[start:]),
+		tcell.StyleDefault)
+	ui.screen.ShowCursor(prompt+ui.cursor-start, y)
+}
+
+/* Write text on a row, cut off at `width` cells */
+func puts(screen tcell.Screen, x int, y int, width int, text string, style tcell.Style) {
+	for i, r := range []rune(text) {
+		if i >= width {
+			return
+		}
+		screen.SetContent(x+i, y, r, nil, style)
+	}
+}
+
+/* Split a line into rows of at most `width` runes */
+func wrap(line string, width int) []string {
+	runes := []rune(line)
+	if width < 1 || len(runes) <= width {
+		return []string{line}
+	}
+
+	var rows []string
+	for len(runes) > width {
+		rows = append(rows, string(runes[:width]))
+		runes = runes[width:]
+	}
+
+	return append(rows, string(runes))
+}
@@ -0,0 +1,334 @@
+package tui
+
+import (
+	"fmt"
+	"peonbot/peonbot"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+type _fakeGroup struct {
+	mu        sync.Mutex
+	snapshots []peonbot.Snapshot
+	inputs    chan string
+	refreshed chan struct{}
+}
+
+func (g *_fakeGroup) Snapshots() []peonbot.Snapshot {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case g.refreshed <- struct{}{}:
+	default:
+	}
+
+	return g.snapshots
+}
+
+func (g *_fakeGroup) Input(line string) error {
+	if strings.HasPrefix(line, "@nobody") {
+		return fmt.Errorf("No bot named 'nobody'.")
+	}
+
+	g.inputs <- line
+	return nil
+}
+
+func getTestGroup() *_fakeGroup {
+	return &_fakeGroup{
+		snapshots: []peonbot.Snapshot{{
+			State:   peonbot.STATE_CONNECTED,
+			Channel: "Clan Peon",
+			Queue:   2,
+			Members: []peonbot.Member{
+				{Name: "mod#Azeroth", Flags: []string{"Moderator"}},
+				{Name: "priv#Azeroth", Priveleged: true},
+				{Name: "testuser#Azeroth"},
+				{Name: "testuser2#Lordaeron"},
+			},
+		}},
+		inputs:    make(chan string, 16),
+		refreshed: make(chan struct{}, 1),
+	}
+}
+
+func getTestUI(t *testing.T, group *_fakeGroup) (*UI, tcell.SimulationScreen) {
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatalf("Could not start simulated screen: %v", err)
+	}
+	screen.SetSize(80, 10)
+
+	ui := New(screen, group, []string{"/kick", "/ban", "/say"})
+	ui.snapshots = group.Snapshots()
+
+	return ui, screen
+}
+
+func typeKeys(ui *UI, text string) {
+	for _, r := range text {
+		ui.handleKey(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+}
+
+func pressKey(ui *UI, key tcell.Key) {
+	ui.handleKey(tcell.NewEventKey(key, 0, tcell.ModNone))
+}
+
+func getRow(screen tcell.SimulationScreen, y int) string {
+	cells, width, _ := screen.GetContents()
+
+	var row []rune
+	for _, cell := range cells[y*width : (y+1)*width] {
+		if len(cell.Runes) > 0 {
+			row = append(row, cell.Runes[0])
+		} else {
+			row = append(row, ' ')
+		}
+	}
+
+	return string(row)
+}
+
+func getScreen(screen tcell.SimulationScreen) string {
+	_, _, height := screen.GetContents()
+
+	var rows []string
+	for y := 0; y < height; y++ {
+		rows = append(rows, getRow(screen, y))
+	}
+
+	return strings.Join(rows, "\n")
+}
+
+/* Blocks until the UI takes the key, unlike `InjectKey` */
+func injectKeys(screen tcell.SimulationScreen, text string) {
+	for _, r := range text {
+		screen.PostEventWait(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+}
+
+func TestDraw(t *testing.T) {
+	ui, screen := getTestUI(t, getTestGroup())
+	defer ui.Stop()
+
+	_, _ = ui.Write([]byte("[testuser#Azeroth] hello\n"))
+	typeKeys(ui, "hi there")
+	ui.draw()
+
+	contents := getScreen(screen)
+	for _, expected := range []string{
+		"[testuser#Azeroth] hello",
+		"Clan Peon",
+		"@mod#Azeroth",
+		"*priv#Azeroth",
+		" testuser#Azeroth",
+		"connected Clan Peon users: 4 queue: 2",
+		"> hi there",
+	} {
+		if !strings.Contains(contents, expected) {
+			t.Errorf("Expected: %s, Actual: %s", expected, contents)
+		}
+	}
+
+	/* Status bar, then input line */
+	if !strings.Contains(getRow(screen, 8), "queue: 2") {
+		t.Errorf("Expected: status bar on row 8, Actual: %s", getRow(screen, 8))
+	}
+	if x, y, _ := screen.GetCursor(); x != 10 || y != 9 {
+		t.Errorf("Expected: cursor at 10,9, Actual: %d,%d", x, y)
+	}
+}
+
+func TestDrawNarrow(t *testing.T) {
+	ui, screen := getTestUI(t, getTestGroup())
+	defer ui.Stop()
+	screen.SetSize(20, 10)
+
+	_, _ = ui.Write([]byte("a line longer than twenty runes\n"))
+	ui.draw()
+
+	contents := getScreen(screen)
+	if strings.Contains(contents, "mod#Azeroth") {
+		t.Errorf("Expected: no member list, Actual: %s", contents)
+	}
+	if getRow(screen, 6) != "a line longer than t" || !strings.HasPrefix(getRow(screen, 7), "wenty runes") {
+		t.Errorf("Expected: wrapped line, Actual: %s", contents)
+	}
+}
+
+func TestScroll(t *testing.T) {
+	ui, screen := getTestUI(t, getTestGroup())
+	defer ui.Stop()
+
+	for i := 0; i < 20; i++ {
+		_, _ = ui.Write([]byte(fmt.Sprintf("line %d\n", i)))
+	}
+	ui.draw()
+	if !strings.HasPrefix(getRow(screen, 7), "line 19") {
+		t.Errorf("Expected: line 19, Actual: %s", getRow(screen, 7))
+	}
+
+	pressKey(ui, tcell.KeyPgUp)
+	ui.draw()
+	if !strings.HasPrefix(getRow(screen, 7), "line 15") {
+		t.Errorf("Expected: line 15, Actual: %s", getRow(screen, 7))
+	}
+
+	/* Can not scroll past the first line */
+	for i := 0; i < 10; i++ {
+		pressKey(ui, tcell.KeyPgUp)
+	}
+	ui.draw()
+	if !strings.HasPrefix(getRow(screen, 0), "line 0") {
+		t.Errorf("Expected: line 0, Actual: %s", getRow(screen, 0))
+	}
+
+	/* Sending input jumps back to the bottom */
+	typeKeys(ui, "hi")
+	pressKey(ui, tcell.KeyEnter)
+	ui.draw()
+	if !strings.HasPrefix(getRow(screen, 7), "> hi") {
+		t.Errorf("Expected: > hi, Actual: %s", getRow(screen, 7))
+	}
+}
+
+func TestHistory(t *testing.T) {
+	group := getTestGroup()
+	ui, _ := getTestUI(t, group)
+	defer ui.Stop()
+
+	typeKeys(ui, "first")
+	pressKey(ui, tcell.KeyEnter)
+	typeKeys(ui, "second")
+	pressKey(ui, tcell.KeyEnter)
+
+	pressKey(ui, tcell.KeyUp)
+	if string(ui.input) != "second" {
+		t.Errorf("Expected: second, Actual: %s", string(ui.input))
+	}
+	pressKey(ui, tcell.KeyUp)
+	pressKey(ui, tcell.KeyUp)
+	if string(ui.input) != "first" {
+		t.Errorf("Expected: first, Actual: %s", string(ui.input))
+	}
+	pressKey(ui, tcell.KeyDown)
+	pressKey(ui, tcell.KeyDown)
+	if string(ui.input) != "" {
+		t.Errorf("Expected: empty input, Actual: %s", string(ui.input))
+	}
+
+	/* Editing in the middle of the line */
+	typeKeys(ui, "helo")
+	pressKey(ui, tcell.KeyLeft)
+	typeKeys(ui, "l")
+	pressKey(ui, tcell.KeyHome)
+	pressKey(ui, tcell.KeyDelete)
+	if string(ui.input) != "ello" || ui.cursor != 0 {
+		t.Errorf("Expected: ello, Actual: %s", string(ui.input))
+	}
+}
+
+func TestComplete(t *testing.T) {
+	ui, _ := getTestUI(t, getTestGroup())
+	defer ui.Stop()
+
+	typeKeys(ui, "/ki")
+	pressKey(ui, tcell.KeyTab)
+	if string(ui.input) != "/kick " {
+		t.Errorf("Expected: /kick , Actual: %s", string(ui.input))
+	}
+
+	/* Repeated tabs cycle through the matches */
+	typeKeys(ui, "TEST")
+	pressKey(ui, tcell.KeyTab)
+	if string(ui.input) != "/kick testuser#Azeroth " {
+		t.Errorf("Expected: /kick testuser#Azeroth , Actual: %s", string(ui.input))
+	}
+	pressKey(ui, tcell.KeyTab)
+	if string(ui.input) != "/kick testuser2#Lordaeron " {
+		t.Errorf("Expected: /kick testuser2#Lordaeron , Actual: %s", string(ui.input))
+	}
+	pressKey(ui, tcell.KeyTab)
+	if string(ui.input) != "/kick testuser#Azeroth " {
+		t.Errorf("Expected: /kick testuser#Azeroth , Actual: %s", string(ui.input))
+	}
+
+	/* Commands only complete where a command can go */
+	ui.input, ui.cursor = nil, 0
+	typeKeys(ui, "say /k")
+	pressKey(ui, tcell.KeyTab)
+	if string(ui.input) != "say /k" {
+		t.Errorf("Expected: say /k, Actual: %s", string(ui.input))
+	}
+
+	ui.input, ui.cursor = nil, 0
+	typeKeys(ui, "@a")
+	pressKey(ui, tcell.KeyTab)
+	typeKeys(ui, "/b")
+	pressKey(ui, tcell.KeyTab)
+	if string(ui.input) != "@all /ban " {
+		t.Errorf("Expected: @all /ban , Actual: %s", string(ui.input))
+	}
+}
+
+func TestRun(t *testing.T) {
+	group := getTestGroup()
+	screen := tcell.NewSimulationScreen("UTF-8")
+	ui := New(screen, group, nil)
+	ui.Printf = func(msg string, vargs ...interface{}) {
+		_, _ = fmt.Fprintf(ui, msg, vargs...)
+	}
+
+	done := make(chan error)
+	go func() { done <- ui.Run() }()
+
+	/* Snapshots are refreshed once the screen is up */
+	<-group.refreshed
+
+	injectKeys(screen, "hello")
+	screen.PostEventWait(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+
+	select {
+	case line := <-group.inputs:
+		if line != "hello" {
+			t.Errorf("Expected: hello, Actual: %s", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected: input sent to the group, Actual: nothing")
+	}
+
+	/* Errors from routing input are shown in the chat pane */
+	injectKeys(screen, "@nobody hi")
+	screen.PostEventWait(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ui.mu.Lock()
+		lines := strings.Join(ui.lines, "\n")
+		ui.mu.Unlock()
+
+		if strings.Contains(lines, "No bot named 'nobody'.") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected: routing error, Actual: %s", lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	screen.PostEventWait(tcell.NewEventKey(tcell.KeyCtrlC, 0, tcell.ModCtrl))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected: nil, Actual: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected: ctrl-c to quit, Actual: still running")
+	}
+}
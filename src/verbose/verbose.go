@@ -1,15 +1,36 @@
 package verbose
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
 
-var _verbose = false
+/* Toggled from the console while bots are logging, so read atomically */
+var _verbose int32 = 0
+var _output io.Writer = os.Stdout
 
 func SetPrinter(verbose bool) {
-	_verbose = verbose
+	var on int32
+	if verbose {
+		on = 1
+	}
+
+	atomic.StoreInt32(&_verbose, on)
+}
+
+func Enabled() bool {
+	return atomic.LoadInt32(&_verbose) == 1
+}
+
+/* Where debug output goes. Defaults to stdout. */
+func SetOutput(w io.Writer) {
+	_output = w
 }
 
 func Vprintf(msg string, vargs ...interface{}) {
-	if _verbose {
-		fmt.Printf(fmt.Sprintf("[debug] %s", msg), vargs...)
+	if Enabled() {
+		fmt.Fprintf(_output, fmt.Sprintf("[debug] %s", msg), vargs...)
 	}
 }
@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Inbound webhook. Other services POST a json message, which the bot says
+	in its channel:
+	  {"from": "discord", "message": "hello"}
+
+	Every request must be signed with the shared secret. The signature is
+	the hex encoded HMAC-SHA256 of "<timestamp>.<body>", where timestamp is
+	the unix time the request was made:
+	  X-Peonbot-Timestamp: 1571234567
+	  X-Peonbot-Signature: sha256=<hex>
+
+	Requests with a timestamp too far from now are refused, and each
+	signature is only accepted once while its timestamp is fresh, so a
+	captured request cannot be replayed.
+*/
+
+const HEADER_TIMESTAMP = "X-Peonbot-Timestamp"
+const HEADER_SIGNATURE = "X-Peonbot-Signature"
+
+const _SIGNATURE_PREFIX = "sha256="
+const _MAX_SKEW = 5 * time.Minute
+const _MAX_BODY = 4096
+
+type Message struct {
+	From    string `json:"from"`
+	Message string `json:"message"`
+}
+
+/* Signature header value for a request body sent at `timestamp` */
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return _SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+/* Whether the request was signed with `secret`, recently enough */
+func Verify(secret string, r *http.Request, body []byte, now time.Time) bool {
+	timestamp, err := strconv.ParseInt(r.Header.Get(HEADER_TIMESTAMP), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	skew := now.Sub(time.Unix(timestamp, 0))
+	if skew > _MAX_SKEW || skew < -_MAX_SKEW {
+		return false
+	}
+
+	expected := Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get(HEADER_SIGNATURE)))
+}
+
+/*
+	Signatures already accepted. Each is kept until its request could no
+	longer pass `Verify`, at most `_MAX_SKEW` either side of when it was seen.
+*/
+type _seen struct {
+	mu         sync.Mutex
+	signatures map[string]time.Time /* when to forget it */
+}
+
+/* Remember `signature`. Returns false if it was already seen. */
+func (s *_seen) add(signature string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for seen, expires := range s.signatures {
+		if now.After(expires) {
+			delete(s.signatures, seen)
+		}
+	}
+
+	if _, ok := s.signatures[signature]; ok {
+		return false
+	}
+
+	s.signatures[signature] = now.Add(2 * _MAX_SKEW)
+	return true
+}
+
+func (s *_seen) forget(signature string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.signatures, signature)
+}
+
+/*
+	Accept signed messages, and hand them to `messages` without blocking.
+	If nothing is ready to take a message, the request fails with 503 so
+	the sender can retry. A request that was already accepted fails with
+	409.
+*/
+func Handler(secret string, messages chan<- Message) http.Handler {
+	seen := &_seen{signatures: make(map[string]time.Time)}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message Message
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, _MAX_BODY))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		if !Verify(secret, r, body, now) {
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		signature := r.Header.Get(HEADER_SIGNATURE)
+		if !seen.add(signature, now) {
+			http.Error(w, "Already received", http.StatusConflict)
+			return
+		}
+
+		if err := json.Unmarshal(body, &message); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		message.Message = strings.TrimSpace(message.Message)
+		if len(message.Message) == 0 {
+			http.Error(w, "Message must not be empty", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case messages <- message:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			/* Not taken, so the same request may be sent again */
+			seen.forget(signature)
+			http.Error(w, "Busy, try again later", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+/*
+	Serve `Handler` on `addr` at `path`. Blocks like `ListenAndServe`,
+	until `ctx` is done, when it returns nil.
+*/
+func Serve(ctx context.Context, addr string, path string, secret string, messages chan<- Message) error {
+	mux := http.NewServeMux()
+	mux.Handle(path, Handler(secret, messages))
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
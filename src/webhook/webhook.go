@@ -0,0 +1,407 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+/*
+	Outbound webhooks. When something a hook is interested in happens, its
+	payload template is rendered and POSTed to its url. Failed deliveries
+	are retried with backoff, and deliveries that never succeed are written
+	to a dead letter file as json lines, so they can be replayed by hand.
+*/
+
+const EVENT_KICK = "kick"
+const EVENT_BAN = "ban"
+const EVENT_UNBAN = "unban"
+const EVENT_KEYWORD = "keyword"
+const EVENT_JOIN = "join"
+
+var EVENTS = []string{EVENT_KICK, EVENT_BAN, EVENT_UNBAN, EVENT_KEYWORD, EVENT_JOIN}
+
+/* What a payload template is rendered with */
+type Event struct {
+	Event   string    `json:"event"`
+	Bot     string    `json:"bot,omitempty"`
+	Channel string    `json:"channel,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Keyword string    `json:"keyword,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+const _HTTP_TIMEOUT = time.Duration(10) * time.Second
+
+const _ATTEMPTS = 5
+const _MIN_BACKOFF = time.Second
+const _MAX_BACKOFF = time.Minute
+
+/* Deliveries in flight at once. More wait their turn in the queue. */
+const _MAX_DELIVERIES = 8
+
+/* Deliveries waiting for their turn. More are dead lettered straight away. */
+const _MAX_QUEUED = 256
+
+type Hook struct {
+	url      string
+	template *template.Template /* nil sends the event as json */
+}
+
+/*
+	Templates use Go's text/template syntax, and must render to json. The
+	`json` function quotes a value, e.g.
+	  {"content": {{json (printf "%s was banned" .User)}}}
+*/
+func NewHook(rawurl string, payload string) (*Hook, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Webhook url '%s' must be http or https.", u.Redacted())
+	}
+
+	hook := &Hook{url: rawurl}
+	if len(payload) == 0 {
+		return hook, nil
+	}
+
+	hook.template, err = template.New("payload").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			raw, err := json.Marshal(v)
+			return string(raw), err
+		},
+	}).Option("missingkey=error").Parse(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Catch templates that can never render to json up front */
+	if _, err := hook.Render(Event{Event: EVENT_JOIN, Time: time.Now()}); err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+/* Webhook urls often carry a token, so only show where they point */
+func (h *Hook) String() string {
+	u, err := url.Parse(h.url)
+	if err != nil {
+		return "<invalid url>"
+	}
+
+	return u.Scheme + "://" + u.Host + "/..."
+}
+
+func (h *Hook) Render(event Event) ([]byte, error) {
+	if h.template == nil {
+		return json.Marshal(event)
+	}
+
+	var buf bytes.Buffer
+	if err := h.template.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("Webhook template did not render to json: %s", buf.String())
+	}
+
+	return buf.Bytes(), nil
+}
+
+type _deadLetter struct {
+	Time    time.Time       `json:"time"`
+	Url     string          `json:"url"`
+	Payload json.RawMessage `json:"payload"`
+	Error   string          `json:"error"`
+}
+
+type _delivery struct {
+	hook    *Hook
+	event   string
+	payload []byte
+}
+
+type Sender struct {
+	Printf func(string, ...interface{})
+
+	deadLetter string
+	client     *http.Client
+	queue      chan _delivery
+	ctx        context.Context
+	cancel     context.CancelFunc
+	workers    sync.WaitGroup
+	wg         sync.WaitGroup
+	pending    int32      /* deliveries not yet finished, read atomically */
+	qmu        sync.Mutex /* guards closed, so nothing is queued after Close */
+	closed     bool
+	mu         sync.Mutex /* guards the dead letter file */
+
+	attempts   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+/* Deliveries that fail for good are appended to `deadLetter` */
+func NewSender(deadLetter string) *Sender {
+	return newSender(deadLetter, _MAX_DELIVERIES, _MAX_QUEUED)
+}
+
+func newSender(deadLetter string, workers int, queued int) *Sender {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sender{
+		Printf:     log.Printf,
+		deadLetter: deadLetter,
+		client:     &http.Client{Timeout: _HTTP_TIMEOUT},
+		queue:      make(chan _delivery, queued),
+		ctx:        ctx,
+		cancel:     cancel,
+		attempts:   _ATTEMPTS,
+		minBackoff: _MIN_BACKOFF,
+		maxBackoff: _MAX_BACKOFF,
+	}
+
+	s.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+
+	return s
+}
+
+/*
+	Render the payload, and queue it to be delivered in the background. If
+	the queue is full, or the sender is closed, the payload is dead lettered
+	instead and an error returned.
+*/
+func (s *Sender) Send(hook *Hook, event Event) error {
+	payload, err := hook.Render(event)
+	if err != nil {
+		return err
+	}
+
+	if err := s.enqueue(_delivery{hook: hook, event: event.Event, payload: payload}); err != nil {
+		s.writeDeadLetter(hook, payload, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Sender) enqueue(d _delivery) error {
+	s.qmu.Lock()
+	defer s.qmu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("Sender is closed")
+	}
+
+	s.wg.Add(1)
+	atomic.AddInt32(&s.pending, 1)
+	select {
+	case s.queue <- d:
+		return nil
+	default:
+		s.finish()
+		return fmt.Errorf("Queue is full, %d deliveries waiting", cap(s.queue))
+	}
+}
+
+func (s *Sender) work() {
+	defer s.workers.Done()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case d := <-s.queue:
+			s.run(d)
+		}
+	}
+}
+
+func (s *Sender) run(d _delivery) {
+	defer s.finish()
+
+	if err := s.deliver(d.hook, d.payload); err != nil {
+		s.Printf("Could not deliver %s webhook to %s: %v\n", d.event, d.hook, err)
+		s.writeDeadLetter(d.hook, d.payload, err)
+	}
+}
+
+func (s *Sender) finish() {
+	atomic.AddInt32(&s.pending, -1)
+	s.wg.Done()
+}
+
+/* Deliveries queued or in flight, including their retries */
+func (s *Sender) Pending() int {
+	return int(atomic.LoadInt32(&s.pending))
+}
+
+/* Wait for deliveries queued or in flight, including their retries */
+func (s *Sender) Wait() {
+	s.wg.Wait()
+}
+
+/*
+	Stop sending. Deliveries in flight are cut short, and they and every
+	delivery still queued are dead lettered, so nothing is lost on shutdown.
+*/
+func (s *Sender) Close() {
+	s.qmu.Lock()
+	if s.closed {
+		s.qmu.Unlock()
+		return
+	}
+	s.closed = true
+	s.qmu.Unlock()
+
+	s.cancel()
+	s.workers.Wait()
+
+	/* Nothing more can be queued, and deliver gives up at once */
+	for {
+		select {
+		case d := <-s.queue:
+			s.run(d)
+		default:
+			return
+		}
+	}
+}
+
+/* Errors that are worth retrying */
+type _retryable struct {
+	err   error
+	after time.Duration /* from Retry-After, if the server sent one */
+}
+
+func (e *_retryable) Error() string {
+	return e.err.Error()
+}
+
+func (s *Sender) deliver(hook *Hook, payload []byte) error {
+	var err error
+	backoff := s.minBackoff
+
+	for attempt := 1; attempt <= s.attempts; attempt++ {
+		if s.ctx.Err() != nil {
+			return fmt.Errorf("Sender closed before delivery")
+		}
+
+		err = s.post(hook, payload)
+		retry, ok := err.(*_retryable)
+		if !ok {
+			return err
+		}
+
+		if attempt == s.attempts {
+			break
+		}
+
+		wait := backoff
+		if retry.after > wait {
+			wait = retry.after
+		}
+		if wait > s.maxBackoff {
+			wait = s.maxBackoff
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("Sender closed after %d attempts: %v", attempt, err)
+		}
+		backoff *= 2
+	}
+
+	return fmt.Errorf("Gave up after %d attempts: %v", s.attempts, err)
+}
+
+func (s *Sender) post(hook *Hook, payload []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, hook.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		/* Drop the url from the error, it may hold a token */
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return &_retryable{err: err}
+	}
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		retry := &_retryable{err: fmt.Errorf("Server responded: %s", resp.Status)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retry.after = time.Duration(seconds) * time.Second
+		}
+		return retry
+	default:
+		/* The request itself is wrong, so retrying will not help */
+		return fmt.Errorf("Server responded: %s", resp.Status)
+	}
+}
+
+func (s *Sender) writeDeadLetter(hook *Hook, payload []byte, cause error) {
+	if len(s.deadLetter) == 0 {
+		return
+	}
+
+	line, err := json.Marshal(_deadLetter{
+		Time:    time.Now(),
+		Url:     hook.url,
+		Payload: payload,
+		Error:   cause.Error(),
+	})
+	if err != nil {
+		s.Printf("Could not write webhook dead letter: %v\n", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.deadLetter), 0755); err != nil {
+		s.Printf("Could not write webhook dead letter: %v\n", err)
+		return
+	}
+
+	file, err := os.OpenFile(s.deadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		s.Printf("Could not write webhook dead letter: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		s.Printf("Could not write webhook dead letter: %v\n", err)
+	}
+}
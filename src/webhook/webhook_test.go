@@ -0,0 +1,339 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* Responds with each status in turn, then 200, and records every body */
+type testReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bodies = append(r.bodies, string(body))
+	if len(r.statuses) > 0 {
+		w.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+	}
+}
+
+func getTestSender(t *testing.T) *Sender {
+	sender := NewSender(filepath.Join(t.TempDir(), "dead.jsonl"))
+	sender.minBackoff = time.Millisecond
+	sender.maxBackoff = 10 * time.Millisecond
+
+	return sender
+}
+
+func readDeadLetters(t *testing.T, sender *Sender) []_deadLetter {
+	var letters []_deadLetter
+
+	raw, err := ioutil.ReadFile(sender.deadLetter)
+	if err != nil {
+		return nil
+	}
+
+	for _, line := range bytes.Split(bytes.TrimSpace(raw), []byte("\n")) {
+		var letter _deadLetter
+		if err := json.Unmarshal(line, &letter); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters
+}
+
+func TestHookTemplate(t *testing.T) {
+	receiver := &testReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook, err := NewHook(server.URL, `{"content": {{json (printf "%s was %sned" .User .Event)}}}`)
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	sender := getTestSender(t)
+	if err := sender.Send(hook, Event{Event: EVENT_BAN, User: `Troll"#Azeroth`}); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	sender.Wait()
+
+	expected := `{"content": "Troll\"#Azeroth was banned"}`
+	if len(receiver.bodies) != 1 || receiver.bodies[0] != expected {
+		t.Errorf("Expected: %s, Actual: %v", expected, receiver.bodies)
+	}
+}
+
+func TestHookDefaultPayload(t *testing.T) {
+	hook, err := NewHook("https://example.com/hook", "")
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	raw, err := hook.Render(Event{Event: EVENT_KEYWORD, User: "a#Azeroth", Keyword: "hack"})
+	if err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+
+	if !strings.Contains(string(raw), `"keyword":"hack"`) {
+		t.Errorf("Unexpected payload: %s", raw)
+	}
+}
+
+func TestHookInvalid(t *testing.T) {
+	for _, hook := range [][2]string{
+		{"ftp://example.com/hook", ""},
+		{"https://example.com/hook", `{"content": {{.Nope}}}`},
+		{"https://example.com/hook", `content: {{.User}}`},
+	} {
+		if _, err := NewHook(hook[0], hook[1]); err == nil {
+			t.Errorf("Expected an error for %v, but got nil.", hook)
+		}
+	}
+}
+
+func TestHookString(t *testing.T) {
+	hook, _ := NewHook("https://discord.com/api/webhooks/1/secret-token", "")
+	if strings.Contains(hook.String(), "secret-token") {
+		t.Errorf("Hook should not show its token: %s", hook)
+	}
+}
+
+func TestSenderRetry(t *testing.T) {
+	receiver := &testReceiver{statuses: []int{500, http.StatusTooManyRequests}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook, _ := NewHook(server.URL, "")
+	sender := getTestSender(t)
+	_ = sender.Send(hook, Event{Event: EVENT_KICK})
+	sender.Wait()
+
+	if len(receiver.bodies) != 3 {
+		t.Errorf("Expected: %d attempts, Actual: %d", 3, len(receiver.bodies))
+	}
+
+	if letters := readDeadLetters(t, sender); len(letters) != 0 {
+		t.Errorf("Delivered payload should not be dead lettered: %+v", letters)
+	}
+}
+
+func TestSenderDeadLetter(t *testing.T) {
+	receiver := &testReceiver{statuses: []int{500, 500, 500, 500, 500, http.StatusBadRequest}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook, _ := NewHook(server.URL, "")
+	sender := getTestSender(t)
+
+	/* Gives up after every attempt failed */
+	_ = sender.Send(hook, Event{Event: EVENT_KICK})
+	sender.Wait()
+
+	/* Does not retry a request the server rejects */
+	_ = sender.Send(hook, Event{Event: EVENT_BAN})
+	sender.Wait()
+
+	if len(receiver.bodies) != _ATTEMPTS+1 {
+		t.Errorf("Expected: %d attempts, Actual: %d", _ATTEMPTS+1, len(receiver.bodies))
+	}
+
+	letters := readDeadLetters(t, sender)
+	if len(letters) != 2 || letters[0].Url != server.URL ||
+		!strings.Contains(string(letters[1].Payload), EVENT_BAN) {
+
+		t.Errorf("Unexpected dead letters: %+v", letters)
+	}
+}
+
+func TestSenderQueueFull(t *testing.T) {
+	hook, _ := NewHook("https://example.com/hook", "")
+
+	/* No workers, so the one queued delivery waits until Close */
+	sender := newSender(filepath.Join(t.TempDir(), "dead.jsonl"), 0, 1)
+
+	if err := sender.Send(hook, Event{Event: EVENT_KICK}); err != nil {
+		t.Fatalf("Expected nil, but got an error: %v", err)
+	}
+	if err := sender.Send(hook, Event{Event: EVENT_BAN}); err == nil {
+		t.Errorf("Expected an error for a full queue, but got nil.")
+	}
+
+	letters := readDeadLetters(t, sender)
+	if len(letters) != 1 || !strings.Contains(string(letters[0].Payload), EVENT_BAN) {
+		t.Errorf("Unexpected dead letters: %+v", letters)
+	}
+
+	sender.Close()
+	if sender.Pending() != 0 {
+		t.Errorf("Expected: nothing pending after Close, Actual: %d", sender.Pending())
+	}
+	if letters := readDeadLetters(t, sender); len(letters) != 2 {
+		t.Errorf("Expected: the queued delivery dead lettered, Actual: %+v", letters)
+	}
+
+	if err := sender.Send(hook, Event{Event: EVENT_UNBAN}); err == nil {
+		t.Errorf("Expected an error sending after Close, but got nil.")
+	}
+}
+
+func TestSenderCloseWhileRetrying(t *testing.T) {
+	receiver := &testReceiver{statuses: []int{500}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook, _ := NewHook(server.URL, "")
+	sender := getTestSender(t)
+	sender.minBackoff = time.Hour
+	sender.maxBackoff = time.Hour
+	_ = sender.Send(hook, Event{Event: EVENT_KICK})
+
+	/* Wait for the first attempt, so the delivery is backing off */
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		receiver.mu.Lock()
+		attempts := len(receiver.bodies)
+		receiver.mu.Unlock()
+
+		if attempts > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected an attempt, but got none.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		sender.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close should not wait out the backoff.")
+	}
+
+	if letters := readDeadLetters(t, sender); len(letters) != 1 {
+		t.Errorf("Expected: the retrying delivery dead lettered, Actual: %+v", letters)
+	}
+}
+
+func signedRequest(secret string, timestamp time.Time, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(HEADER_TIMESTAMP, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(HEADER_SIGNATURE, Sign(secret, timestamp.Unix(), []byte(body)))
+
+	return req
+}
+
+func TestInbound(t *testing.T) {
+	messages := make(chan Message, 1)
+	handler := Handler("secret", messages)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, signedRequest("secret", time.Now(),
+		`{"from": "discord", "message": " hello "}`))
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected: %d, Actual: %d %s", http.StatusAccepted, w.Code, w.Body)
+	}
+
+	if message := <-messages; message.From != "discord" || message.Message != "hello" {
+		t.Errorf("Unexpected message: %+v", message)
+	}
+}
+
+func TestInboundRejected(t *testing.T) {
+	messages := make(chan Message)
+	handler := Handler("secret", messages)
+	body := `{"message": "hello"}`
+
+	for expected, req := range map[int]*http.Request{
+		http.StatusUnauthorized:       signedRequest("wrong", time.Now(), body),
+		http.StatusMethodNotAllowed:   httptest.NewRequest(http.MethodGet, "/", nil),
+		http.StatusBadRequest:         signedRequest("secret", time.Now(), `{"message": ""}`),
+		http.StatusServiceUnavailable: signedRequest("secret", time.Now(), body),
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("Expected: %d, Actual: %d %s", expected, w.Code, w.Body)
+		}
+	}
+
+	/* Replayed long after it was signed */
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, signedRequest("secret", time.Now().Add(-time.Hour), body))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected: %d, Actual: %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestInboundReplayed(t *testing.T) {
+	messages := make(chan Message, 1)
+	handler := Handler("secret", messages)
+	signed := time.Now()
+	req := func() *http.Request {
+		return signedRequest("secret", signed, `{"message": "hello"}`)
+	}
+
+	/* Refused while busy, so sending it again is not a replay */
+	messages <- Message{Message: "queued"}
+	for _, expected := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req())
+		if w.Code != expected {
+			t.Errorf("Expected: %d, Actual: %d %s", expected, w.Code, w.Body)
+		}
+	}
+	<-messages
+
+	for _, expected := range []int{http.StatusAccepted, http.StatusConflict} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req())
+		if w.Code != expected {
+			t.Errorf("Expected: %d, Actual: %d %s", expected, w.Code, w.Body)
+		}
+	}
+}
+
+func TestServeStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, "127.0.0.1:0", "/", "secret", make(chan Message))
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected: nil, Actual: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected: Serve to return once stopped, Actual: still serving")
+	}
+}